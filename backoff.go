@@ -0,0 +1,58 @@
+package main
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Backoff decides how long to wait before retry attempt (1 for the first
+// retry) of a failed request. Config.Backoff retries at once if unset.
+type Backoff interface {
+	Next(attempt int) time.Duration
+}
+
+// BackoffFunc adapts a function, e.g. decorrelated jitter, to Backoff.
+type BackoffFunc func(attempt int) time.Duration
+
+func (f BackoffFunc) Next(attempt int) time.Duration { return f(attempt) }
+
+// ConstantBackoff waits Delay before every retry.
+type ConstantBackoff struct {
+	Delay time.Duration
+}
+
+func (b ConstantBackoff) Next(int) time.Duration { return b.Delay }
+
+// LinearBackoff waits Step more before each retry than before the last.
+type LinearBackoff struct {
+	Step time.Duration
+}
+
+func (b LinearBackoff) Next(attempt int) time.Duration {
+	return time.Duration(max(attempt, 1)) * b.Step
+}
+
+// ExponentialBackoff waits a random duration up to Base doubled with every
+// retry and capped at Max, if set ("full jitter"), so the retries of
+// intervals that failed together spread out.
+type ExponentialBackoff struct {
+	Base time.Duration
+	Max  time.Duration
+}
+
+func (b ExponentialBackoff) Next(attempt int) time.Duration {
+	if b.Base <= 0 {
+		return 0
+	}
+	d := b.Base
+	for i := 1; i < attempt; i++ {
+		if b.Max > 0 && d >= b.Max || d > time.Duration(1<<62)/2 {
+			break
+		}
+		d *= 2
+	}
+	if b.Max > 0 {
+		d = min(d, b.Max)
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
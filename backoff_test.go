@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBackoffNext(t *testing.T) {
+	for attempt := 1; attempt <= 8; attempt++ {
+		if d := (ConstantBackoff{Delay: time.Second}).Next(attempt); d != time.Second {
+			t.Errorf("constant, attempt %d: %v", attempt, d)
+		}
+		if d := (LinearBackoff{Step: time.Second}).Next(attempt); d != time.Duration(attempt)*time.Second {
+			t.Errorf("linear, attempt %d: %v", attempt, d)
+		}
+		b := ExponentialBackoff{Base: time.Second, Max: 10 * time.Second}
+		ceiling := min(time.Second<<(attempt-1), b.Max)
+		for range 100 {
+			if d := b.Next(attempt); d < 0 || d > ceiling {
+				t.Fatalf("exponential, attempt %d: %v, want up to %v", attempt, d, ceiling)
+			}
+		}
+	}
+	if d := (ExponentialBackoff{}).Next(3); d != 0 {
+		t.Errorf("exponential without a base: %v", d)
+	}
+}
+
+// TestCustomBackoff fails an interval twice: its retries, through the
+// worker path, wait what a custom strategy says.
+func TestCustomBackoff(t *testing.T) {
+	catalog := testCatalog(3000)
+	var failures atomic.Int64
+	cfg := testConfig(DoerFunc(func(req *http.Request) (*http.Response, error) {
+		if lo := req.URL.Query().Get(minPriceParam); lo == "50000" && failures.Add(1) <= 2 {
+			return simulatedResponse(req, http.StatusServiceUnavailable, nil), nil
+		}
+		return catalog.Do(req)
+	}))
+	var mu sync.Mutex
+	var attempts []int
+	cfg.Backoff = BackoffFunc(func(attempt int) time.Duration {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts = append(attempts, attempt)
+		return time.Millisecond
+	})
+	cfg.RetryableStatus = []int{http.StatusServiceUnavailable}
+	cfg.Intervals = []Interval{{0, 50_000}, {50_000, maxPrice}}
+	res, err := testScraper(t, cfg).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Complete || len(res.Products) != catalog.Valid() {
+		t.Errorf("complete %v with %d products, want %d", res.Complete, len(res.Products), catalog.Valid())
+	}
+	if !slices.Equal(attempts, []int{1, 2}) {
+		t.Errorf("backoff asked for attempts %v, want 1 and 2", attempts)
+	}
+}
+
+// waitForWaiters blocks until clock has n timers or tickers pending.
+func waitForWaiters(t *testing.T, clock *fakeClock, n int) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); clock.Waiters() < n; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d waiters, want %d", clock.Waiters(), n)
+		}
+	}
+}
+
+func TestSleepFakeClock(t *testing.T) {
+	clock := newFakeClock()
+	done := make(chan error, 1)
+	go func() { done <- sleep(context.Background(), clock, 2*time.Second) }()
+	waitForWaiters(t, clock, 1)
+
+	clock.Advance(time.Second)
+	select {
+	case <-done:
+		t.Fatal("woke up a second early")
+	default:
+	}
+	clock.Advance(time.Second)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if n := clock.Waiters(); n != 0 {
+		t.Errorf("%d timers left", n)
+	}
+}
+
+// TestInitialRequestBackoff fails the initial request twice and checks it
+// waited the backoff of each retry, on the fake clock only.
+func TestInitialRequestBackoff(t *testing.T) {
+	clock := newFakeClock()
+	catalog := testCatalog(100)
+	var requests atomic.Int64
+	cfg := testConfig(DoerFunc(func(req *http.Request) (*http.Response, error) {
+		if requests.Add(1) <= 2 {
+			return &http.Response{StatusCode: http.StatusServiceUnavailable, Header: http.Header{}, Body: http.NoBody}, nil
+		}
+		return catalog.Do(req)
+	}))
+	cfg.Clock = clock
+	cfg.RateLimiter = unlimited{}
+	cfg.Backoff = LinearBackoff{Step: time.Minute}
+	cfg.RetryableStatus = []int{http.StatusServiceUnavailable}
+	s := testScraper(t, cfg)
+
+	start := clock.Now()
+	done := make(chan *Result, 1)
+	go func() {
+		res, err := s.Run(context.Background())
+		if err != nil {
+			t.Error(err)
+		}
+		done <- res
+	}()
+	// A minute before the first retry, then two before the second.
+	for _, d := range []time.Duration{time.Minute, 2 * time.Minute} {
+		waitForWaiters(t, clock, 1)
+		clock.Advance(d)
+	}
+	res := <-done
+	if res == nil || !res.Complete {
+		t.Fatal("run incomplete")
+	}
+	if waited := clock.Now().Sub(start); waited != 3*time.Minute {
+		t.Errorf("waited %v, want 3m", waited)
+	}
+	if n := requests.Load(); n < 3 {
+		t.Errorf("%d requests, want the initial one retried twice", n)
+	}
+}
+
+func TestLoggingFakeClock(t *testing.T) {
+	clock := newFakeClock()
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	d := Logging(logger, clock)(DoerFunc(func(req *http.Request) (*http.Response, error) {
+		clock.Advance(1500 * time.Millisecond)
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+	}))
+	req, _ := http.NewRequest(http.MethodGet, "http://shop.test/products", nil)
+	if _, err := d.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	var entry struct {
+		Duration time.Duration `json:"duration"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry.Duration != 1500*time.Millisecond {
+		t.Errorf("logged duration %v, want 1.5s", entry.Duration)
+	}
+}
+
+func TestReportFakeClock(t *testing.T) {
+	clock := newFakeClock()
+	cfg := testConfig(nil)
+	cfg.Clock = clock
+	started := clock.Now()
+	clock.Advance(time.Hour)
+	r := newReport(cfg, started, nil, nil, nil)
+	if !r.Started.Equal(started) || !r.Finished.Equal(started.Add(time.Hour)) {
+		t.Errorf("report from %v to %v, want an hour from %v", r.Started, r.Finished, started)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+)
+
+// probeMaxPriceBound tells whether the API's max price bound is exclusive,
+// by asking for a product's own price as both bounds, assuming the min
+// bound is inclusive. It reports the price probed, and ok false when the
+// price range has no product to probe with.
+func (s *Scraper) probeMaxPriceBound(ctx context.Context) (exclusive bool, price float32, ok bool, err error) {
+	sample, _, err := s.probe(ctx, s.cfg.priceRange(), 1, nil)
+	if err != nil || len(sample.Products) == 0 {
+		return false, 0, false, err
+	}
+	price = sample.Products[0].Price
+	at, _, err := s.probe(ctx, Interval{price, price}, 1, nil)
+	if err != nil {
+		return false, 0, false, err
+	}
+	return at.Total == 0, price, true, nil
+}
+
+// detectMaxPriceBound sets Config.MaxPriceExclusive as the API behaves,
+// for Config.DetectMaxPriceBound.
+func (s *Scraper) detectMaxPriceBound(ctx context.Context) error {
+	exclusive, price, ok, err := s.probeMaxPriceBound(ctx)
+	if err != nil {
+		return fmt.Errorf("detecting the max price bound: %w", err)
+	}
+	if !ok {
+		s.cfg.Logger.Info("max price bound not detected, no product to probe with", "exclusive", s.cfg.MaxPriceExclusive)
+		return nil
+	}
+	if exclusive != s.cfg.MaxPriceExclusive {
+		s.cfg.Logger.Info("detected the max price bound", "exclusive", exclusive, "price", price)
+	}
+	s.cfg.MaxPriceExclusive = exclusive
+	return nil
+}
+
+// dropAboveMaxPrice leaves out the products priced above the price range
+// that the top interval, widened for Config.MaxPriceExclusive, may return.
+func (s *Scraper) dropAboveMaxPrice(interval Interval, products []Product) []Product {
+	top := s.cfg.priceRange()[1]
+	if !s.cfg.MaxPriceExclusive || !sameBound(interval[1], top, s.cfg.PricePrecision) {
+		return products
+	}
+	n := len(products)
+	products = slices.DeleteFunc(products, func(p Product) bool {
+		return p.Price > top && !sameBound(p.Price, top, s.cfg.PricePrecision)
+	})
+	s.aboveMaxPrice.Add(int64(n - len(products)))
+	return products
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen fails the requests a CircuitBreaker doesn't send. The
+// scraper doesn't retry them: their intervals go to Result.Failed.
+var ErrCircuitOpen = errors.New("circuit open")
+
+// CircuitBreaker stops sending requests after threshold consecutive
+// failures (network errors and 5xx statuses), failing them with
+// ErrCircuitOpen for cooldown. A single request then probes the API: its
+// success closes the circuit, its failure opens it again. A nil clock is
+// the system clock. onRecover, if not nil, is called when a probe closes
+// the circuit, e.g. to ramp the rate up again with Scraper.RestartWarmUp.
+func CircuitBreaker(threshold int, cooldown time.Duration, clock Clock, onRecover func()) Middleware {
+	if clock == nil {
+		clock = systemClock{}
+	}
+	b := &breaker{threshold: threshold, cooldown: cooldown, clock: clock, onRecover: onRecover}
+	return func(next Doer) Doer {
+		return DoerFunc(func(req *http.Request) (*http.Response, error) {
+			probe, err := b.allow()
+			if err != nil {
+				return nil, err
+			}
+			resp, err := next.Do(req)
+			switch {
+			case req.Context().Err() == nil:
+				if b.record(err != nil || resp.StatusCode >= 500) && b.onRecover != nil {
+					b.onRecover()
+				}
+			case probe:
+				// A cancelled request says nothing about the API, but a
+				// cancelled probe must not hold the circuit half-open.
+				b.abandon()
+			}
+			return resp, err
+		})
+	}
+}
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen // a probe is in flight
+)
+
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+	clock     Clock
+	onRecover func()
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int // consecutive, while closed
+	openedAt time.Time
+}
+
+// allow tells whether a request may go, letting the first one after the
+// cooldown through as the probe.
+func (b *breaker) allow() (probe bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		left := b.cooldown - b.clock.Now().Sub(b.openedAt)
+		if left > 0 {
+			return false, fmt.Errorf("%w for another %v", ErrCircuitOpen, left.Round(time.Millisecond))
+		}
+		b.state = breakerHalfOpen
+		return true, nil
+	case breakerHalfOpen:
+		return false, fmt.Errorf("%w while probing", ErrCircuitOpen)
+	}
+	return false, nil
+}
+
+// abandon opens the circuit again for another cooldown after the probe was
+// cancelled or timed out before telling anything.
+func (b *breaker) abandon() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == breakerHalfOpen {
+		b.state = breakerOpen
+		b.openedAt = b.clock.Now()
+	}
+}
+
+func (b *breaker) record(failed bool) (recovered bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch {
+	case !failed:
+		recovered = b.state == breakerHalfOpen
+		b.state = breakerClosed
+		b.failures = 0
+	case b.state == breakerHalfOpen:
+		b.state = breakerOpen
+		b.openedAt = b.clock.Now()
+	case b.state == breakerClosed:
+		b.failures++
+		if b.failures >= b.threshold {
+			b.state = breakerOpen
+			b.openedAt = b.clock.Now()
+			b.failures = 0
+		}
+	}
+	return recovered
+}
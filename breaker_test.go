@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerProbeTimeout(t *testing.T) {
+	clock := newFakeClock()
+	fail := true
+	var next DoerFunc = func(req *http.Request) (*http.Response, error) {
+		if req.Context().Err() != nil {
+			return nil, req.Context().Err()
+		}
+		if fail {
+			return &http.Response{StatusCode: http.StatusServiceUnavailable, Body: http.NoBody}, nil
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	}
+	recovered := 0
+	d := CircuitBreaker(2, time.Minute, clock, func() { recovered++ })(next)
+	do := func(ctx context.Context) error {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "http://shop.test/", nil)
+		_, err := d.Do(req)
+		return err
+	}
+
+	for range 2 {
+		if err := do(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := do(context.Background()); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("after the threshold: %v, want ErrCircuitOpen", err)
+	}
+
+	clock.Advance(time.Minute)
+	timedOut, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := do(timedOut); !errors.Is(err, context.Canceled) {
+		t.Fatalf("probe: %v, want context.Canceled", err)
+	}
+	if err := do(context.Background()); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("after the cancelled probe: %v, want ErrCircuitOpen", err)
+	}
+
+	clock.Advance(time.Minute)
+	fail = false
+	if err := do(context.Background()); err != nil {
+		t.Fatalf("second probe: %v", err)
+	}
+	if err := do(context.Background()); err != nil {
+		t.Fatalf("after recovering: %v", err)
+	}
+	if recovered != 1 {
+		t.Errorf("onRecover called %d times, want once", recovered)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	clock := newFakeClock()
+	sent := 0
+	fail := true
+	var next DoerFunc = func(req *http.Request) (*http.Response, error) {
+		sent++
+		if fail {
+			return nil, errors.New("connection refused")
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	}
+	d := CircuitBreaker(3, time.Minute, clock, nil)(next)
+	do := func() error {
+		req, _ := http.NewRequest(http.MethodGet, "http://shop.test/", nil)
+		_, err := d.Do(req)
+		return err
+	}
+
+	// Failures have to be consecutive.
+	do()
+	do()
+	fail = false
+	if err := do(); err != nil {
+		t.Fatal(err)
+	}
+	fail = true
+	for i := range 3 {
+		if err := do(); errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("circuit open after a success and %d failures", i+1)
+		}
+	}
+
+	// Open, nothing is sent until the cooldown is over.
+	sent = 0
+	for range 5 {
+		if err := do(); !errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("open circuit: %v, want ErrCircuitOpen", err)
+		}
+	}
+	clock.Advance(time.Minute / 2)
+	if err := do(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("mid cooldown: %v, want ErrCircuitOpen", err)
+	}
+	if sent != 0 {
+		t.Errorf("%d requests sent while open", sent)
+	}
+
+	// A failed probe opens it again.
+	clock.Advance(time.Minute / 2)
+	if err := do(); err == nil || errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("probe: %v, want the failure", err)
+	}
+	if err := do(); !errors.Is(err, ErrCircuitOpen) || sent != 1 {
+		t.Fatalf("after the failed probe: %v with %d sent, want ErrCircuitOpen and the probe only", err, sent)
+	}
+}
+
+// TestCircuitBreakerRun scrapes an API that is down: once the circuit
+// opens, intervals fail without any more requests.
+func TestCircuitBreakerRun(t *testing.T) {
+	var sent atomic.Int64
+	cfg := testConfig(DoerFunc(func(req *http.Request) (*http.Response, error) {
+		sent.Add(1)
+		return simulatedResponse(req, http.StatusServiceUnavailable, nil), nil
+	}))
+	// One at a time, as no more may be in flight when it opens.
+	cfg.MaxWorkers = 1
+	cfg.Middleware = []Middleware{CircuitBreaker(2, time.Hour, nil, nil)}
+	cfg.Backoff = BackoffFunc(func(int) time.Duration { return time.Millisecond })
+	cfg.RetryableStatus = []int{http.StatusServiceUnavailable}
+	cfg.Intervals = []Interval{{0, 25_000}, {25_000, 50_000}, {50_000, 75_000}, {75_000, maxPrice}}
+	res, err := testScraper(t, cfg).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Complete || len(res.Failed) != len(cfg.Intervals) {
+		t.Fatalf("complete %v with %d failed intervals, want all %d", res.Complete, len(res.Failed), len(cfg.Intervals))
+	}
+	open := 0
+	for _, f := range res.Failed {
+		if errors.Is(f.Err, ErrCircuitOpen) {
+			open++
+		}
+	}
+	if open == 0 || sent.Load() != 2 {
+		t.Errorf("%d intervals failed on the open circuit, %d requests sent, want some and 2", open, sent.Load())
+	}
+}
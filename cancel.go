@@ -0,0 +1,55 @@
+package main
+
+// CancelInterval abandons i and everything split from it, which lies
+// within i: those intervals are no longer requested and end up uncovered,
+// and pending intervals overlapping i are trimmed to what lies outside.
+// Requests in flight complete, but what they return is dropped rather than
+// paged through or split.
+func (s *Scraper) CancelInterval(i Interval) {
+	s.cancelled.add(i)
+	s.cfg.Logger.Info("interval cancelled", "interval", i)
+}
+
+// isCancelled reports whether i lies within the cancelled intervals.
+func (s *Scraper) isCancelled(i Interval) bool {
+	return len(s.cancelled.remainder(i)) == 0
+}
+
+// skipCancelled gives up on interval, reporting whether it was cancelled.
+func (s *Scraper) skipCancelled(interval Interval) bool {
+	if !s.isCancelled(interval) {
+		return false
+	}
+	s.cancelledIntervals.Add(1)
+	s.addUncovered(interval)
+	s.tree.finish(interval, nodeCancelled, 0)
+	return true
+}
+
+// trimCancelled cuts the cancelled intervals out of rest, the parts of
+// interval left to request, like trimExcluded does with the plan. The
+// parts cut end up uncovered, and interval is given up on if nothing is
+// left of it.
+func (s *Scraper) trimCancelled(interval Interval, rest []Interval) []Interval {
+	p := s.cfg.PricePrecision
+	trimmed := make([]Interval, 0, len(rest))
+	for _, r := range rest {
+		left := s.cancelled.remainder(r)
+		lo := r[0]
+		for _, l := range left {
+			if !sameBound(lo, l[0], p) {
+				s.addUncovered(Interval{lo, l[0]})
+			}
+			lo = l[1]
+		}
+		if !sameBound(lo, r[1], p) {
+			s.addUncovered(Interval{lo, r[1]})
+		}
+		trimmed = append(trimmed, left...)
+	}
+	if len(trimmed) == 0 {
+		s.cancelledIntervals.Add(1)
+		s.tree.finish(interval, nodeCancelled, 0)
+	}
+	return trimmed
+}
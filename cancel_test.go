@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestCancelInterval(t *testing.T) {
+	catalog := testCatalog(20_000)
+	band := Interval{500, 2500}
+	var s *Scraper
+	var sent int
+	var after []Interval
+	// One worker, so that no request is on its way when the band is
+	// cancelled, from within the third.
+	cfg := testConfig(DoerFunc(func(req *http.Request) (*http.Response, error) {
+		switch sent++; {
+		case sent == 3:
+			s.CancelInterval(band)
+		case sent > 3:
+			q := req.URL.Query()
+			lo, _ := strconv.ParseFloat(q.Get(minPriceParam), 32)
+			hi, _ := strconv.ParseFloat(q.Get(maxPriceParam), 32)
+			after = append(after, Interval{float32(lo), float32(hi)})
+		}
+		return catalog.Do(req)
+	}))
+	cfg.MaxWorkers = 1
+	s = testScraper(t, cfg)
+	res, err := s.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The last is the probe of the final total, over the whole range.
+	if len(after) < 2 {
+		t.Fatal("no request after cancelling")
+	}
+	for _, i := range after[:len(after)-1] {
+		if i[0] < band[1] && i[1] > band[0] && !sameBound(i[0], band[1], cfg.PricePrecision) && !sameBound(i[1], band[0], cfg.PricePrecision) {
+			t.Errorf("requested %v after cancelling %v", i, band)
+		}
+	}
+	if res.Complete || len(res.Uncovered) == 0 {
+		t.Errorf("complete %v, uncovered %v, want the band uncovered", res.Complete, res.Uncovered)
+	}
+	for _, u := range res.Uncovered {
+		if u[0] < band[0] || u[1] > band[1] {
+			t.Errorf("uncovered %v, outside %v", u, band)
+		}
+	}
+	collected := map[ProductID]bool{}
+	for _, p := range res.Products {
+		collected[p.ID] = true
+	}
+	for _, p := range catalog.products {
+		if validPrice(p) && (p.Price < band[0] || p.Price >= band[1]) && !collected[p.ID] {
+			t.Errorf("product %s at %v, outside %v, not collected", p.ID, p.Price, band)
+		}
+	}
+}
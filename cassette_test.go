@@ -0,0 +1,65 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"testing"
+)
+
+// TestRecordReplay records a run against a server, then replays it once
+// the server is gone.
+func TestRecordReplay(t *testing.T) {
+	catalog := testCatalog(5000)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp, err := catalog.Do(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer resp.Body.Close()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+	}))
+	dir := t.TempDir()
+
+	scrape := func(client Doer) *Result {
+		t.Helper()
+		s, err := NewScraper(testConfig(client), WithBaseURL(srv.URL+"/products"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := s.Run(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		slices.SortFunc(res.Products, func(a, b Product) int { return cmp.Compare(a.ID, b.ID) })
+		return res
+	}
+	recorded := scrape(NewRecorder(dir, srv.Client()))
+	srv.Close()
+	if files, _ := os.ReadDir(dir); len(files) == 0 {
+		t.Fatal("nothing recorded")
+	}
+
+	replayed := scrape(NewReplayer(dir))
+	if !recorded.Complete || !replayed.Complete || !slices.Equal(recorded.Products, replayed.Products) {
+		t.Errorf("recorded %d products (complete %v), replayed %d (complete %v)",
+			len(recorded.Products), recorded.Complete, len(replayed.Products), replayed.Complete)
+	}
+	if len(recorded.Products) != catalog.Valid() {
+		t.Errorf("recorded %d products, want %d", len(recorded.Products), catalog.Valid())
+	}
+
+	// Nothing is made up for a request that wasn't recorded.
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/products?minPrice=1&maxPrice=2", nil)
+	if _, err := NewReplayer(dir).Do(req); !errors.Is(err, errNotRecorded) {
+		t.Errorf("unrecorded request: err %v, want %v", err, errNotRecorded)
+	}
+}
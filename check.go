@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// checkTimeout bounds each step of Check.
+const checkTimeout time.Duration = 10 * time.Second
+
+// Check walks through what a run needs from the endpoint, one step at a
+// time, and writes whether each passed to w: DNS resolution, the TLS
+// handshake, the robots.txt verdict, a request for a tiny interval with
+// our credentials, the API version negotiated, the schema of its response
+// and the rate-limit headers. DNS and TLS go through the scraper's own
+// resolver and dialer, see Config.Resolver and Config.LocalAddrs. No real
+// work is queued. It returns an error if any step failed.
+func (s *Scraper) Check(ctx context.Context, w io.Writer) error {
+	cfg := s.cfg
+	host := cfg.BaseURL.Hostname()
+	port := cfg.BaseURL.Port()
+	if port == "" {
+		port = "80"
+		if cfg.BaseURL.Scheme == "https" {
+			port = "443"
+		}
+	}
+	root := cfg.priceRange()
+	tiny := Interval{root[0], root[0] + min(1, width(root))}
+
+	var res *Response
+	var header http.Header
+	steps := []struct {
+		name string
+		run  func(ctx context.Context) (string, error)
+	}{
+		{"dns", func(ctx context.Context) (string, error) {
+			addrs, err := s.lookupHost(ctx, host)
+			return strings.Join(addrs, ", "), err
+		}},
+		{"tls", func(ctx context.Context) (string, error) {
+			if cfg.BaseURL.Scheme != "https" {
+				return "skipped, plain http", nil
+			}
+			raw, err := s.dial(ctx, "tcp", net.JoinHostPort(host, port))
+			if err != nil {
+				return "", err
+			}
+			conn := tls.Client(raw, &tls.Config{ServerName: host})
+			defer conn.Close()
+			if err := conn.HandshakeContext(ctx); err != nil {
+				return "", err
+			}
+			return tls.VersionName(conn.ConnectionState().Version), nil
+		}},
+		{"robots.txt", func(ctx context.Context) (string, error) {
+			v, err := s.robots(ctx)
+			switch {
+			case err != nil && cfg.RespectRobots:
+				return "", err
+			case err != nil:
+				return fmt.Sprintf("unreadable (%v), not respected anyway", err), nil
+			case !v.Allowed && cfg.RespectRobots && !cfg.IgnoreRobots:
+				return "", fmt.Errorf("%w: %s", errRobotsDisallowed, v)
+			}
+			return v.String(), nil
+		}},
+		{"request", func(ctx context.Context) (string, error) {
+			var err error
+			res, header, err = s.probe(ctx, tiny, 1, nil)
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%v answered", tiny), nil
+		}},
+		{"api version", func(ctx context.Context) (string, error) {
+			accept := cfg.accept()
+			switch {
+			case header == nil:
+				return "", errors.New("no response to check")
+			case accept == "":
+				return fmt.Sprintf("not negotiated, answered %q", header.Get("Content-Type")), nil
+			}
+			if err := cfg.checkContentType(header); err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("asked for %q, answered %q", accept, header.Get("Content-Type")), nil
+		}},
+		{"schema", func(ctx context.Context) (string, error) {
+			switch {
+			case res == nil:
+				return "", errors.New("no response to check")
+			case res.Total < 0 || res.Count < 0:
+				return "", fmt.Errorf("negative total %d or count %d", res.Total, res.Count)
+			case len(res.Products) > 0 && res.Products[0].ID == "":
+				return "", errors.New("products have no id")
+			}
+			return fmt.Sprintf("total %d, count %d", res.Total, res.Count), nil
+		}},
+		{"boundaries", func(ctx context.Context) (string, error) {
+			exclusive, price, ok, err := s.probeMaxPriceBound(ctx)
+			if err != nil {
+				return "", err
+			}
+			if !ok {
+				return "skipped, no product to probe with", nil
+			}
+			switch {
+			case exclusive && !cfg.MaxPriceExclusive && !cfg.DetectMaxPriceBound:
+				return "", fmt.Errorf("nothing returned at exactly %v, the max price bound looks exclusive: set -max-price-exclusive", price)
+			case !exclusive && cfg.MaxPriceExclusive && !cfg.DetectMaxPriceBound:
+				return "", fmt.Errorf("returned at exactly %v, the max price bound looks inclusive: unset -max-price-exclusive", price)
+			case exclusive:
+				return "max price bound exclusive", nil
+			}
+			return "max price bound inclusive", nil
+		}},
+		{"rate-limit headers", func(ctx context.Context) (string, error) {
+			if header == nil {
+				return "", errors.New("no response to check")
+			}
+			remaining := header.Get(cfg.RateRemainingHeader)
+			reset := header.Get(cfg.RateResetHeader)
+			if remaining == "" && reset == "" {
+				return "not sent, pacing relies on -rps only", nil
+			}
+			if _, err := strconv.Atoi(remaining); err != nil {
+				return "", fmt.Errorf("%s: %q", cfg.RateRemainingHeader, remaining)
+			}
+			if _, err := strconv.ParseInt(reset, 10, 64); err != nil {
+				return "", fmt.Errorf("%s: %q", cfg.RateResetHeader, reset)
+			}
+			return fmt.Sprintf("%s remaining, reset %s", remaining, reset), nil
+		}},
+	}
+
+	var failed []string
+	for _, step := range steps {
+		stepCtx, cancel := context.WithTimeout(ctx, checkTimeout)
+		detail, err := step.run(stepCtx)
+		cancel()
+		if err != nil {
+			failed = append(failed, step.name)
+			fmt.Fprintf(w, "FAIL %s: %v\n", step.name, err)
+			continue
+		}
+		fmt.Fprintf(w, "PASS %s: %s\n", step.name, detail)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("check failed: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestCheckResolvesLikeTheClient resolves a made-up host through the
+// scraper's DNS cache, which the system resolver can't.
+func TestCheckResolvesLikeTheClient(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	cfg := testConfig(nil)
+	cfg.DNSCacheTTL = time.Minute
+	s, err := NewScraper(cfg, WithBaseURL("http://shop.test:"+port+"/products"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.dns.lookup = func(ctx context.Context, host string) ([]string, error) {
+		if host != "shop.test" {
+			return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+		}
+		return []string{"127.0.0.1"}, nil
+	}
+
+	var out bytes.Buffer
+	s.Check(context.Background(), &out)
+	if !strings.Contains(out.String(), "PASS dns: 127.0.0.1") {
+		t.Errorf("dns step didn't go through the cache:\n%s", out.String())
+	}
+	conn, err := s.dial(context.Background(), "tcp", "shop.test:"+port)
+	if err != nil {
+		t.Fatalf("dialing through the cache: %v", err)
+	}
+	conn.Close()
+}
+
+// TestCheckIgnoresOutputs runs -check with an output, and with one that
+// can't be opened: the self-test runs all the same, and creates neither.
+func TestCheckIgnoresOutputs(t *testing.T) {
+	dir := t.TempDir()
+	out, bad := filepath.Join(dir, "products.ndjson"), filepath.Join(dir, "missing", "products.ndjson")
+	stdout, _, restore := capture(t)
+	run(context.Background(), []string{"-simulate", "100", "-rps", "1e6", "-quiet", "-check", "-out", out, "-out", bad})
+	restore()
+	if !strings.Contains(stdout.String(), "dns") {
+		t.Errorf("no check run:\n%s", stdout.String())
+	}
+	if _, err := os.Stat(out); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("output created: %v", err)
+	}
+}
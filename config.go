@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// The CLI takes each setting from, in order of precedence: the command
+// line, the environment (SCRAPER_ and the flag name in upper snake case,
+// e.g. SCRAPER_MAX_WORKERS), the -config file, and the flag's default.
+const envPrefix string = "SCRAPER_"
+
+// envAliases are shorter names for some variables.
+var envAliases = map[string]string{
+	"SCRAPER_WORKERS": "max-workers",
+	"SCRAPER_OUTPUT":  "out",
+}
+
+const (
+	sourceDefault = "default"
+	sourceFlag    = "flag"
+	sourceEnv     = "env"
+	sourceConfig  = "config"
+)
+
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// applyEnvAndConfig sets the flags of fs not given on the command line from
+// the environment or the JSON config file at configPath (if not empty), an
+// object of flag names to values or, for repeatable flags, arrays of values.
+// It returns where each flag's value came from.
+func applyEnvAndConfig(fs *flag.FlagSet, configPath string) (map[string]string, error) {
+	sources := map[string]string{}
+	fs.VisitAll(func(f *flag.Flag) { sources[f.Name] = sourceDefault })
+	fs.Visit(func(f *flag.Flag) { sources[f.Name] = sourceFlag })
+
+	// Flag names to the variable setting them.
+	env := map[string]string{}
+	for alias, name := range envAliases {
+		if _, ok := os.LookupEnv(alias); ok {
+			env[name] = alias
+		}
+	}
+	fs.VisitAll(func(f *flag.Flag) {
+		if _, ok := os.LookupEnv(envName(f.Name)); ok {
+			env[f.Name] = envName(f.Name)
+		}
+	})
+
+	var config map[string]json.RawMessage
+	if configPath != "" {
+		data, err := os.ReadFile(configPath)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &config); err != nil {
+			return nil, fmt.Errorf("%s: %w", configPath, err)
+		}
+	}
+	for name := range config {
+		if fs.Lookup(name) == nil {
+			return nil, fmt.Errorf("%s: unknown setting %q", configPath, name)
+		}
+	}
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || sources[f.Name] == sourceFlag {
+			return
+		}
+		if name, ok := env[f.Name]; ok {
+			v := os.Getenv(name)
+			if serr := f.Value.Set(v); serr != nil {
+				err = fmt.Errorf("invalid %s=%q: %w", name, v, serr)
+				return
+			}
+			sources[f.Name] = sourceEnv
+			return
+		}
+		if raw, ok := config[f.Name]; ok {
+			values, cerr := configValues(raw)
+			if cerr == nil {
+				for _, v := range values {
+					if cerr = f.Value.Set(v); cerr != nil {
+						break
+					}
+				}
+			}
+			if cerr != nil {
+				err = fmt.Errorf("%s: invalid %q: %w", configPath, f.Name, cerr)
+				return
+			}
+			sources[f.Name] = sourceConfig
+		}
+	})
+	return sources, err
+}
+
+// configValues reads a config file value as the flag strings to set.
+func configValues(raw json.RawMessage) ([]string, error) {
+	var list []json.RawMessage
+	if json.Unmarshal(raw, &list) != nil {
+		list = []json.RawMessage{raw}
+	}
+	values := make([]string, 0, len(list))
+	for _, item := range list {
+		var s string
+		if json.Unmarshal(item, &s) == nil {
+			values = append(values, s)
+			continue
+		}
+		// Numbers and booleans, as written.
+		var v any
+		if err := json.Unmarshal(item, &v); err != nil {
+			return nil, err
+		}
+		switch v.(type) {
+		case float64, bool:
+			values = append(values, string(item))
+		default:
+			return nil, fmt.Errorf("unsupported value %s", item)
+		}
+	}
+	return values, nil
+}
+
+// maskedValue is a flag's value fit for logging: secrets are masked and
+// URLs lose their passwords.
+func maskedValue(f *flag.Flag) string {
+	v := f.Value.String()
+	name := strings.ToLower(f.Name)
+	for _, s := range []string{"key", "token", "secret", "password", "auth"} {
+		if strings.Contains(name, s) && v != "" {
+			return "****"
+		}
+	}
+	if u, err := url.Parse(v); err == nil && u.User != nil {
+		return u.Redacted()
+	}
+	return v
+}
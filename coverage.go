@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"sync"
+)
+
+// coverage tracks the price ranges already fetched in full, as sorted,
+// non-overlapping intervals. Adjacent ranges are merged.
+type coverage struct {
+	ranges    []Interval
+	claims    []claim // being fetched, see claim
+	precision int     // slivers narrower than this are ignored
+	mu        sync.Mutex
+}
+
+type claim struct {
+	interval Interval
+	released chan struct{}
+}
+
+func (c *coverage) add(i Interval) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	start := sort.Search(len(c.ranges), func(k int) bool { return c.ranges[k][1] >= i[0] })
+	end := start
+	for end < len(c.ranges) && c.ranges[end][0] <= i[1] {
+		i[0] = min(i[0], c.ranges[end][0])
+		i[1] = max(i[1], c.ranges[end][1])
+		end++
+	}
+	c.ranges = slices.Replace(c.ranges, start, end, i)
+}
+
+// remainder returns the parts of i not covered yet, in order.
+func (c *coverage) remainder(i Interval) []Interval {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rest(i)
+}
+
+// claim returns the parts of i not covered yet, like remainder, and claims
+// the first until release is called: a claim overlapping another waits
+// for it to be released, so no price is fetched by two workers at once.
+// Once ctx is done it stops waiting, claiming nothing.
+func (c *coverage) claim(ctx context.Context, i Interval) (rest []Interval, release func()) {
+	for {
+		c.mu.Lock()
+		k := slices.IndexFunc(c.claims, func(cl claim) bool { return overlaps(cl.interval, i, c.precision) })
+		if k < 0 {
+			break
+		}
+		released := c.claims[k].released
+		c.mu.Unlock()
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return c.remainder(i), func() {}
+		}
+	}
+	defer c.mu.Unlock()
+
+	rest = c.rest(i)
+	if len(rest) == 0 {
+		return nil, func() {}
+	}
+	cl := claim{interval: rest[0], released: make(chan struct{})}
+	c.claims = append(c.claims, cl)
+	return rest, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.claims = slices.DeleteFunc(c.claims, func(x claim) bool { return x.released == cl.released })
+		close(cl.released)
+	}
+}
+
+// rest is remainder, called with mu held.
+func (c *coverage) rest(i Interval) []Interval {
+	var rest []Interval
+	lo := i[0]
+	k := sort.Search(len(c.ranges), func(k int) bool { return c.ranges[k][1] > i[0] })
+	for ; k < len(c.ranges) && c.ranges[k][0] < i[1]; k++ {
+		if c.ranges[k][0] > lo && !sameBound(lo, c.ranges[k][0], c.precision) {
+			rest = append(rest, Interval{lo, c.ranges[k][0]})
+		}
+		lo = max(lo, c.ranges[k][1])
+	}
+	if lo < i[1] && !sameBound(lo, i[1], c.precision) {
+		rest = append(rest, Interval{lo, i[1]})
+	}
+	return rest
+}
+
+// overlaps reports whether a and b share more than a sliver at precision.
+func overlaps(a, b Interval, precision int) bool {
+	lo, hi := max(a[0], b[0]), min(a[1], b[1])
+	return lo < hi && !sameBound(lo, hi, precision)
+}
+
+// width is the total width covered.
+func (c *coverage) width() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var w float64
+	for _, r := range c.ranges {
+		w += float64(width(r))
+	}
+	return w
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// TestNoPointFetchedTwice scrapes random catalogs from random, overlapping
+// intervals, as resumed runs and retry passes hand them over, to several
+// workers: no price may be in two accepted responses.
+func TestNoPointFetchedTwice(t *testing.T) {
+	for seed := range int64(20) {
+		t.Run(fmt.Sprint("seed=", seed), func(t *testing.T) {
+			rng := rand.New(rand.NewSource(seed))
+			_, products := randomProducts(rng)
+			catalog := NewCatalog(products, (&Config{}).catalogParams())
+
+			var mu sync.Mutex
+			var accepted []Interval
+			cfg := testConfig(DoerFunc(func(req *http.Request) (*http.Response, error) {
+				resp, err := catalog.Do(req)
+				q := req.URL.Query()
+				if err != nil || resp.StatusCode != http.StatusOK || q.Has(offsetParam) {
+					return resp, err
+				}
+				var res Response
+				if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+					return nil, err
+				}
+				lo, _ := strconv.ParseFloat(q.Get(minPriceParam), 32)
+				hi, _ := strconv.ParseFloat(q.Get(maxPriceParam), 32)
+				// The probes of the total are over the whole range, planned
+				// intervals never are.
+				i := Interval{float32(lo), float32(hi)}
+				if len(res.Products) < apiLimit && res.Total <= len(res.Products) && i != (Interval{0, maxPrice}) {
+					mu.Lock()
+					accepted = append(accepted, i)
+					mu.Unlock()
+				}
+				body, err := json.Marshal(res)
+				if err != nil {
+					return nil, err
+				}
+				return simulatedResponse(req, http.StatusOK, body), nil
+			}))
+			cfg.PaginateBelow = 1
+			cfg.MaxWorkers = 4
+			// The whole range, and as many random pieces of it again.
+			cfg.Intervals = planIntervals(max(catalog.Len(), 2*apiLimit), apiLimit, Interval{0, maxPrice})
+			for range len(cfg.Intervals) + rng.Intn(10) {
+				lo := float32(rng.Intn(int(maxPrice)*100)) / 100
+				hi := lo + float32(rng.Intn(int(maxPrice)*10))/100
+				cfg.Intervals = append(cfg.Intervals, Interval{lo, min(hi, maxPrice)})
+			}
+			rng.Shuffle(len(cfg.Intervals), func(i, j int) { cfg.Intervals[i], cfg.Intervals[j] = cfg.Intervals[j], cfg.Intervals[i] })
+
+			res, err := testScraper(t, cfg).Run(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !res.Complete || len(res.Products) != catalog.Valid() {
+				t.Errorf("complete %v with %d products, want %d", res.Complete, len(res.Products), catalog.Valid())
+			}
+			slices.SortFunc(accepted, func(a, b Interval) int { return cmp.Compare(a[0], b[0]) })
+			for i := 1; i < len(accepted); i++ {
+				if accepted[i][0] < accepted[i-1][1] && !sameBound(accepted[i][0], accepted[i-1][1], cfg.PricePrecision) {
+					t.Errorf("%v and %v both accepted", accepted[i-1], accepted[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRemainder(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		covered []Interval
+		i       Interval
+		want    []Interval
+	}{
+		{"nothing covered", nil, Interval{10, 20}, []Interval{{10, 20}}},
+		{"contained", []Interval{{0, 100}}, Interval{10, 20}, nil},
+		{"equal", []Interval{{10, 20}}, Interval{10, 20}, nil},
+		{"containing", []Interval{{40, 60}}, Interval{0, 100}, []Interval{{0, 40}, {60, 100}}},
+		{"adjacent below", []Interval{{0, 10}}, Interval{10, 20}, []Interval{{10, 20}}},
+		{"adjacent above", []Interval{{20, 30}}, Interval{10, 20}, []Interval{{10, 20}}},
+		{"overlapping below", []Interval{{0, 15}}, Interval{10, 20}, []Interval{{15, 20}}},
+		{"overlapping above", []Interval{{15, 30}}, Interval{10, 20}, []Interval{{10, 15}}},
+		{"several within", []Interval{{12, 14}, {16, 18}}, Interval{10, 20}, []Interval{{10, 12}, {14, 16}, {18, 20}}},
+		{"adjacent ranges", []Interval{{0, 10}, {10, 20}}, Interval{5, 25}, []Interval{{20, 25}}},
+		{"sliver left", []Interval{{0, 19.999}}, Interval{10, 20}, nil},
+		{"sliver gap", []Interval{{10, 14.999}, {15, 20}}, Interval{10, 20}, nil},
+	} {
+		c := &coverage{precision: 2}
+		for _, r := range tc.covered {
+			c.add(r)
+		}
+		if got := c.remainder(tc.i); !slices.Equal(got, tc.want) {
+			t.Errorf("%s: remainder of %v is %v, want %v", tc.name, tc.i, got, tc.want)
+		}
+	}
+
+	// Adjacent and overlapping ranges are merged as they are added.
+	c := &coverage{precision: 2}
+	for _, r := range []Interval{{10, 20}, {30, 40}, {20, 30}, {35, 50}} {
+		c.add(r)
+	}
+	if want := []Interval{{10, 50}}; !slices.Equal(c.ranges, want) {
+		t.Errorf("covered %v, want %v", c.ranges, want)
+	}
+	if w := c.width(); w != 40 {
+		t.Errorf("width %v, want 40", w)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// sinkFailed applies Config.SinkFailure to a product the sink couldn't
+// write. Only the collector calls it.
+func (s *Scraper) sinkFailed(p Product, err error) {
+	if s.cfg.SinkFailure == SinkFailFatal {
+		s.fatal(fmt.Errorf("writing product %s: %w", p.ID, err))
+		return
+	}
+
+	s.sinkFailures.Add(1)
+	if s.deadProducts == nil {
+		var ferr error
+		s.deadProducts, ferr = openDeadLetters(s.cfg.DeadLetterDir)
+		if ferr != nil {
+			s.fatal(fmt.Errorf("writing product %s: %w (dead letters: %v)", p.ID, err, ferr))
+			return
+		}
+	}
+	if derr := s.deadProducts.WriteProduct(p); derr != nil {
+		s.fatal(fmt.Errorf("writing product %s: %w (dead letters: %v)", p.ID, err, derr))
+	}
+}
+
+// openDeadLetters returns a sink appending to the product dead letters
+// in dir, closing the file on Flush.
+func openDeadLetters(dir string) (ProductSink, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, deadLetterProducts), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return closingSink{NewNDJSONSink(f), f}, nil
+}
+
+type closingSink struct {
+	ProductSink
+	c io.Closer
+}
+
+func (s closingSink) Flush() error {
+	return errors.Join(s.ProductSink.Flush(), s.c.Close())
+}
+
+// deadLetter saves the raw body of an interval that failed on a malformed
+// response to Config.DeadLetterDir, for offline inspection.
+func (s *Scraper) deadLetter(interval Interval, err error) {
+	var de *DecodeError
+	if s.cfg.DeadLetterDir == "" || !errors.As(err, &de) {
+		return
+	}
+
+	path := filepath.Join(s.cfg.DeadLetterDir, intervalKey(interval, s.cfg.PricePrecision)+".body")
+	err = os.MkdirAll(s.cfg.DeadLetterDir, 0o755)
+	if err == nil {
+		err = os.WriteFile(path, de.Body, 0o644)
+	}
+	if err != nil {
+		s.cfg.Logger.Error("error writing dead letter", "interval", interval, "err", err)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSinkFailure(t *testing.T) {
+	catalog := testCatalog(5000)
+
+	// A full disk aborts the run, saying so.
+	cfg := testConfig(catalog)
+	cfg.Sink = &failingSink{after: 100}
+	res, err := testScraper(t, cfg).Run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "disk full") {
+		t.Errorf("fatal sink failure: err %v, want the disk full", err)
+	}
+	if res != nil && !res.Truncated {
+		t.Error("fatal sink failure: not truncated")
+	}
+
+	// Or its products are dead-lettered, and the run goes on.
+	cfg = testConfig(catalog)
+	cfg.Sink = &failingSink{after: 100}
+	cfg.SinkFailure = SinkFailDeadLetter
+	cfg.DeadLetterDir = t.TempDir()
+	res, err = testScraper(t, cfg).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := int64(catalog.Valid() - 100)
+	if !res.Complete || res.Stats.SinkFailures != want {
+		t.Errorf("dead-lettering: complete %v with %d sink failures, want %d", res.Complete, res.Stats.SinkFailures, want)
+	}
+	f, err := os.Open(filepath.Join(cfg.DeadLetterDir, deadLetterProducts))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var lines int64
+	for sc := bufio.NewScanner(f); sc.Scan(); lines++ {
+	}
+	if lines != want {
+		t.Errorf("%d products dead-lettered, want %d", lines, want)
+	}
+}
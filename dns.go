@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// dnsCache resolves the API's host once per ttl rather than on every new
+// connection, riding out a flaky DNS server: a failed lookup falls back to
+// the last addresses it resolved, however old. The system resolver doesn't
+// tell record TTLs, so ttl is Config.DNSCacheTTL.
+type dnsCache struct {
+	lookup func(ctx context.Context, host string) ([]string, error)
+	ttl    time.Duration
+	clock  Clock
+
+	mu      sync.Mutex
+	entries map[string]dnsEntry
+
+	lookups atomic.Int64
+	stale   atomic.Int64
+}
+
+type dnsEntry struct {
+	addrs   []string
+	expires time.Time
+}
+
+// newDNSCache resolves with the server at resolver, host:port or a host
+// on port 53, or with the system's resolver if it is empty.
+func newDNSCache(resolver string, ttl time.Duration, clock Clock) (*dnsCache, error) {
+	r := net.DefaultResolver
+	if resolver != "" {
+		addr := resolver
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			addr = net.JoinHostPort(addr, "53")
+		}
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil || net.ParseIP(host) == nil {
+			return nil, fmt.Errorf("invalid resolver %q, want an IP address and optional port", resolver)
+		}
+		var d net.Dialer
+		r = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return d.DialContext(ctx, network, addr)
+			},
+		}
+	}
+	return &dnsCache{lookup: r.LookupHost, ttl: ttl, clock: clock, entries: map[string]dnsEntry{}}, nil
+}
+
+// resolve returns the addresses of host, from the cache while they are
+// fresh.
+func (c *dnsCache) resolve(ctx context.Context, host string) ([]string, error) {
+	c.mu.Lock()
+	e, ok := c.entries[host]
+	c.mu.Unlock()
+	if ok && c.clock.Now().Before(e.expires) {
+		return e.addrs, nil
+	}
+
+	c.lookups.Add(1)
+	addrs, err := c.lookup(ctx, host)
+	if err != nil {
+		if ok {
+			c.stale.Add(1)
+			return e.addrs, nil
+		}
+		return nil, err
+	}
+	c.mu.Lock()
+	c.entries[host] = dnsEntry{addrs: addrs, expires: c.clock.Now().Add(c.ttl)}
+	c.mu.Unlock()
+	return addrs, nil
+}
+
+// dialContext dials through d the addresses resolved for the host of
+// addr, in turn until one connects.
+func (c *dnsCache) dialContext(d *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil || net.ParseIP(host) != nil {
+			return d.DialContext(ctx, network, addr)
+		}
+		ips, err := c.resolve(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		var errs []error
+		for _, ip := range ips {
+			conn, err := d.DialContext(ctx, network, net.JoinHostPort(ip, port))
+			if err == nil {
+				return conn, nil
+			}
+			errs = append(errs, err)
+		}
+		if len(errs) == 0 {
+			return nil, &net.DNSError{Err: "no addresses", Name: host, IsNotFound: true}
+		}
+		return nil, errors.Join(errs...)
+	}
+}
+
+// lookupHost resolves host like the scraper's client does: through the DNS
+// cache of Config.Resolver and Config.DNSCacheTTL, if set.
+func (s *Scraper) lookupHost(ctx context.Context, host string) ([]string, error) {
+	if s.dns != nil {
+		return s.dns.resolve(ctx, host)
+	}
+	return net.DefaultResolver.LookupHost(ctx, host)
+}
+
+// dial connects to addr like the scraper's client does: from the first of
+// Config.LocalAddrs, if any, and resolving host names with lookupHost.
+func (s *Scraper) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	switch {
+	case s.local != nil:
+		return s.local.dials[0](ctx, network, addr)
+	case s.dns != nil:
+		return s.dns.dialContext(&net.Dialer{})(ctx, network, addr)
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, network, addr)
+}
+
+// isDNSError tells failed lookups of the API's host. They are transport
+// errors, retried like any.
+func isDNSError(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestFlakyDNS scrapes through a resolver failing every other lookup, the
+// first one included: no interval fails for it.
+func TestFlakyDNS(t *testing.T) {
+	catalog := testCatalog(5000)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp, err := catalog.Do(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer resp.Body.Close()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+	}))
+	defer srv.Close()
+	// A new connection, so a lookup, for every request.
+	srv.Config.SetKeepAlivesEnabled(false)
+	_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+
+	cfg := testConfig(nil)
+	cfg.DNSCacheTTL = time.Nanosecond
+	cfg.Backoff = BackoffFunc(func(int) time.Duration { return time.Millisecond })
+	s, err := NewScraper(cfg, WithBaseURL((&url.URL{Scheme: "http", Host: net.JoinHostPort("api.shop.test", port), Path: "/products"}).String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lookups atomic.Int64
+	s.dns.lookup = func(ctx context.Context, host string) ([]string, error) {
+		if host != "api.shop.test" {
+			t.Errorf("looked up %s", host)
+		}
+		if lookups.Add(1)%2 == 1 {
+			return nil, &net.DNSError{Err: "server misbehaving", Name: host, IsTemporary: true}
+		}
+		return []string{"127.0.0.1"}, nil
+	}
+
+	res, err := s.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Complete || len(res.Failed) > 0 || len(res.Products) != catalog.Valid() {
+		t.Errorf("complete %v with %d products, %d failed intervals, want %d products", res.Complete, len(res.Products), len(res.Failed), catalog.Valid())
+	}
+	// The first failure had nothing to fall back on, the others had.
+	if st := res.Stats; st.DNSErrors != 1 || st.DNSStaleAnswers == 0 || st.DNSLookups != lookups.Load() {
+		t.Errorf("%d DNS errors, %d stale answers of %d lookups (%d made), want 1 and some", st.DNSErrors, st.DNSStaleAnswers, st.DNSLookups, lookups.Load())
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+)
+
+// Doer sends HTTP requests; *http.Client satisfies it.
+type Doer interface {
+	Do(*http.Request) (*http.Response, error)
+}
+
+// DoerFunc adapts a function to the Doer interface.
+type DoerFunc func(*http.Request) (*http.Response, error)
+
+func (f DoerFunc) Do(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// Middleware layers a cross-cutting concern around a Doer.
+type Middleware func(Doer) Doer
+
+// Chain wraps d with mws, the first middleware being the outermost.
+func Chain(d Doer, mws ...Middleware) Doer {
+	for i := len(mws) - 1; i >= 0; i-- {
+		d = mws[i](d)
+	}
+	return d
+}
+
+// Logging logs every request with its outcome and duration, tagged with
+// the request's correlation ID and the scraper's worker when it has them.
+// Durations are measured with clock, the system clock if nil.
+func Logging(logger *slog.Logger, clock Clock) Middleware {
+	if clock == nil {
+		clock = systemClock{}
+	}
+	return func(next Doer) Doer {
+		return DoerFunc(func(req *http.Request) (*http.Response, error) {
+			logger := logger
+			if id := req.Header.Get(requestIDHeader); id != "" {
+				logger = logger.With("request_id", id)
+			}
+			if worker, ok := workerFrom(req.Context()); ok {
+				logger = logger.With("worker", worker)
+			}
+			if ua := req.Header.Get("User-Agent"); ua != "" {
+				logger = logger.With("user_agent", ua)
+			}
+			start := clock.Now()
+			resp, err := next.Do(req)
+			if err != nil {
+				logger.Warn("request failed", "url", req.URL.Redacted(), "duration", clock.Now().Sub(start), "err", err)
+				return nil, err
+			}
+			if chain := redirectChain(resp); chain != nil {
+				logger = logger.With("redirects", chain)
+			}
+			logger.Info("request", "url", req.URL.Redacted(), "status", resp.StatusCode, "duration", clock.Now().Sub(start))
+			return resp, nil
+		})
+	}
+}
+
+// RateLimit waits on the limiter before every request, giving up if the
+// request's context is done first.
+func RateLimit(l RateLimiter) Middleware {
+	return func(next Doer) Doer {
+		return DoerFunc(func(req *http.Request) (*http.Response, error) {
+			if err := l.Wait(req.Context()); err != nil {
+				return nil, err
+			}
+			return next.Do(req)
+		})
+	}
+}
+
+// SetHeader sets a header on every request, e.g. credentials.
+func SetHeader(key, value string) Middleware {
+	return func(next Doer) Doer {
+		return DoerFunc(func(req *http.Request) (*http.Response, error) {
+			req = req.Clone(req.Context())
+			req.Header.Set(key, value)
+			return next.Do(req)
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+	"testing"
+)
+
+func TestChain(t *testing.T) {
+	var calls []string
+	trace := func(name string) Middleware {
+		return func(next Doer) Doer {
+			return DoerFunc(func(req *http.Request) (*http.Response, error) {
+				calls = append(calls, name+" in")
+				resp, err := next.Do(req)
+				calls = append(calls, name+" out")
+				return resp, err
+			})
+		}
+	}
+	d := Chain(DoerFunc(func(req *http.Request) (*http.Response, error) {
+		calls = append(calls, "request")
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	}), trace("outer"), trace("inner"))
+
+	req, _ := http.NewRequest(http.MethodGet, "http://shop.test/products", nil)
+	if _, err := d.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"outer in", "inner in", "request", "inner out", "outer out"}
+	if !slices.Equal(calls, want) {
+		t.Errorf("calls %q, want %q", calls, want)
+	}
+}
+
+func TestRequestIDs(t *testing.T) {
+	catalog := testCatalog(5000)
+	var mu sync.Mutex
+	sent := map[string]int{}
+	var buf bytes.Buffer
+	cfg := testConfig(DoerFunc(func(req *http.Request) (*http.Response, error) {
+		mu.Lock()
+		sent[req.Header.Get(requestIDHeader)]++
+		mu.Unlock()
+		return catalog.Do(req)
+	}))
+	cfg.MaxWorkers = 4
+	cfg.Middleware = []Middleware{Logging(slog.New(slog.NewJSONHandler(&buf, nil)), nil)}
+	if _, err := testScraper(t, cfg).Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for id, n := range sent {
+		if id == "" || n > 1 {
+			t.Errorf("request ID %q sent %d times", id, n)
+		}
+	}
+
+	logged := map[string]bool{}
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var entry struct {
+			RequestID string `json:"request_id"`
+		}
+		if err := dec.Decode(&entry); err != nil {
+			t.Fatal(err)
+		}
+		logged[entry.RequestID] = true
+	}
+	if len(logged) != len(sent) {
+		t.Errorf("%d request IDs logged, %d sent", len(logged), len(sent))
+	}
+	for id := range sent {
+		if !logged[id] {
+			t.Errorf("request ID %q not logged", id)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+// Event is one lifecycle transition of an interval, see Scraper.Events.
+type Event interface {
+	event()
+}
+
+type IntervalStarted struct {
+	Interval Interval
+	Attempt  int // 0 for the first attempt
+}
+
+type IntervalSplit struct {
+	Interval Interval
+	Children [2]Interval
+}
+
+type IntervalCompleted struct {
+	Interval Interval
+	N        int // products returned
+}
+
+type IntervalRetried struct {
+	Interval Interval
+	Attempt  int // the attempt that was queued
+	Err      error
+}
+
+type IntervalFailed struct {
+	Interval Interval
+	Err      error
+}
+
+func (IntervalStarted) event()   {}
+func (IntervalSplit) event()     {}
+func (IntervalCompleted) event() {}
+func (IntervalRetried) event()   {}
+func (IntervalFailed) event()    {}
+
+const eventBufferSize int = 100
+
+// Events returns a channel receiving every interval transition of the next
+// Run, closed when Run returns. Call it before Run. The channel must be
+// drained: once its buffer is full, workers block until events are read.
+func (s *Scraper) Events() <-chan Event {
+	if s.events == nil {
+		s.events = make(chan Event, eventBufferSize)
+	}
+	return s.events
+}
+
+func (s *Scraper) emit(e Event) {
+	if s.events != nil {
+		s.events <- e
+	}
+}
+
+// FailedIntervals returns a channel receiving every interval of the next
+// Run given up on, as soon as it is, e.g. to dead-letter it elsewhere
+// right away; Result.Failed still lists them all. It is closed when Run
+// returns. Call it before Run, and drain it like Events.
+func (s *Scraper) FailedIntervals() <-chan FailedInterval {
+	if s.failed == nil {
+		s.failed = make(chan FailedInterval, eventBufferSize)
+	}
+	return s.failed
+}
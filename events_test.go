@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestEventsSplitThenComplete(t *testing.T) {
+	var products []Product
+	for i := range 1200 {
+		price := float32(1 + i%600)
+		if i >= 600 {
+			price += 60_000
+		}
+		products = append(products, Product{ID: ProductID(strconv.Itoa(i)), Price: price})
+	}
+	cfg := testConfig(NewCatalog(products, CatalogParams{}))
+	cfg.MaxWorkers = 1
+	s := testScraper(t, cfg)
+	events, failed := s.Events(), s.FailedIntervals()
+	go func() {
+		for range failed {
+		}
+	}()
+	var got []string
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for e := range events {
+			got = append(got, fmt.Sprintf("%T %v", e, e))
+		}
+	}()
+	if _, err := s.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+
+	root, low, high := Interval{0, maxPrice}, Interval{0, maxPrice / 2}, Interval{maxPrice / 2, maxPrice}
+	want := []string{
+		fmt.Sprintf("main.IntervalStarted %v", IntervalStarted{Interval: root}),
+		fmt.Sprintf("main.IntervalSplit %v", IntervalSplit{Interval: root, Children: [2]Interval{low, high}}),
+		fmt.Sprintf("main.IntervalStarted %v", IntervalStarted{Interval: low}),
+		fmt.Sprintf("main.IntervalCompleted %v", IntervalCompleted{Interval: low, N: 600}),
+		fmt.Sprintf("main.IntervalStarted %v", IntervalStarted{Interval: high}),
+		fmt.Sprintf("main.IntervalCompleted %v", IntervalCompleted{Interval: high, N: 600}),
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("events:\n%q\nwant:\n%q", got, want)
+	}
+}
+
+// TestFailedIntervalsLive holds a request of the run until the interval
+// failing meanwhile comes out of FailedIntervals: it does before Run
+// returns.
+func TestFailedIntervalsLive(t *testing.T) {
+	catalog := testCatalog(3000)
+	received := make(chan struct{})
+	cfg := testConfig(DoerFunc(func(req *http.Request) (*http.Response, error) {
+		switch req.URL.Query().Get(minPriceParam) {
+		case "50000":
+			return simulatedResponse(req, http.StatusServiceUnavailable, nil), nil
+		case "0":
+			select {
+			case <-received:
+			case <-time.After(5 * time.Second):
+				t.Error("failed interval not received while running")
+			}
+		}
+		return catalog.Do(req)
+	}))
+	cfg.MinWorkers = 2
+	cfg.MaxWorkers = 2
+	cfg.RetryableStatus = []int{http.StatusServiceUnavailable}
+	cfg.Backoff = BackoffFunc(func(int) time.Duration { return time.Millisecond })
+	cfg.Intervals = []Interval{{0, 50_000}, {50_000, maxPrice}}
+	s := testScraper(t, cfg)
+	failed := s.FailedIntervals()
+	var live []FailedInterval
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for f := range failed {
+			if live = append(live, f); len(live) == 1 {
+				close(received)
+			}
+		}
+	}()
+	res, err := s.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	if len(live) != 1 || live[0].Interval != cfg.Intervals[1] || len(res.Failed) != 1 || res.Failed[0].Interval != live[0].Interval {
+		t.Errorf("failed %v live, %v in the result, want %v in both", live, res.Failed, cfg.Intervals[1])
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import "slices"
+
+// trimExcluded cuts Config.ExcludeRanges out of plan, adding the width
+// they took up to the excluded width. Splits stay within the intervals
+// they come from, so trimming the plan keeps them out of the ranges too.
+func (s *Scraper) trimExcluded(plan []Interval) []Interval {
+	trimmed := make([]Interval, 0, len(plan))
+	for _, i := range plan {
+		rest := s.excluded.remainder(i)
+		for _, r := range rest {
+			s.excludedWidth -= float64(width(r))
+		}
+		s.excludedWidth += float64(width(i))
+		trimmed = append(trimmed, rest...)
+	}
+	return trimmed
+}
+
+// dropExcluded leaves out the products priced within Config.ExcludeRanges,
+// as the intervals bordering them may return some.
+func (s *Scraper) dropExcluded(products []Product) []Product {
+	if len(s.cfg.ExcludeRanges) == 0 {
+		return products
+	}
+	n := len(products)
+	products = slices.DeleteFunc(products, func(p Product) bool {
+		for _, r := range s.cfg.ExcludeRanges {
+			if r[0] <= p.Price && p.Price <= r[1] {
+				return true
+			}
+		}
+		return false
+	})
+	s.excludedProducts.Add(int64(n - len(products)))
+	return products
+}
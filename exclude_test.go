@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"testing"
+)
+
+func TestExcludeRanges(t *testing.T) {
+	products := []Product{{ID: "edge", Price: 99_000}, {ID: "placeholder", Price: 99_999}}
+	for _, p := range testCatalog(5000).products {
+		if validPrice(p) && p.Price < 99_000 {
+			products = append(products, p)
+		}
+	}
+	// The interval below the excluded range returns the product on its
+	// edge, as if the max price bound were inclusive there.
+	catalog := NewCatalog(products, (&Config{}).catalogParams())
+	client := DoerFunc(func(req *http.Request) (*http.Response, error) {
+		q := req.URL.Query()
+		if q.Get(maxPriceParam) == "99000" {
+			q.Set(maxPriceParam, "99000.01")
+			req = req.Clone(req.Context())
+			req.URL.RawQuery = q.Encode()
+		}
+		return catalog.Do(req)
+	})
+	cfg := testConfig(client)
+	cfg.ExcludeRanges = []Interval{{99_000, maxPrice}, {1000, 2000}, {1500, 3000}}
+	res, err := testScraper(t, cfg).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := 0
+	for _, p := range products {
+		if !(1000 <= p.Price && p.Price <= 3000) && p.Price < 99_000 {
+			want++
+		}
+	}
+	if !res.Complete || len(res.Products) != want {
+		t.Errorf("complete %v with %d products, want %d", res.Complete, len(res.Products), want)
+	}
+	for _, p := range res.Products {
+		if 1000 <= p.Price && p.Price <= 3000 || p.Price >= 99_000 {
+			t.Errorf("product %s at %v collected", p.ID, p.Price)
+		}
+	}
+	st := res.Stats
+	if math.Abs(st.ExcludedWidth-float64(maxPrice-99_000+2000)) > 0.1 || st.ExcludedProducts != 1 {
+		t.Errorf("%v excluded width, %d products excluded, want %v and the edge one", st.ExcludedWidth, st.ExcludedProducts, maxPrice-99_000+2000)
+	}
+}
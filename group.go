@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// group runs the goroutines of a stage of the run under one context, like
+// golang.org/x/sync/errgroup: the first error cancels the context with it
+// as the cause, and is the one Wait returns. Unlike errgroup's, Wait
+// leaves the context alone, since Run still tells a cancelled run from a
+// complete one by it after the stage stopped; cancel does that.
+type group struct {
+	cancel context.CancelCauseFunc
+	wg     sync.WaitGroup
+
+	mu  sync.Mutex
+	err error
+}
+
+func newGroup(ctx context.Context) (*group, context.Context) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	return &group{cancel: cancel}, ctx
+}
+
+// Go runs f in a goroutine of g, failing g if it returns an error.
+func (g *group) Go(f func() error) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		if err := f(); err != nil {
+			g.fail(err)
+		}
+	}()
+}
+
+// fail cancels g with err, unless it already failed, telling whether it
+// did.
+func (g *group) fail(err error) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.err != nil {
+		return false
+	}
+	g.err = err
+	g.cancel(err)
+	return true
+}
+
+// Err is the error g failed with, if any.
+func (g *group) Err() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.err
+}
+
+// Wait waits for the goroutines of g and returns the error it failed with.
+func (g *group) Wait() error {
+	g.wg.Wait()
+	return g.Err()
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestGroup(t *testing.T) {
+	g, ctx := newGroup(context.Background())
+	errFirst := errors.New("first")
+	g.Go(func() error {
+		<-ctx.Done()
+		return errors.New("second")
+	})
+	g.Go(func() error { return errFirst })
+	if err := g.Wait(); err != errFirst {
+		t.Errorf("Wait() = %v, want %v", err, errFirst)
+	}
+	if cause := context.Cause(ctx); cause != errFirst {
+		t.Errorf("cause %v, want %v", cause, errFirst)
+	}
+
+	g, ctx = newGroup(context.Background())
+	g.Go(func() error { return nil })
+	if err := g.Wait(); err != nil || ctx.Err() != nil {
+		t.Errorf("Wait() = %v with ctx %v, want neither", err, ctx.Err())
+	}
+}
+
+// TestGroupFail fails a group from outside its goroutines, the way a
+// fatal error of the collect stage aborts the fetch stage: the first
+// failure cancels the context with it as the cause and is the one kept.
+func TestGroupFail(t *testing.T) {
+	g, ctx := newGroup(context.Background())
+	for range 4 {
+		g.Go(func() error {
+			<-ctx.Done()
+			return nil
+		})
+	}
+	errFatal := errors.New("fatal")
+	if !g.fail(errFatal) {
+		t.Error("first failure not reported as such")
+	}
+	if g.fail(errors.New("later")) {
+		t.Error("second failure reported as the first")
+	}
+	if err := g.Wait(); err != errFatal || g.Err() != errFatal || context.Cause(ctx) != errFatal {
+		t.Errorf("Wait() = %v, Err() = %v, cause %v, want %v", err, g.Err(), context.Cause(ctx), errFatal)
+	}
+
+	// cancel stops the goroutines without failing the group.
+	g, ctx = newGroup(context.Background())
+	g.Go(func() error {
+		<-ctx.Done()
+		return nil
+	})
+	g.cancel(nil)
+	done := make(chan error)
+	go func() { done <- g.Wait() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("cancelled: Wait() = %v, want nil", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("cancelled group didn't stop")
+	}
+}
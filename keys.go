@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+)
+
+var (
+	errKeyRotated = errors.New("API key rejected, rotated to the next one")
+	errKeysBurned = errors.New("every API key is burned")
+)
+
+// KeyStats is what one key of Config.BearerTokens was used for. Key is
+// masked, Burned says why the key was given up on, if it was.
+type KeyStats struct {
+	Key      string `json:"key"`
+	Requests int64  `json:"requests"`
+	Burned   string `json:"burned,omitempty"`
+}
+
+// keyPool rotates through Config.BearerTokens, giving up on a key for the
+// rest of the run once the API rejects it or reports its quota used up.
+type keyPool struct {
+	tokens []string
+	logger *slog.Logger
+
+	mu      sync.Mutex
+	current int
+	stats   []KeyStats
+}
+
+func newKeyPool(tokens []string, logger *slog.Logger) *keyPool {
+	p := &keyPool{tokens: tokens, logger: logger, stats: make([]KeyStats, len(tokens))}
+	for i, t := range tokens {
+		p.stats[i].Key = maskKey(i, t)
+	}
+	return p
+}
+
+// maskKey names the ith key by its last characters, enough to tell keys
+// apart in logs and reports.
+func maskKey(i int, token string) string {
+	if len(token) < 12 {
+		return fmt.Sprintf("#%d", i+1)
+	}
+	return fmt.Sprintf("#%d …%s", i+1, token[len(token)-4:])
+}
+
+// next returns the key to send a request with, and its index.
+func (p *keyPool) next() (int, string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stats[p.current].Burned != "" {
+		return 0, "", fmt.Errorf("%w: all %d", errKeysBurned, len(p.tokens))
+	}
+	p.stats[p.current].Requests++
+	return p.current, p.tokens[p.current], nil
+}
+
+// burn gives up on key i, moving on to the next live one, and reports
+// whether there is one. Requests in flight with a key may burn it again.
+func (p *keyPool) burn(i int, reason string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stats[i].Burned == "" {
+		p.stats[i].Burned = reason
+		p.logger.Warn("API key burned", "key", p.stats[i].Key, "reason", reason)
+		for range p.tokens {
+			if p.stats[p.current].Burned == "" {
+				break
+			}
+			p.current = (p.current + 1) % len(p.tokens)
+		}
+		if p.stats[p.current].Burned == "" {
+			p.logger.Info("rotated API key", "key", p.stats[p.current].Key)
+		}
+	}
+	return p.stats[p.current].Burned == ""
+}
+
+func (p *keyPool) snapshot() []KeyStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]KeyStats(nil), p.stats...)
+}
+
+// mergeKeyStats sums the requests of each key over two runs, keeping the
+// later run's reason for burning it.
+func mergeKeyStats(a, b []KeyStats) []KeyStats {
+	merged := append([]KeyStats(nil), a...)
+	for _, k := range b {
+		i := slices.IndexFunc(merged, func(m KeyStats) bool { return m.Key == k.Key })
+		if i < 0 {
+			merged = append(merged, k)
+			continue
+		}
+		merged[i].Requests += k.Requests
+		merged[i].Burned = k.Burned
+	}
+	return merged
+}
+
+// rotateKey burns key, the index of the key resp was sent with, if the API
+// rejected it or reports its quota used up. errKeyRotated has
+// sendAuthorized resend a rejected request with the next key; none left is
+// errKeysBurned.
+func (s *Scraper) rotateKey(key int, resp *http.Response) error {
+	if key < 0 {
+		return nil
+	}
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		if s.keys.burn(key, fmt.Sprintf("status %d", resp.StatusCode)) {
+			return errKeyRotated
+		}
+		return fmt.Errorf("%w: %w", errKeysBurned, &StatusError{StatusCode: resp.StatusCode})
+	}
+	if remaining, err := strconv.Atoi(resp.Header.Get(s.cfg.RateRemainingHeader)); err == nil && remaining <= 0 {
+		s.keys.burn(key, "quota exhausted")
+	}
+	return nil
+}
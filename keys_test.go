@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// TestKeyRotation scrapes with a pool whose first key the API rejects: the
+// ping and the run go on with the next key, at no interval's retry.
+func TestKeyRotation(t *testing.T) {
+	catalog := testCatalog(3000)
+	rejecting := func(rejected ...string) Doer {
+		return DoerFunc(func(req *http.Request) (*http.Response, error) {
+			for _, key := range rejected {
+				if req.Header.Get("Authorization") == "Bearer "+key {
+					return simulatedResponse(req, http.StatusUnauthorized, []byte(`{}`)), nil
+				}
+			}
+			return catalog.Do(req)
+		})
+	}
+
+	cfg := testConfig(rejecting("revoked-key-0001"))
+	cfg.BearerTokens = []string{"revoked-key-0001", "valid-key-0002"}
+	if err := testScraper(t, cfg).Ping(context.Background()); err != nil {
+		t.Fatalf("ping: %v", err)
+	}
+
+	cfg = testConfig(rejecting("revoked-key-0001"))
+	cfg.BearerTokens = []string{"revoked-key-0001", "valid-key-0002"}
+	res, err := testScraper(t, cfg).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Complete || len(res.Products) != catalog.Valid() || res.Stats.Retries != 0 {
+		t.Errorf("complete %v with %d products after %d retries, want %d and none", res.Complete, len(res.Products), res.Stats.Retries, catalog.Valid())
+	}
+	keys := res.Stats.Keys
+	if len(keys) != 2 || keys[0].Requests != 1 || !strings.Contains(keys[0].Burned, "401") || keys[1].Burned != "" {
+		t.Errorf("keys %+v, want the first burned after a request", keys)
+	}
+	if sent := keys[0].Requests + keys[1].Requests; sent != res.Stats.Requests {
+		t.Errorf("%d requests sent with the keys, %d counted", sent, res.Stats.Requests)
+	}
+
+	// With every key rejected, the ping tells so.
+	cfg = testConfig(rejecting("revoked-key-0001", "revoked-key-0002"))
+	cfg.BearerTokens = []string{"revoked-key-0001", "revoked-key-0002"}
+	if err := testScraper(t, cfg).Ping(context.Background()); !errors.Is(err, errKeysBurned) {
+		t.Errorf("ping with every key rejected: %v, want %v", err, errKeysBurned)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// localAddrs sends requests from several local addresses, worker n
+// through the nth modulo their number.
+type localAddrs struct {
+	addrs    []string
+	clients  []*http.Client
+	dials    []func(ctx context.Context, network, addr string) (net.Conn, error) // of the clients
+	requests []atomic.Int64
+}
+
+// newLocalAddrs checks that every address can be bound to, so that a
+// typo fails the run before its first request. Host names are resolved
+// with dns, if not nil, and redirects followed as checkRedirect allows.
+func newLocalAddrs(addrs []string, dns *dnsCache, checkRedirect func(*http.Request, []*http.Request) error) (*localAddrs, error) {
+	l := &localAddrs{addrs: addrs, requests: make([]atomic.Int64, len(addrs))}
+	for _, a := range addrs {
+		ip := net.ParseIP(a)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid local address %q", a)
+		}
+		ln, err := net.Listen("tcp", net.JoinHostPort(a, "0"))
+		if err != nil {
+			return nil, fmt.Errorf("local address %s: %w", a, err)
+		}
+		ln.Close()
+
+		dialer := &net.Dialer{LocalAddr: &net.TCPAddr{IP: ip}, Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.DialContext = dialer.DialContext
+		if dns != nil {
+			t.DialContext = dns.dialContext(dialer)
+		}
+		l.clients = append(l.clients, &http.Client{Transport: t, CheckRedirect: checkRedirect})
+		l.dials = append(l.dials, t.DialContext)
+	}
+	return l, nil
+}
+
+func (l *localAddrs) Do(req *http.Request) (*http.Response, error) {
+	// Requests made outside workers go through the first address.
+	id, _ := workerFrom(req.Context())
+	i := id % len(l.clients)
+	l.requests[i].Add(1)
+	return l.clients[i].Do(req)
+}
+
+// counts is the number of requests sent from each address.
+func (l *localAddrs) counts() map[string]int64 {
+	m := make(map[string]int64, len(l.addrs))
+	for i, a := range l.addrs {
+		m[a] = l.requests[i].Load()
+	}
+	return m
+}
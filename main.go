@@ -1,32 +1,111 @@
 package main
 
 import (
+	"cmp"
+	"context"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
+	"log/slog"
+	"math"
+	"math/rand"
 	"net/http"
 	"net/url"
+	"os"
+	"os/signal"
+	"slices"
 	"strconv"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 )
 
 // ############# TYPES #############
 
 type Product struct {
-	ID    int     `json:"id"`
-	Name  string  `json:"name"`
-	Price float32 `json:"price"`
+	ID    ProductID `json:"id"`
+	Name  string    `json:"name"`
+	Price float32   `json:"price"` // list price
+	// SalePrice, if the API has one, can be split on instead of Price
+	// with Config.MinPriceParam and Config.MaxPriceParam.
+	SalePrice float32 `json:"salePrice,omitempty"`
 }
 
-type ProductList struct {
+// ProductID identifies a product. APIs send it as a number or a string
+// like "SKU-00123"; numeric IDs are written back as numbers.
+type ProductID string
+
+func (id *ProductID) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		*id = ProductID(s)
+		return nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err != nil {
+		return fmt.Errorf("product id %s: want a number or a string", data)
+	}
+	*id = ProductID(n)
+	return nil
+}
+
+func (id ProductID) MarshalJSON() ([]byte, error) {
+	if id.numeric() {
+		return []byte(id), nil
+	}
+	return json.Marshal(string(id))
+}
+
+// numeric tells IDs that are integers as written, e.g. 123 but not 0123.
+func (id ProductID) numeric() bool {
+	n, err := strconv.ParseInt(string(id), 10, 64)
+	return err == nil && strconv.FormatInt(n, 10) == string(id)
+}
+
+// compareIDs orders numeric IDs by value, before the others by string.
+func compareIDs(a, b ProductID) int {
+	an, bn := a.numeric(), b.numeric()
+	switch {
+	case an && bn:
+		return cmp.Or(cmp.Compare(len(a), len(b)), cmp.Compare(a, b))
+	case an != bn:
+		if an {
+			return -1
+		}
+		return 1
+	}
+	return cmp.Compare(a, b)
+}
+
+// foundProducts are the products of a response along with the interval
+// that returned them, sent to the collector in one go.
+type foundProducts struct {
 	products []Product
-	mu       sync.Mutex
+	interval Interval
+}
+
+type ProductList struct {
+	products   []Product
+	byInterval map[Interval][]Product
+	histogram  histogram
+	collected  int
+	filtered   int
+	invalid    []Product
+	sinkErr    error // first error writing to the sink
+	mu         sync.Mutex
 }
 
 type ErrorList struct {
-	intervals []Interval
+	intervals []FailedInterval
 	mu        sync.Mutex
 }
 
@@ -34,221 +113,1176 @@ type Response struct {
 	Total    int       `json:"total"`
 	Count    int       `json:"count"`
 	Products []Product `json:"products"`
+	// NextCursor, from APIs paging with cursors, is where the next page
+	// of the interval starts; empty on the last one.
+	NextCursor string `json:"nextCursor"`
 }
+
 type Interval [2]float32
 
+// FailedInterval is an interval given up on, with the error of its last
+// attempt.
+type FailedInterval struct {
+	Interval Interval
+	Err      error
+	Attempts int
+}
+
 type IntervalInfo struct {
 	interval Interval
 	nRetry   int
 }
 
+type Result struct {
+	Products []Product
+	// SpillFile holds the products collected past Config.SpillAfter, as
+	// NDJSON in ID order; EachProduct goes through both, Close removes it.
+	SpillFile string
+	// ByInterval groups Products under the (post-split) interval that
+	// returned them. A product seen in several is kept under the first.
+	// Spilled products are left out.
+	ByInterval map[Interval][]Product
+	// Collected counts Products, also when Config.StatsOnly leaves them
+	// out, and Histogram counts them per interval like ByInterval.
+	Collected int
+	Histogram map[Interval]int
+	// Products with a zero, negative or NaN price, usually a sign the
+	// price failed to parse upstream. They are kept out of Products.
+	InvalidPrices []Product
+	// Intervals that couldn't be requested
+	Failed []FailedInterval
+	// Complete means every interval was fetched. It doesn't require the
+	// product count to match the totals: the catalog can change during a
+	// run, which InitialTotal and FinalTotal (the API's totals before and
+	// after scraping, 0 when unknown) let callers reason about. A run of
+	// an empty catalog or price range is complete, with no products.
+	Complete     bool
+	InitialTotal int
+	FinalTotal   int
+	// Shortfall is how many products a complete run is missing beyond
+	// Config.CoverageTolerance; 0 when it is within it.
+	Shortfall int
+	// Truncated is set when the run stopped before covering every
+	// interval; Uncovered lists what is left, ready to be resumed.
+	Truncated bool
+	Uncovered []Interval
+	// Partial lists the intervals Config.NoSplit collected only the first
+	// page of, though they hold more.
+	Partial []Interval
+	// SpotChecks are the intervals requested again by Config.SpotChecks,
+	// and SpotMisses the products they returned that the run missed.
+	SpotChecks []SpotCheck
+	SpotMisses int
+	// Skipped lists the top-level intervals left out by Config.Sample.
+	Skipped []Interval
+	// SplitTree holds how each top-level interval was split, when
+	// Config.SplitTreeDepth is set.
+	SplitTree []*SplitNode
+	// Warnings about the configuration, e.g. more workers than the rate
+	// limit lets work.
+	Warnings []string
+	// Watermark is when the run started: the next incremental run (see
+	// Config.UpdatedSince) starts from there so as not to miss products
+	// updated during this one.
+	Watermark time.Time
+	Stats     Stats
+}
+
+type Config struct {
+	// BaseURL is the products endpoint, apiURL by default. Set it with
+	// WithBaseURL to have it validated.
+	BaseURL *url.URL
+	// Sent with every request, including the probes. NewScraper rejects
+	// the names the scraper sets itself (the price, ID, offset and limit
+	// params).
+	ExtraParams url.Values
+	// MinPriceParam and MaxPriceParam bound the price the intervals split
+	// on ("minPrice" and "maxPrice" by default), e.g. a sale price.
+	MinPriceParam string
+	MaxPriceParam string
+	// PriceFormat is how they are written, PriceFloat by default.
+	PriceFormat PriceFormat
+	// MaxPriceExclusive is for APIs leaving out products priced exactly at
+	// maxPrice. Split intervals share their bounds either way, so a
+	// boundary product is returned by the upper one, or by both and
+	// deduped; only the top of the price range needs widening, by one
+	// PricePrecision step, not to lose the products priced there. Either
+	// way, a product priced exactly at the top of the price range is
+	// collected once, and none priced above it. DetectMaxPriceBound sets
+	// MaxPriceExclusive as the API behaves, probing it before the run.
+	MaxPriceExclusive   bool
+	DetectMaxPriceBound bool
+	// Order in which pending intervals are handed to workers.
+	Order Order
+	// Shuffle randomizes the initial partition and spreads retries among
+	// pending work so consecutive requests don't hit one price region.
+	Shuffle bool
+	// Seed for the random choices above, for reproducible runs.
+	Seed int64
+	// RetryableStatus lists the HTTP statuses worth retrying; any other
+	// non-2xx status fails the interval at once. Defaults to
+	// defaultRetryableStatus.
+	RetryableStatus []int
+	// RetryInvalidPrices re-requests an interval whose response contains
+	// products with invalid prices, in case the bad values were transient.
+	RetryInvalidPrices bool
+	// ValidateInterval, when set, checks every response to an interval
+	// before its products are accepted, e.g. with PricesWithin. A
+	// response failing it fails the interval, or is requested again with
+	// RetryInvalidIntervals.
+	ValidateInterval      func(Interval, *Response) error
+	RetryInvalidIntervals bool
+	// The worker pool scales between MinWorkers and MaxWorkers depending on
+	// queue depth and unused rate-limit tokens.
+	MinWorkers int
+	MaxWorkers int
+	// ProgressInterval is how often a progress line is logged; 0 disables it.
+	ProgressInterval time.Duration
+	// MaxRequests caps every HTTP attempt of the run, retries and the
+	// initial request included; 0 means no cap.
+	MaxRequests int64
+	// MaxRedirects is how many redirects a request follows, 3 by default;
+	// negative follows none. Redirects to another host are refused unless
+	// AllowCrossHostRedirects. A request redirected to an HTML page, like
+	// a login page, fails its interval for good with a RedirectError.
+	MaxRedirects            int
+	AllowCrossHostRedirects bool
+	// MaxProducts stops the run once this many unique products have been
+	// collected; 0 means no limit.
+	MaxProducts int
+	// PolitenessDelay is slept by a worker after each of its requests,
+	// give or take a random PolitenessJitter, on top of the global rate
+	// limit. Whichever of the two is slower wins.
+	PolitenessDelay  time.Duration
+	PolitenessJitter time.Duration
+	// RequestTimeout bounds each HTTP attempt, from when the rate limiter
+	// lets it through, or from when it starts waiting for a token with
+	// TimeoutIncludesWait, for deadlines on the latency seen end to end.
+	// 0 means no timeout beyond the Client's own.
+	RequestTimeout      time.Duration
+	TimeoutIncludesWait bool
+	// PricePrecision is the number of decimals prices have, 2 by default.
+	// Interval bounds closer than that are considered equal.
+	PricePrecision int
+	// SplitRatio is where an interval over the limit is split, as a
+	// fraction of its width from the low end; 0.5 by default. Lower values
+	// suit prices skewed toward the low end.
+	SplitRatio float32
+	// CappedBelowLimit is for APIs returning at most apiLimit-1 products
+	// per response, where a response of apiLimit-1 may be cut short.
+	CappedBelowLimit bool
+	// PaginateBelow, when set, stops splitting intervals narrower than
+	// this and pages through them instead with OffsetParam ("offset" by
+	// default), so dense clusters don't cost a request per tiny split.
+	PaginateBelow float32
+	OffsetParam   string
+	// CursorParam ("cursor" by default) sends back the nextCursor of a
+	// response. An interval within the split limit whose response has one
+	// is paged through to its last page instead of being split.
+	CursorParam string
+	// SplitUntilComplete ignores the count and total of responses, for
+	// APIs whose count can't be trusted: every interval is split down to
+	// PaginateBelow, or to the price precision without it, and then paged
+	// through. Coverage is complete at the cost of many more requests.
+	SplitUntilComplete bool
+	// NoSplit makes a single request per planned interval, for a fast
+	// approximate scrape: intervals over the split limit are neither split
+	// nor paged through, only their first page is collected, and they are
+	// listed in Result.Partial.
+	NoSplit bool
+	// MinIDParam and MaxIDParam, when set, bound product IDs (inclusive) to
+	// split the intervals too narrow to split by price any further, e.g.
+	// thousands of products at the same price. Without them such an
+	// interval fails with errUnsplittable.
+	MinIDParam string
+	MaxIDParam string
+	// SinceID, when set, scrapes only the products with higher IDs, e.g.
+	// those added to an append-only catalog since a previous run. Every
+	// request bounds the IDs, so MinIDParam and MaxIDParam are required.
+	SinceID int
+	// SplitTreeDepth, when set, records the tree of splits in
+	// Result.SplitTree, with detail down to this depth so that memory stays
+	// bounded.
+	SplitTreeDepth int
+	// PriceRange, when set, is scraped instead of [0, maxPrice].
+	PriceRange *Interval
+	// ExcludeRanges are price ranges, bounds included, never to fetch:
+	// planned intervals and their splits are trimmed around them, and
+	// products priced within them that still come back at the edges are
+	// dropped. The API's totals count what they hide, so a run excluding
+	// anything isn't checked for a Result.Shortfall.
+	ExcludeRanges []Interval
+	// Sample, when in (0, 1), fetches only that seeded-random fraction of
+	// the top-level intervals (with all their splits) and extrapolates the
+	// full run from it.
+	Sample float64
+	// Intervals, when set, are scraped as-is instead of planning the
+	// partition from an initial request (e.g. to resume a truncated run).
+	Intervals []Interval
+	// UpdatedSince, when set, scrapes only the products updated since
+	// then, sending it in RFC 3339 as UpdatedSinceParam ("updatedSince" by
+	// default). A previous run's Result.Watermark is where to pick up.
+	UpdatedSince      time.Time
+	UpdatedSinceParam string
+	// CoverageTolerance is the fraction of the lower of the initial and
+	// final totals a complete run may miss without a Result.Shortfall.
+	// With StrictCoverage, a shortfall also fails the run.
+	CoverageTolerance float64
+	StrictCoverage    bool
+	// SpotChecks is how many random narrow intervals, within what the run
+	// covered, are requested again once it is over, to check that every
+	// product they return was collected; see Result.SpotMisses.
+	SpotChecks int
+	// OnPlan, if set, is called with the top-level intervals about to be
+	// scraped, after sampling and before any worker starts.
+	OnPlan func([]Interval)
+	// PreFanoutDelay is waited between planning and requesting the
+	// intervals, e.g. to read the logged plan first.
+	PreFanoutDelay time.Duration
+	// Client sends the requests, wrapped by Middleware (outermost first)
+	// and then by the rate limiter. Defaults to a client like
+	// http.DefaultClient. It may be shared by several scrapers. An
+	// *http.Client without a CheckRedirect of its own is used with one
+	// enforcing MaxRedirects and AllowCrossHostRedirects.
+	Client     Doer
+	Middleware []Middleware
+	Logger     *slog.Logger
+	// BearerToken, BasicAuth or BearerTokens, only one of them,
+	// authenticate every request. BearerTokens is a pool of keys, each
+	// with its own quota, used one after the other: a key is given up on
+	// once the API rejects it (401 or 403) or reports no requests
+	// remaining, and the run fails once all are.
+	BearerToken  string
+	BasicAuth    *BasicAuth
+	BearerTokens []string
+	// UserAgent is sent with every request, one naming the scraper and its
+	// version by default. UserAgents, instead, are drawn from at random for
+	// each request, reproducibly from Seed.
+	UserAgent  string
+	UserAgents []string
+	// APIVersion selects the response schema the API is asked for and
+	// decoded with, among the RegisterSchema ones; "" is the original one.
+	// Accept, by default the version's media type, is sent with every
+	// request, and a response of another media type fails its interval.
+	APIVersion string
+	Accept     string
+	// StrictSchema fails the intervals whose responses have fields the
+	// scraper doesn't know, or products without an id, name or price,
+	// with a SchemaError. Otherwise unknown fields are ignored, and a run
+	// whose products mostly have no price or name warns of schema drift.
+	StrictSchema bool
+	// RespectRobots, the compliance mode, reads the robots.txt of the API's
+	// host before the run: a run of an endpoint it disallows for UserAgent
+	// fails unless IgnoreRobots, and its Crawl-delay caps RPS and
+	// RetryRPS, without bursts: it is the least time between requests.
+	RespectRobots bool
+	IgnoreRobots  bool
+	// LocalAddrs, instead of Client, sends the requests from these local
+	// IP addresses, spreading workers across them in turn.
+	LocalAddrs []string
+	// DNSCacheTTL, instead of Client, caches the addresses of the API's
+	// host this long rather than resolving it for every new connection,
+	// answering from the last lookup when the DNS server fails. Resolver,
+	// an IP address with an optional port (53 by default), is the DNS
+	// server to ask instead of the system's; without DNSCacheTTL, every
+	// connection resolves anew, still falling back on the last answer.
+	DNSCacheTTL time.Duration
+	Resolver    string
+	// SessionURL, for APIs behind a WAF, is a landing page visited with
+	// SessionMethod (GET by default) before the run for session cookies,
+	// which are then sent with every request. It is visited again when the
+	// API starts answering 403. Cookie values are never logged.
+	SessionURL    string
+	SessionMethod string
+	// Clock drives every wait and measurement of the run; the system
+	// clock by default.
+	Clock Clock
+	// RateLimiter paces requests. Defaults to a token bucket of
+	// tokenBucketSize refilled at RPS, which also honors the API's
+	// rate-limit headers named below. Scrapers of one host can share a
+	// rate through the jobs of a SharedLimiter.
+	RateLimiter RateLimiter
+	RPS         float64
+	// RetryRPS, when set, admits retries to the queue at this separate,
+	// usually much lower rate so fresh work keeps most of the main one.
+	RetryRPS float64
+	// Backoff spaces out the attempts at a failed interval; a retry waits
+	// its turn off the queue, without holding a worker.
+	Backoff Backoff
+	// WarmUp ramps the rate up from a fraction of RPS over this long at the
+	// start of the run, whatever the RateLimiter.
+	WarmUp              time.Duration
+	RateRemainingHeader string
+	RateResetHeader     string
+	// AllowIDs, if not empty, keeps only these products out of the
+	// collected ones; otherwise DenyIDs leaves these out.
+	AllowIDs map[ProductID]struct{}
+	DenyIDs  map[ProductID]struct{}
+	// StatsOnly runs the whole scrape but keeps only product counts out of
+	// Result.Products, for sizing a catalog in little memory.
+	StatsOnly bool
+	// SpillAfter, if > 0, caps the products kept in memory: the rest are
+	// written to SpillDir (os.TempDir() if empty) in runs of SpillAfter
+	// sorted by ID, merged once the run is over into a temporary file, in
+	// ID order; the merge drops the duplicates the collector no longer
+	// remembers. Those products reach the Sink and are counted only then,
+	// Histogram is coarsened to a few thousand intervals, and MaxProducts
+	// counts their duplicates too. Memory stays about the same whatever
+	// the size of the catalog: see BenchmarkSpill.
+	SpillAfter int
+	SpillDir   string
+	// Sink, if set, receives every valid unique product as it is collected
+	// and is flushed at the end of the run. Use MultiSink for several.
+	Sink ProductSink
+	// Buffer sizes of the product and failed-interval channels. When the
+	// collector falls behind, workers block on the full channel instead of
+	// the buffer growing, so memory use stays bounded by these sizes and
+	// nothing is dropped. Products are sent a response at a time, so
+	// ProductBuffer is rounded down to whole responses of apiLimit.
+	ProductBuffer int
+	ErrorBuffer   int
+	// SinkFailure decides whether a product the Sink fails to write aborts
+	// the run (the default) or is dead-lettered to DeadLetterDir.
+	SinkFailure SinkFailure
+	// DeadLetterDir, if set, receives the raw body of every interval that
+	// failed for good on a malformed response, one file per interval.
+	DeadLetterDir string
+}
+
 // ############# CONSTANTS #############
 
 const apiURL string = "https://api.ecommerce.com/products"
+const requestIDHeader string = "X-Request-ID"
 const apiLimit int = 1000
+const defaultSplitRatio float32 = 0.5
 const maxPrice float32 = 100000
+const maxRetries int = 3
 const workerNum int = 10
+const productBufferSize int = 1000
+const errorBufferSize int = 100
+const bodySnippetSize int = 2048
+const scaleInterval time.Duration = time.Millisecond * 500
 const tokenBucketSize int = 10
+const overProvisionFactor int = 2
 const refreshRate time.Duration = time.Millisecond * 100
+const rateRemainingHeader string = "X-RateLimit-Remaining"
+const rateResetHeader string = "X-RateLimit-Reset"
+const offsetParam string = "offset"
+const cursorParam string = "cursor"
+const updatedSinceParam string = "updatedSince"
+const maxID int = 1<<31 - 1
+
+// reusedIDSample is how many known products -since-id checks again.
+const reusedIDSample int = 5
+const minPriceParam string = "minPrice"
+const maxPriceParam string = "maxPrice"
+const pricePrecision int = 2
+const productChunk int = 4096
+const maxPresize int = 1 << 22
+
+const (
+	exitOK        int = 0
+	exitError     int = 1
+	exitFailed    int = 2
+	exitTruncated int = 3
+)
+
+var defaultRetryableStatus = []int{429, 500, 502, 503, 504}
 
 // ############# FUNCTIONS #############
 
-func initTokenBucket(done <-chan struct{}) chan struct{} {
-	tb := make(chan struct{}, tokenBucketSize)
-	ticker := time.NewTicker(refreshRate)
-
-	go func() {
-		for {
-			select {
-			case <-ticker.C:
-				select {
-				case <-tb:
-				case <-ticker.C:
-				case <-done:
-					return
-				}
-			case <-done:
-				return
-			}
+func validPrice(p Product) bool {
+	return p.Price > 0 && !math.IsNaN(float64(p.Price))
+}
+
+func hasInvalidPrice(products []Product) bool {
+	for _, p := range products {
+		if !validPrice(p) {
+			return true
 		}
-	}()
+	}
+	return false
+}
 
-	return tb
+// intervalKey normalizes an interval's bounds to precision decimals, so
+// that intervals differing only by float32 noise compare and key the same.
+func intervalKey(i Interval, precision int) string {
+	return formatBound(i[0], precision) + "-" + formatBound(i[1], precision)
 }
 
-func request(interval Interval, tokenBucket chan<- struct{}) (*Response, error) {
-	params := url.Values{}
-	strconv.FormatFloat(float64(interval[0]), 'f', -1, 32)
-	params.Add("minPrice", strconv.FormatFloat(float64(interval[0]), 'f', -1, 32))
-	params.Add("maxPrice", strconv.FormatFloat(float64(interval[1]), 'f', -1, 32))
+func formatBound(b float32, precision int) string {
+	return strconv.FormatFloat(float64(b), 'f', precision, 32)
+}
 
-	fullURL := apiURL + "?" + params.Encode()
+// sameBound compares bounds the way intervalKey does.
+func sameBound(a, b float32, precision int) bool {
+	return formatBound(a, precision) == formatBound(b, precision)
+}
 
-	tokenBucket <- struct{}{}
-	resp, err := http.Get(fullURL)
-	if err != nil {
-		return nil, err
+// uniqueProducts drops the repeats of a product within one response, and
+// reports how many there were.
+func uniqueProducts(products []Product) ([]Product, int) {
+	seen := make(map[ProductID]struct{}, len(products))
+	unique := products[:0:0]
+	for _, p := range products {
+		if _, ok := seen[p.ID]; ok {
+			continue
+		}
+		seen[p.ID] = struct{}{}
+		unique = append(unique, p)
 	}
-	defer resp.Body.Close()
+	return unique, len(products) - len(unique)
+}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
+// receive returns the next value of c, or false once c is closed or ctx
+// is done.
+func receive[T any](ctx context.Context, c <-chan T) (T, bool) {
+	select {
+	case v, ok := <-c:
+		return v, ok
+	case <-ctx.Done():
+		var zero T
+		return zero, false
 	}
+}
 
-	var response Response
-	err = json.Unmarshal(body, &response)
-	if err != nil {
-		fmt.Println("Error al decodificar JSON:", err)
-		return nil, err
+// getProductsList collects unique products by ID that pass filter (if not
+// nil), also writing the valid ones to sink if there is one. Unless keep is
+// set only their counts per interval are. Once limit (if > 0) products are
+// collected it calls full and discards the rest, still draining c so
+// workers never block on it. The kept products are sized for expected (if
+// > 0) up front, and grow in chunks of at least productChunk past it. It
+// gives up on c once ctx is done.
+//
+// If spill isn't nil, only its runSize products are kept in memory: the
+// others, left out or not, go to its runs and are only counted, written to
+// sink and to spill's file once the runs are merged, after c. Until then
+// the valid ones count towards limit as they come, repeats included.
+func getProductsList(ctx context.Context, c <-chan foundProducts, limit, expected int, full func(), filter func(Product) bool, sink ProductSink, keep bool, spill *spiller, g *group) *ProductList {
+	pl := ProductList{products: []Product{}, byInterval: map[Interval][]Product{}, histogram: histogram{counts: map[Interval]int{}}, mu: sync.Mutex{}}
+	if limit > 0 && (expected <= 0 || limit < expected) {
+		expected = limit
+	}
+	if spill != nil {
+		pl.histogram.max = histogramBuckets
+		if expected <= 0 || spill.runSize < expected {
+			expected = spill.runSize
+		}
+	}
+	if keep && expected > 0 {
+		// The API reported the total, don't trust it with all our memory.
+		pl.products = make([]Product, 0, min(expected, maxPresize))
 	}
 
-	return &response, nil
+	g.Go(func() error {
+		// Spilling, seen only holds the IDs of the products in memory and
+		// of the run being buffered: the runs' merge drops the repeats of
+		// the others.
+		seen := make(map[ProductID]struct{})
+		var valid []Product
+		for batch, ok := receive(ctx, c); ok; batch, ok = receive(ctx, c) {
+			valid = valid[:0]
+			for _, p := range batch.products {
+				if _, ok := seen[p.ID]; ok {
+					continue
+				}
+				if limit > 0 && pl.collected >= limit {
+					continue
+				}
+				seen[p.ID] = struct{}{}
+				passes := filter == nil || filter(p)
+
+				pl.mu.Lock()
+				switch {
+				case spill != nil && (!passes || !validPrice(p) || len(pl.products) >= spill.runSize):
+					if passes && validPrice(p) {
+						pl.collected++
+					}
+					spill.add(spillRecord{Product: p, Interval: batch.interval, Filtered: !passes})
+					if spill.full() {
+						for _, rec := range spill.run {
+							delete(seen, rec.Product.ID)
+						}
+						spill.flush()
+					}
+				case !passes:
+					pl.filtered++
+				case validPrice(p):
+					pl.collected++
+					pl.histogram.add(batch.interval, 1)
+					if keep {
+						if len(pl.products) == cap(pl.products) {
+							pl.products = slices.Grow(pl.products, max(len(pl.products), productChunk))
+						}
+						pl.products = append(pl.products, p)
+						pl.byInterval[batch.interval] = append(pl.byInterval[batch.interval], p)
+					}
+					valid = append(valid, p)
+				default:
+					pl.invalid = append(pl.invalid, p)
+				}
+				pl.mu.Unlock()
+
+				if passes && limit > 0 && pl.collected == limit {
+					full()
+				}
+			}
+			if sink != nil && pl.sinkErr == nil && len(valid) > 0 {
+				pl.sinkErr = writeBatch(sink, valid)
+			}
+		}
+		if spill != nil {
+			pl.unspill(spill, seen, sink)
+		}
+		return nil
+	})
+
+	return &pl
 }
 
-func initialReq(tb chan struct{}) (*Response, error) {
-	interval := Interval{0, maxPrice}
-	res, err := request(interval, tb)
-	nRetry := 0
-	for err != nil && nRetry < 3 {
-		res, err = request(interval, tb)
-		if err == nil {
-			break
+// unspill merges the runs of spill, counting every product in them as the
+// first time it was seen, unless it was kept in memory: kept holds their
+// IDs and those of the run still buffered. The valid products go to
+// spill's file and to sink.
+func (pl *ProductList) unspill(spill *spiller, kept map[ProductID]struct{}, sink ProductSink) {
+	for _, rec := range spill.run {
+		delete(kept, rec.Product.ID)
+	}
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	pl.collected = len(pl.products)
+	var valid []Product
+	write := func() {
+		if sink != nil && pl.sinkErr == nil && len(valid) > 0 {
+			pl.sinkErr = writeBatch(sink, valid)
 		}
+		valid = valid[:0]
 	}
-
-	return res, nil
+	spill.merge(func(rec spillRecord) {
+		p := rec.Product
+		if _, ok := kept[p.ID]; ok {
+			return
+		}
+		switch {
+		case rec.Filtered:
+			pl.filtered++
+		case validPrice(p):
+			pl.collected++
+			pl.histogram.add(rec.Interval, 1)
+			spill.out.WriteProduct(p)
+			if valid = append(valid, p); len(valid) == apiLimit {
+				write()
+			}
+		default:
+			pl.invalid = append(pl.invalid, p)
+		}
+	})
+	write()
 }
 
-func recursiveReq(
-	intervalInfo IntervalInfo,
-	pChan chan<- Product,
-	eChan chan<- Interval,
-	iChan chan<- IntervalInfo,
-	wg *sync.WaitGroup,
-	tokenBucket chan struct{},
-) {
-	defer wg.Done()
+// Intervals that couldn't be requested, until c is closed or ctx done.
+func getErrorsList(ctx context.Context, c <-chan FailedInterval, g *group) *ErrorList {
+	eList := ErrorList{intervals: []FailedInterval{}, mu: sync.Mutex{}}
 
-	interval := intervalInfo.interval
-	nRetry := intervalInfo.nRetry
+	g.Go(func() error {
+		for i, ok := receive(ctx, c); ok; i, ok = receive(ctx, c) {
+			eList.mu.Lock()
+			eList.intervals = append(eList.intervals, i)
+			eList.mu.Unlock()
+		}
+		return nil
+	})
 
-	res, err := request(interval, tokenBucket)
-	if err != nil {
-		if nRetry == 3 {
-			eChan <- interval
-			return
+	return &eList
+}
+
+// writeHistogram writes the product count of each interval as NDJSON, in
+// price order.
+func writeHistogram(w io.Writer, histogram map[Interval]int) error {
+	intervals := make([]Interval, 0, len(histogram))
+	for i := range histogram {
+		intervals = append(intervals, i)
+	}
+	slices.SortFunc(intervals, func(a, b Interval) int { return cmp.Compare(a[0], b[0]) })
+	enc := json.NewEncoder(w)
+	for _, i := range intervals {
+		if err := enc.Encode(struct {
+			Interval Interval `json:"interval"`
+			Count    int      `json:"count"`
+		}{i, histogram[i]}); err != nil {
+			return err
 		}
-		iChan <- IntervalInfo{interval: interval, nRetry: nRetry + 1}
 	}
+	return nil
+}
 
-	if res.Count < apiLimit {
-		go func() {
-			for _, p := range res.Products {
-				pChan <- p
-			}
-		}()
-		return
-	}
+// idSet is a flag of comma-separated product IDs, repeatable.
+type idSet map[ProductID]struct{}
 
-	wg.Add(2)
-	dif := (interval[1] - interval[0]) / 2
-	iChan <- IntervalInfo{interval: Interval{interval[0], interval[0] + dif}, nRetry: 0}
-	iChan <- IntervalInfo{interval: Interval{interval[0] + dif, interval[1]}, nRetry: 0}
+func (s *idSet) String() string {
+	ids := make([]string, 0, len(*s))
+	for id := range *s {
+		ids = append(ids, string(id))
+	}
+	slices.Sort(ids)
+	return strings.Join(ids, ",")
 }
 
-func worker(
-	iChan chan IntervalInfo,
-	pChan chan<- Product,
-	eChan chan<- Interval,
-	wg *sync.WaitGroup,
-	tokenBucket chan struct{},
-) {
-	for intInfo := range iChan {
-		recursiveReq(intInfo, pChan, eChan, iChan, wg, tokenBucket)
+func (s *idSet) Set(v string) error {
+	if *s == nil {
+		*s = idSet{}
+	}
+	for _, f := range strings.Split(v, ",") {
+		if id := strings.TrimSpace(f); id != "" {
+			(*s)[ProductID(id)] = struct{}{}
+		}
 	}
+	return nil
 }
 
-func getProductsList(c <-chan Product, done chan<- struct{}) *ProductList {
-	pl := ProductList{products: []Product{}, mu: sync.Mutex{}}
+// stringList is a repeatable string flag.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
 
-	go func() {
-		for p := range c {
-			pl.mu.Lock()
-			pl.products = append(pl.products, p)
-			pl.mu.Unlock()
+// rangeList is a repeatable lo-hi flag of price ranges.
+type rangeList []Interval
+
+func (l *rangeList) String() string {
+	ranges := make([]string, len(*l))
+	for i, r := range *l {
+		ranges[i] = fmt.Sprintf("%v-%v", r[0], r[1])
+	}
+	return strings.Join(ranges, ",")
+}
+
+func (l *rangeList) Set(v string) error {
+	lo, hi, ok := strings.Cut(v, "-")
+	if !ok {
+		return fmt.Errorf("want lo-hi, got %q", v)
+	}
+	var r Interval
+	for i, bound := range []string{lo, hi} {
+		f, err := strconv.ParseFloat(bound, 32)
+		if err != nil {
+			return err
 		}
+		r[i] = float32(f)
+	}
+	*l = append(*l, r)
+	return nil
+}
 
-		done <- struct{}{}
-	}()
+// paramFlag is a repeatable k=v flag adding query params.
+type paramFlag struct{ params *url.Values }
 
-	return &pl
+func (f paramFlag) String() string {
+	if f.params == nil {
+		return ""
+	}
+	return f.params.Encode()
+}
+
+func (f paramFlag) Set(v string) error {
+	k, val, ok := strings.Cut(v, "=")
+	if !ok || k == "" {
+		return fmt.Errorf("want k=v, got %q", v)
+	}
+	if *f.params == nil {
+		*f.params = url.Values{}
+	}
+	f.params.Add(k, val)
+	return nil
 }
 
-// Intervals that couldn't be requested
-func getErrorsList(c <-chan Interval, done chan struct{}) *ErrorList {
-	eList := ErrorList{intervals: []Interval{}, mu: sync.Mutex{}}
+func run(ctx context.Context, args []string) (res *Result, err error) {
+	flags := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	flags.Usage = func() { usage(flags) }
+	// One clock for the report, the middleware and the run.
+	cfg := &Config{Clock: systemClock{}}
+	flags.IntVar(&cfg.MinWorkers, "min-workers", 1, "minimum number of workers")
+	flags.IntVar(&cfg.MaxWorkers, "max-workers", workerNum, "maximum number of workers")
+	flags.DurationVar(&cfg.ProgressInterval, "progress", 0, "log progress at this interval (0 disables)")
+	flags.IntVar(&cfg.ProductBuffer, "product-buffer", productBufferSize, "products buffered before workers block")
+	flags.Int64Var(&cfg.MaxRequests, "max-requests", 0, "stop after this many requests (0 means no limit)")
+	flags.IntVar(&cfg.MaxProducts, "max-products", 0, "stop after collecting this many products (0 means no limit)")
+	baseURL := flags.String("url", apiURL, "products endpoint")
+	logRequests := flags.Bool("log-requests", false, "log every HTTP request")
+	flags.Float64Var(&cfg.RPS, "rps", float64(time.Second/refreshRate), "requests per second")
+	flags.Float64Var(&cfg.RetryRPS, "retry-rps", 0, "separate rate for retries (0 shares -rps)")
+	backoff := flags.Duration("backoff", 0, "base wait before a retry, doubled with jitter for each further one (0 retries at once)")
+	breakerThreshold := flags.Int("breaker-threshold", 0, "stop sending requests for -breaker-cooldown after this many consecutive failures (0 disables)")
+	breakerCooldown := flags.Duration("breaker-cooldown", 30*time.Second, "how long the circuit stays open before probing the API again")
+	flags.DurationVar(&cfg.WarmUp, "warm-up", 0, "ramp up to -rps over this long")
+	flags.DurationVar(&cfg.PolitenessDelay, "delay", 0, "pause of each worker after every request")
+	flags.DurationVar(&cfg.PolitenessJitter, "jitter", 0, "random +/- variation of -delay")
+	flags.IntVar(&cfg.MaxRedirects, "max-redirects", defaultMaxRedirects, "redirects a request follows at most, -1 for none")
+	flags.BoolVar(&cfg.AllowCrossHostRedirects, "allow-cross-host-redirects", false, "follow redirects to other hosts than the API's")
+	flags.DurationVar(&cfg.RequestTimeout, "request-timeout", 0, "give up on a request after this long (0 disables)")
+	flags.BoolVar(&cfg.TimeoutIncludesWait, "timeout-includes-wait", false, "count the wait for a rate-limit token in -request-timeout")
+	minP := flags.Float64("min-price", 0, "lower bound of the price range to scrape")
+	maxP := flags.Float64("max-price", float64(maxPrice), "upper bound of the price range to scrape")
+	flags.Var((*rangeList)(&cfg.ExcludeRanges), "exclude-range", "lo-hi price range never to fetch, bounds included (repeatable)")
+	flags.DurationVar(&cfg.PreFanoutDelay, "pre-fanout-delay", 0, "wait this long after planning before requesting intervals")
+	splitRatio := flags.Float64("split-ratio", float64(defaultSplitRatio), "where to split intervals over the limit, as a fraction of their width")
+	flags.StringVar(&cfg.MinIDParam, "min-id-param", "", "query param of the lowest product ID, to split same-price clusters by ID (with -max-id-param)")
+	flags.StringVar(&cfg.MaxIDParam, "max-id-param", "", "query param of the highest product ID")
+	flags.IntVar(&cfg.SinceID, "since-id", 0, "scrape only the products with higher IDs, appending them to the outputs (needs -min-id-param and -max-id-param)")
+	flags.BoolVar(&cfg.CappedBelowLimit, "capped-below-limit", false, fmt.Sprintf("the API returns at most %d products per response", apiLimit-1))
+	flags.StringVar(&cfg.MinPriceParam, "min-price-param", minPriceParam, "query param of the lower price bound, e.g. of a sale price")
+	flags.BoolVar(&cfg.MaxPriceExclusive, "max-price-exclusive", false, "the API leaves out products priced exactly at the max price param (see -check)")
+	flags.BoolVar(&cfg.DetectMaxPriceBound, "detect-max-price-bound", false, "probe whether the API's max price bound is exclusive before the run, setting -max-price-exclusive")
+	priceFormat := flags.String("price-format", "float", "how to write the price params: \"float\", \"decimals\" (-price-precision of them), \"cents\" or \"units\"")
+	flags.StringVar(&cfg.MaxPriceParam, "max-price-param", maxPriceParam, "query param of the upper price bound")
+	paginateBelow := flags.Float64("paginate-below", 0, "page through intervals narrower than this instead of splitting them (0 disables)")
+	flags.StringVar(&cfg.CursorParam, "cursor-param", cursorParam, "query param sending back the nextCursor of a paged response")
+	validatePrices := flags.Bool("validate-prices", false, "fail the intervals whose responses have products priced outside of them")
+	flags.BoolVar(&cfg.RetryInvalidIntervals, "retry-invalid-intervals", false, "retry the intervals failing -validate-prices instead of failing them at once")
+	flags.BoolVar(&cfg.NoSplit, "no-split", false, "collect only the first page of every planned interval, without splitting")
+	flags.BoolVar(&cfg.SplitUntilComplete, "split-until-complete", false, "ignore response counts: split every interval down to -paginate-below and page through it")
+	flags.IntVar(&cfg.PricePrecision, "price-precision", pricePrecision, "decimals of prices; closer interval bounds are considered equal")
+	flags.Float64Var(&cfg.Sample, "sample", 0, "fetch only this random fraction of intervals and extrapolate")
+	flags.Int64Var(&cfg.Seed, "seed", 0, "seed for random choices")
+	flags.Var(paramFlag{&cfg.ExtraParams}, "param", "k=v query param to send with every request (repeatable)")
+	flags.StringVar(&cfg.SessionURL, "session-url", "", "landing page to get session cookies from before scraping, and again on 403s")
+	flags.StringVar(&cfg.SessionMethod, "session-method", http.MethodGet, "HTTP method of -session-url")
+	var userAgents stringList
+	flags.StringVar(&cfg.APIVersion, "api-version", "", "API version to ask for and decode responses with: "+strings.Join(schemaVersions(), ", "))
+	flags.BoolVar(&cfg.StrictSchema, "strict-schema", false, "fail the intervals whose responses have unknown fields or products without an id, name or price")
+	flags.StringVar(&cfg.Accept, "accept", "", "Accept header to send (default the -api-version media type); responses of other media types fail")
+	flags.Var(&userAgents, "user-agent", "User-Agent header to send (default go-scraper-concept/<version>); repeated, one is drawn at random from -seed for each request")
+	flags.BoolVar(&cfg.RespectRobots, "respect-robots", false, "refuse to scrape an endpoint the host's robots.txt disallows, and keep to its crawl delay")
+	flags.BoolVar(&cfg.IgnoreRobots, "ignore-robots", false, "with -respect-robots, scrape even if robots.txt disallows it, still keeping to its crawl delay")
+	flags.DurationVar(&cfg.DNSCacheTTL, "dns-cache-ttl", 0, "cache the API host's addresses this long, answering from the last lookup while DNS fails (0 disables)")
+	flags.StringVar(&cfg.Resolver, "resolver", "", "DNS server to resolve with, e.g. 1.1.1.1:53 (default the system's)")
+	flags.Var((*stringList)(&cfg.LocalAddrs), "local-addr", "local IP address to send requests from, spreading workers across them (repeatable)")
+	var outputs stringList
+	flags.Var(&outputs, "out", "write products to this file or URI (file://, s3:// or a registered scheme), \"-\" or stdout for stdout (the default); CSV if it ends in .csv, NDJSON otherwise (repeatable)")
+	flags.IntVar(&cfg.SpillAfter, "spill-after", 0, "keep at most this many products in memory, spilling the rest to a temporary file (0 keeps all)")
+	flags.StringVar(&cfg.SpillDir, "spill-dir", "", "directory for the -spill-after file (default the system temporary directory)")
+	flags.StringVar(&cfg.DeadLetterDir, "dead-letter", "", "directory to save malformed responses of failed intervals to")
+	flags.Float64Var(&cfg.CoverageTolerance, "coverage-tolerance", 0.01, "fraction of the total a complete run may miss")
+	flags.IntVar(&cfg.SpotChecks, "spot-checks", 0, "after the run, request this many random narrow intervals again and report the products they return that were missed")
+	flags.BoolVar(&cfg.StrictCoverage, "strict-coverage", false, "fail when more than -coverage-tolerance is missing")
+	dumpIntervals := flags.String("dump-intervals", "", "write the tree of interval splits to this JSON file")
+	splitLeaves := flags.String("split-leaves", "", "write the intervals left unsplit, with their depth and products, to this file; CSV if it ends in .csv, NDJSON otherwise")
+	flags.IntVar(&cfg.SplitTreeDepth, "tree-depth", 16, "record splits down to this depth for -dump-intervals and -split-leaves")
+	var allowIDs, denyIDs idSet
+	flags.Var(&allowIDs, "allow-ids", "keep only products with these comma-separated IDs")
+	flags.Var(&denyIDs, "deny-ids", "leave out products with these comma-separated IDs")
+	flags.BoolVar(&cfg.StatsOnly, "stats-only", false, "print product counts per interval instead of the products")
+	sinkFailure := flags.String("sink-failure", "fatal", "what to do with products -out fails to write: \"fatal\" or \"dead-letter\" (to -dead-letter)")
+	quiet := flags.Bool("quiet", false, "only log errors")
+	verbose := flags.Bool("v", false, "log debug details, and the heaviest split subtrees with -dump-intervals")
+	veryVerbose := flags.Bool("vv", false, "like -v, and log every HTTP request")
+	failedFile := flags.String("failed", "", "write the intervals that failed to this file")
+	retryFrom := flags.String("retry-from", "", "scrape only the intervals of a -failed file, updating it")
+	planFrom := flags.String("plan-from", "", "start from the leaf intervals of a -dump-intervals file")
+	intervalsFile := flags.String("intervals", "", "scrape the intervals of this NDJSON file of {\"min\":..,\"max\":..} lines instead of planning them")
+	reportFile := flags.String("report", "", "write a JSON report of the run to this file, even if it fails")
+	var apiKeys stringList
+	flags.Var(&apiKeys, "api-key", "sent as a bearer token in the Authorization header; repeated, the keys are rotated through as each is rejected or runs out of quota")
+	basicAuth := flags.String("basic-auth", "", "user:password to authenticate with HTTP Basic auth")
+	recordDir := flags.String("record", "", "save every response to this directory, for -replay")
+	replayDir := flags.String("replay", "", "answer requests from the responses -record saved to this directory, without the network")
+	simulate := flags.Int("simulate", 0, "scrape a random in-memory catalog of this many products (from -seed) instead of -url, best with a high -rps")
+	serve := flags.String("serve", "", "serve POST /scrape and GET /metrics on this address instead of scraping")
+	configFile := flags.String("config", "", "JSON file of flag names to values, overridden by the environment and flags")
+	check := flags.Bool("check", false, "check connectivity to the endpoint step by step, without scraping")
+	resumeFile := flags.String("resume", "", "file to resume uncovered intervals from and save them to")
+	updatedSince := flags.String("updated-since", "", "scrape only the products updated since this RFC 3339 time")
+	sinceReport := flags.String("since-report", "", "scrape only the products updated since the watermark of this -report of a previous run")
+	flags.StringVar(&cfg.UpdatedSinceParam, "updated-since-param", updatedSinceParam, "query param of -updated-since")
+	snapshot := flags.String("snapshot", "", "NDJSON snapshot to merge the products into by ID, rewriting it")
+	detectDeletions := flags.Bool("detect-deletions", false, "drop the products gone from the catalog from -snapshot, scraping all its IDs if its total shows any")
+	if err := flags.Parse(args); err != nil {
+		return nil, fmt.Errorf("%w: %w", errBadFlags, err)
+	}
 
-	go func() {
-		for i := range c {
-			eList.mu.Lock()
-			eList.intervals = append(eList.intervals, i)
-			eList.mu.Unlock()
+	if *configFile == "" {
+		*configFile = os.Getenv(envName("config"))
+	}
+	sources, err := applyEnvAndConfig(flags, *configFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var deleted []ProductID
+	var highID int
+	if *reportFile != "" {
+		// Registered first so it runs last, once the outputs are closed.
+		started := cfg.Clock.Now()
+		defer func() {
+			r := newReport(cfg, started, res, err, outputs)
+			r.Deleted = deleted
+			r.MaxID = highID
+			if werr := writeReport(*reportFile, r); werr != nil {
+				slog.Error("error writing report", "err", werr)
+			}
+		}()
+	}
+
+	if *minP != 0 || *maxP != float64(maxPrice) {
+		cfg.PriceRange = &Interval{float32(*minP), float32(*maxP)}
+	}
+	cfg.SplitRatio = float32(*splitRatio)
+	cfg.AllowIDs, cfg.DenyIDs = allowIDs, denyIDs
+	formats := map[string]PriceFormat{"float": PriceFloat, "decimals": PriceDecimals, "cents": PriceCents, "units": PriceUnits}
+	format, ok := formats[*priceFormat]
+	if !ok {
+		return nil, fmt.Errorf("invalid -price-format %q", *priceFormat)
+	}
+	cfg.PriceFormat = format
+	switch *sinkFailure {
+	case "fatal":
+	case "dead-letter":
+		cfg.SinkFailure = SinkFailDeadLetter
+	default:
+		return nil, fmt.Errorf("invalid -sink-failure %q", *sinkFailure)
+	}
+	cfg.PaginateBelow = float32(*paginateBelow)
+	if *backoff > 0 {
+		cfg.Backoff = ExponentialBackoff{Base: *backoff}
+	}
+	if *validatePrices {
+		cfg.ValidateInterval = PricesWithin(cfg.PricePrecision)
+	}
+	if *dumpIntervals == "" && *splitLeaves == "" {
+		cfg.SplitTreeDepth = 0
+	}
+	switch {
+	case *updatedSince != "" && *sinceReport != "":
+		return nil, errors.New("-updated-since and -since-report can't be used together")
+	case *updatedSince != "":
+		cfg.UpdatedSince, err = time.Parse(time.RFC3339, *updatedSince)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -updated-since: %w", err)
 		}
+	case *sinceReport != "":
+		cfg.UpdatedSince, err = readWatermark(*sinceReport)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if *detectDeletions && *snapshot == "" {
+		return nil, errors.New("-detect-deletions needs a -snapshot")
+	}
+	// stdout is kept for the products, everything else is logged to stderr.
+	*verbose = *verbose || *veryVerbose
+	*logRequests = *logRequests || *veryVerbose
+	switch {
+	case *quiet:
+		slog.SetLogLoggerLevel(slog.LevelError)
+	case *verbose:
+		slog.SetLogLoggerLevel(slog.LevelDebug)
+	}
+	if len(outputs) == 0 && !cfg.StatsOnly {
+		outputs = stringList{"-"}
+	}
+	flags.VisitAll(func(f *flag.Flag) {
+		slog.Debug("setting", "name", f.Name, "value", maskedValue(f), "source", sources[f.Name])
+	})
+	if len(userAgents) == 1 {
+		cfg.UserAgent = userAgents[0]
+	} else {
+		cfg.UserAgents = userAgents
+	}
+	if len(apiKeys) == 1 {
+		cfg.BearerToken = apiKeys[0]
+	} else {
+		cfg.BearerTokens = apiKeys
+	}
+	if *basicAuth != "" {
+		user, password, _ := strings.Cut(*basicAuth, ":")
+		cfg.BasicAuth = &BasicAuth{User: user, Password: password}
+	}
+	if *logRequests {
+		cfg.Middleware = append(cfg.Middleware, Logging(slog.Default(), cfg.Clock))
+	}
+	// The scraper is made below, once the middleware is in place.
+	var scraper *Scraper
+	if *breakerThreshold > 0 {
+		restart := func() {
+			if scraper != nil {
+				scraper.RestartWarmUp()
+			}
+		}
+		cfg.Middleware = append(cfg.Middleware, CircuitBreaker(*breakerThreshold, *breakerCooldown, cfg.Clock, restart))
+	}
+	var catalog *Catalog
+	if *simulate > 0 {
+		catalog = RandomCatalog(*simulate, cfg.Seed, cfg.catalogParams())
+		cfg.Client = catalog
+	}
+	switch {
+	case *replayDir != "":
+		cfg.Client = NewReplayer(*replayDir)
+	case *recordDir != "":
+		client := cfg.Client
+		if client == nil {
+			client = &http.Client{CheckRedirect: cfg.checkRedirect}
+		}
+		cfg.Client = NewRecorder(*recordDir, client)
+	}
 
-		done <- struct{}{}
-	}()
+	if *resumeFile != "" {
+		intervals, err := readIntervals(*resumeFile)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		cfg.Intervals = intervals
+	}
 
-	return &eList
-}
+	if *retryFrom != "" {
+		if *resumeFile != "" || *planFrom != "" {
+			return nil, errors.New("-retry-from can't be used with -resume or -plan-from")
+		}
+		intervals, err := readIntervals(*retryFrom)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Intervals = intervals
+		if *failedFile == "" {
+			*failedFile = *retryFrom
+		}
+	}
+	if *planFrom != "" {
+		if *resumeFile != "" {
+			return nil, errors.New("-plan-from and -resume can't be used together")
+		}
+		roots, err := readSplitTree(*planFrom)
+		if err != nil {
+			return nil, err
+		}
+		plan, saved, err := planFromTree(roots, cfg.priceRange(), cfg.PricePrecision)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", *planFrom, err)
+		}
+		slog.Info("reusing plan", "intervals", len(plan), "saved_requests", saved)
+		cfg.Intervals = plan
+	}
 
-func main() {
-	pChan := make(chan Product, 1000)
-	eChan := make(chan Interval, 100)
-	iChan := make(chan IntervalInfo, 100)
-	done := make(chan struct{})
+	if *intervalsFile != "" {
+		if *resumeFile != "" || *retryFrom != "" || *planFrom != "" {
+			return nil, errors.New("-intervals can't be used with -resume, -retry-from or -plan-from")
+		}
+		plan, err := readIntervalPlan(*intervalsFile, cfg.PricePrecision)
+		if err != nil {
+			return nil, err
+		}
+		slog.Info("seeded plan", "intervals", len(plan))
+		cfg.Intervals = plan
+	}
 
-	tb := initTokenBucket(done)
-	wg := sync.WaitGroup{}
+	// Neither writes the outputs: a self-test must not depend on them.
+	if *serve != "" {
+		slog.Info("serving", "addr", *serve)
+		return nil, http.ListenAndServe(*serve, ServeHTTP(*cfg, WithBaseURL(*baseURL)))
+	}
+	if *check {
+		scraper, err = NewScraper(cfg, WithBaseURL(*baseURL))
+		if err != nil {
+			return nil, err
+		}
+		return nil, scraper.Check(ctx, os.Stdout)
+	}
 
-	// Initial request to make estimation of intervals
-	res, err := initialReq(tb)
+	var sinks []ProductSink
+	for _, out := range outputs {
+		// A delta by ID is appended to what earlier runs wrote.
+		w, appended, oerr := openOutput(out, cfg.SinceID > 0)
+		if oerr != nil {
+			return nil, oerr
+		}
+		defer func() {
+			err = errors.Join(err, w.Close())
+		}()
+		if strings.HasSuffix(out, ".csv") {
+			sinks = append(sinks, &csvSink{w: csv.NewWriter(w), header: appended})
+		} else {
+			sinks = append(sinks, NewNDJSONSink(w))
+		}
+	}
+	if len(sinks) > 0 {
+		cfg.Sink = MultiSink(sinks...)
+	}
+
+	scraper, err = NewScraper(cfg, WithBaseURL(*baseURL))
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+	if err := scraper.Ping(ctx); err != nil {
+		return nil, err
+	}
+	if cfg.SinceID > 0 {
+		for _, out := range outputs {
+			path, ok := localOutput(out)
+			if !ok || strings.HasSuffix(path, ".csv") {
+				continue
+			}
+			rng := rand.New(rand.NewSource(cfg.Seed))
+			if err := scraper.checkReusedIDs(ctx, path, reusedIDSample, rng); err != nil {
+				slog.Warn("checking for reused IDs", "output", out, "err", err)
+			}
+			break
+		}
 	}
+	stopSignals := handlePauseSignals(scraper)
+	res, err = scraper.Run(ctx)
+	stopSignals()
+	if res == nil {
+		return nil, err
+	}
+	defer res.Close()
+	runErr := err
 
-	nIntervals := res.Total / apiLimit
-	intLen := maxPrice / float32(nIntervals)
-	interval := Interval{0, intLen}
+	slog.Info("collected", "products", res.Collected, "requests", res.Stats.Requests,
+		"initial_total", res.InitialTotal, "final_total", res.FinalTotal)
+	if catalog != nil {
+		slog.Info("simulated", "products", catalog.Len(), "valid", catalog.Valid(),
+			"missing", catalog.Valid()-res.Collected, "requests", catalog.Requests())
+	}
+	if res.Truncated {
+		slog.Warn("run truncated", "reason", res.Stats.StopReason, "uncovered", len(res.Uncovered))
+	}
+	if len(res.Skipped) > 0 {
+		slog.Info("sampled", "intervals", res.Stats.SampledIntervals,
+			"estimated_products", res.Stats.EstimatedProducts, "estimated_requests", res.Stats.EstimatedRequests)
+	}
+	if *resumeFile != "" {
+		if err := writeIntervals(*resumeFile, slices.Concat(res.Uncovered, res.Skipped)); err != nil {
+			return res, err
+		}
+	}
 
-	wg.Add(nIntervals)
-	for i := 0; i < nIntervals; i++ {
-		iChan <- IntervalInfo{interval: interval, nRetry: 0}
-		interval[0], interval[1] = interval[1], interval[1]+intLen
+	if *failedFile != "" {
+		if err := writeIntervals(*failedFile, res.FailedIntervals()); err != nil {
+			return res, err
+		}
+	}
+	if *dumpIntervals != "" {
+		if err := writeSplitTree(*dumpIntervals, res.SplitTree); err != nil {
+			return res, err
+		}
+		if *verbose {
+			renderHeaviest(os.Stderr, res.SplitTree, 5)
+		}
+	}
+	if *splitLeaves != "" {
+		f, err := os.Create(*splitLeaves)
+		if err != nil {
+			return res, err
+		}
+		err = writeSplitLeaves(f, res.SplitLeaves(), strings.HasSuffix(*splitLeaves, ".csv"))
+		if err := errors.Join(err, f.Close()); err != nil {
+			return res, err
+		}
 	}
 
-	for i := 0; i < workerNum; i++ {
-		go worker(iChan, pChan, eChan, &wg, tb)
+	if cfg.StatsOnly {
+		if err := writeHistogram(os.Stdout, res.Histogram); err != nil {
+			return res, err
+		}
+	}
+	for _, p := range res.InvalidPrices {
+		slog.Warn("invalid price", "product", p)
+	}
+	for _, f := range res.Failed {
+		slog.Warn("interval failed", "interval", f.Interval, "attempts", f.Attempts, "err", f.Err)
 	}
 
-	listsDone := make(chan struct{}, 2)
-	pl := getProductsList(pChan, listsDone)
-	el := getErrorsList(eChan, listsDone)
+	if cfg.SinceID > 0 {
+		highID, err = maxNumericID(res, cfg.SinceID)
+		if err != nil {
+			return res, err
+		}
+		slog.Info("high watermark", "id", highID)
+	}
 
-	wg.Wait()
-	done <- struct{}{}
-	close(done)
-	close(iChan)
-	close(pChan)
-	close(eChan)
-	<-listsDone
-	<-listsDone
-	close(listsDone)
+	if *snapshot != "" {
+		products, err := readSnapshot(*snapshot)
+		if err != nil {
+			return res, err
+		}
+		before := len(products)
+		err = res.EachProduct(func(p Product) error {
+			products[p.ID] = p
+			return nil
+		})
+		if err != nil {
+			return res, err
+		}
+		// Only a clean run tells what the catalog still has.
+		if *detectDeletions && runErr == nil && ctx.Err() == nil {
+			deleted, err = deletedIDs(ctx, *cfg, []Option{WithBaseURL(*baseURL)}, products, len(products)-before)
+			if err != nil {
+				return res, fmt.Errorf("detecting deletions: %w", err)
+			}
+			for _, id := range deleted {
+				delete(products, id)
+			}
+		}
+		if err := writeSnapshot(*snapshot, products); err != nil {
+			return res, err
+		}
+		slog.Info("snapshot updated", "path", *snapshot, "products", len(products),
+			"added", len(products)+len(deleted)-before, "deleted", len(deleted))
+	}
+	return res, runErr
+}
+
+// errBadFlags fails a command line the flag set couldn't parse, having
+// printed why along with the usage.
+var errBadFlags = errors.New("bad flags")
 
-	for _, p := range pl.products {
-		fmt.Println(p)
+// usage prints the flags of the scraper and how it exits.
+func usage(flags *flag.FlagSet) {
+	fmt.Fprintf(flags.Output(), "Usage of %s:\n", flags.Name())
+	flags.PrintDefaults()
+	fmt.Fprint(flags.Output(), `
+Every flag can also be set from the environment, as SCRAPER_ and the flag
+name in upper snake case (e.g. SCRAPER_MAX_WORKERS, or SCRAPER_WORKERS and
+SCRAPER_OUTPUT for short), or from the -config file. Flags take precedence
+over the environment, which takes precedence over the config file.
+
+Exit codes:
+  0  every interval was scraped
+  1  error (bad flags, failed initial request, output not written)
+  2  completed, but some intervals failed
+  3  truncated by the request budget, -max-products or a signal
+`)
+}
+
+func main() {
+	// SIGINT or SIGTERM stop the run, which still writes what it got.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	res, err := run(ctx, os.Args[1:])
+	stop()
+	// The flag set already told what was wrong with the flags.
+	if err != nil && !errors.Is(err, errBadFlags) {
+		log.Print(err)
 	}
-	for _, i := range el.intervals {
-		fmt.Println(i)
+	os.Exit(exitCode(res, err))
+}
+
+// exitCode tells scripts how the run went, as documented in the usage.
+func exitCode(res *Result, err error) int {
+	switch {
+	case errors.Is(err, flag.ErrHelp):
+		return exitOK
+	case err != nil:
+		return exitError
+	case res == nil: // nothing was scraped, e.g. -check
+		return exitOK
+	case res.Truncated:
+		return exitTruncated
+	case len(res.Failed) > 0:
+		return exitFailed
 	}
+	return exitOK
 }
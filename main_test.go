@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"io/fs"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRunExitCodes(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "products.ndjson")
+	for _, tc := range []struct {
+		name    string
+		timeout time.Duration
+		args    []string
+		want    int
+	}{
+		{"bad flag", 0, []string{"-no-such-flag"}, exitError},
+		{"bad value", 0, []string{"-max-workers", "many"}, exitError},
+		{"help", 0, []string{"-h"}, exitOK},
+		{"complete", 0, []string{"-simulate", "3000", "-rps", "1e6", "-out", out}, exitOK},
+		// Cancelled like by a signal, once the run started but well
+		// before its 20 requests at most 20 a second can be done.
+		{"interrupted", 300 * time.Millisecond, []string{"-simulate", "20000", "-rps", "20", "-out", out}, exitTruncated},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tc.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tc.timeout)
+				defer cancel()
+			}
+			res, err := run(ctx, tc.args)
+			if res != nil {
+				defer res.Close()
+			}
+			if got := exitCode(res, err); got != tc.want {
+				t.Errorf("exit code %d (err %v), want %d", got, err, tc.want)
+			}
+		})
+	}
+}
+
+func TestRunBadFlags(t *testing.T) {
+	_, err := run(context.Background(), []string{"-no-such-flag"})
+	if !errors.Is(err, errBadFlags) || errors.Is(err, flag.ErrHelp) {
+		t.Errorf("err %v, want errBadFlags", err)
+	}
+}
+
+// TestRetryFrom scrapes a server failing a price band, then retries the
+// intervals that failed from the -failed file once it has recovered.
+func TestRetryFrom(t *testing.T) {
+	catalog := testCatalog(5000)
+	var healthy atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lo, _ := strconv.ParseFloat(r.URL.Query().Get(minPriceParam), 32)
+		hi, _ := strconv.ParseFloat(r.URL.Query().Get(maxPriceParam), 32)
+		if !healthy.Load() && 20_000 <= lo && hi <= 40_000 {
+			http.Error(w, "flaky", http.StatusInternalServerError)
+			return
+		}
+		resp, err := catalog.Do(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer resp.Body.Close()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	failed := filepath.Join(dir, "failed.json")
+	args := []string{"-url", srv.URL + "/products", "-rps", "1e6", "-failed", failed}
+	res, err := run(context.Background(), append(args, "-out", filepath.Join(dir, "first.ndjson")))
+	if res != nil {
+		defer res.Close()
+	}
+	if got := exitCode(res, err); got != exitFailed {
+		t.Fatalf("flaky run: exit code %d (err %v), want %d", got, err, exitFailed)
+	}
+	band := res.FailedIntervals()
+
+	healthy.Store(true)
+	res, err = run(context.Background(), append(args, "-retry-from", failed, "-out", filepath.Join(dir, "retried.ndjson")))
+	if res != nil {
+		defer res.Close()
+	}
+	if got := exitCode(res, err); got != exitOK {
+		t.Fatalf("retry: exit code %d (err %v), want %d", got, err, exitOK)
+	}
+	collected := map[ProductID]bool{}
+	for _, p := range res.Products {
+		collected[p.ID] = true
+	}
+	want := 0
+	for _, p := range catalog.products {
+		if validPrice(p) && slices.ContainsFunc(band, func(i Interval) bool { return i[0] <= p.Price && p.Price < i[1] }) {
+			want++
+			if !collected[p.ID] {
+				t.Errorf("product %s at %v, in a failed interval, not collected on retry", p.ID, p.Price)
+			}
+		}
+	}
+	if want == 0 {
+		t.Fatal("no product in the failed intervals")
+	}
+	// Nothing failed this time: the file is removed.
+	if _, err := os.Stat(failed); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("%s left after the retry: %v", failed, err)
+	}
+}
+
+// capture redirects stdout, stderr and the log to buffers until the
+// returned func is called.
+func capture(t *testing.T) (stdout, stderr *bytes.Buffer, restore func()) {
+	t.Helper()
+	stdout, stderr = new(bytes.Buffer), new(bytes.Buffer)
+	var wg sync.WaitGroup
+	redirect := func(f **os.File, buf *bytes.Buffer) func() {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		orig := *f
+		*f = w
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			io.Copy(buf, r)
+		}()
+		return func() {
+			*f = orig
+			w.Close()
+		}
+	}
+	restoreStdout := redirect(&os.Stdout, stdout)
+	restoreStderr := redirect(&os.Stderr, stderr)
+	log.SetOutput(os.Stderr)
+	return stdout, stderr, func() {
+		restoreStdout()
+		restoreStderr()
+		log.SetOutput(os.Stderr)
+		wg.Wait()
+		// Set by -quiet and -v.
+		slog.SetLogLoggerLevel(slog.LevelInfo)
+	}
+}
+
+func TestStdoutIsNDJSON(t *testing.T) {
+	for _, mode := range []string{"-vv", "-quiet"} {
+		stdout, stderr, restore := capture(t)
+		res, err := run(context.Background(), []string{"-simulate", "3000", "-rps", "1e6", mode})
+		restore()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Close()
+
+		lines := 0
+		for dec := json.NewDecoder(stdout); dec.More(); lines++ {
+			var p Product
+			if err := dec.Decode(&p); err != nil || p.ID == "" {
+				t.Fatalf("%s: stdout line %d isn't a product: %v", mode, lines+1, err)
+			}
+		}
+		if lines != res.Collected {
+			t.Errorf("%s: %d products on stdout, want %d", mode, lines, res.Collected)
+		}
+		if quiet := mode == "-quiet"; quiet != (stderr.Len() == 0) {
+			t.Errorf("%s: %d bytes on stderr", mode, stderr.Len())
+		}
+	}
+}
+
+func TestIntervalKey(t *testing.T) {
+	third := float32(maxPrice) / 3
+	for _, tc := range []struct {
+		a, b      Interval
+		precision int
+		same      bool
+	}{
+		{Interval{third, 2 * third}, Interval{33333.33, 66666.66}, 2, true},
+		{Interval{third, 2 * third}, Interval{33333.33, 66666.66}, 3, false},
+		{Interval{9.999, 20}, Interval{10, 20}, 2, true},
+		{Interval{9.99, 20}, Interval{10, 20}, 2, false},
+		{Interval{10, 20}, Interval{10, 20.01}, 2, false},
+		{Interval{10, 20}, Interval{10, 20.01}, 1, true},
+	} {
+		ka, kb := intervalKey(tc.a, tc.precision), intervalKey(tc.b, tc.precision)
+		if (ka == kb) != tc.same {
+			t.Errorf("precision %d: %v keyed %q, %v keyed %q", tc.precision, tc.a, ka, tc.b, kb)
+		}
+	}
+}
+
+func TestProductID(t *testing.T) {
+	for _, tc := range []struct {
+		json string
+		id   ProductID
+		back string // marshalled again
+	}{
+		{`123`, "123", `123`},
+		{`"SKU-00123"`, "SKU-00123", `"SKU-00123"`},
+		{`"123"`, "123", `123`},
+		{`"00123"`, "00123", `"00123"`},
+		{`12345678901234567890`, "12345678901234567890", `"12345678901234567890"`},
+	} {
+		var id ProductID
+		if err := json.Unmarshal([]byte(tc.json), &id); err != nil || id != tc.id {
+			t.Errorf("%s decoded to %q (err %v), want %q", tc.json, id, err, tc.id)
+			continue
+		}
+		if back, err := json.Marshal(id); err != nil || string(back) != tc.back {
+			t.Errorf("%s encoded to %s (err %v), want %s", tc.json, back, err, tc.back)
+		}
+	}
+	for _, bad := range []string{`true`, `{}`, `[1]`} {
+		var id ProductID
+		if err := json.Unmarshal([]byte(bad), &id); err == nil {
+			t.Errorf("%s decoded to %q", bad, id)
+		}
+	}
+
+	// Both kinds in one response, a number and its string deduped.
+	var res Response
+	body := `{"products": [{"id": 123, "price": 1}, {"id": "SKU-00123", "price": 2}, {"id": "123", "price": 1}]}`
+	if err := json.Unmarshal([]byte(body), &res); err != nil {
+		t.Fatal(err)
+	}
+	var ids []ProductID
+	for _, p := range collect([]foundProducts{{products: res.Products, interval: Interval{0, 10}}}, 0) {
+		ids = append(ids, p.ID)
+	}
+	if !slices.Equal(ids, []ProductID{"123", "SKU-00123"}) {
+		t.Errorf("collected %q, want 123 and SKU-00123", ids)
+	}
+}
+
+// collect runs the collector on batches of products, sized for expected.
+func collect(batches []foundProducts, expected int) []Product {
+	c := make(chan foundProducts, len(batches))
+	for _, b := range batches {
+		c <- b
+	}
+	close(c)
+	g, ctx := newGroup(context.Background())
+	pl := getProductsList(ctx, c, 0, expected, func() {}, nil, nil, true, nil, g)
+	g.Wait()
+	return pl.products
+}
+
+// responses splits catalog into batches of apiLimit products, like the
+// responses that would have returned them.
+func responses(catalog *Catalog) []foundProducts {
+	var batches []foundProducts
+	for i := 0; i < len(catalog.products); i += apiLimit {
+		products := catalog.products[i:min(i+apiLimit, len(catalog.products))]
+		batches = append(batches, foundProducts{products: products, interval: Interval{products[0].Price, products[len(products)-1].Price}})
+	}
+	return batches
+}
+
+func TestCollectPresized(t *testing.T) {
+	catalog := testCatalog(20_000)
+	batches := responses(catalog)
+	want := collect(batches, 0)
+	if len(want) != catalog.Valid() {
+		t.Fatalf("collected %d products, want %d", len(want), catalog.Valid())
+	}
+	for _, expected := range []int{catalog.Len(), catalog.Len() / 3, 3 * catalog.Len()} {
+		if got := collect(batches, expected); !slices.Equal(got, want) {
+			t.Errorf("sized for %d: collected %d products, differing from unsized", expected, len(got))
+		}
+	}
+}
+
+// BenchmarkCollect collects 100k products sized for the total up front, or
+// grown as they come.
+func BenchmarkCollect(b *testing.B) {
+	batches := responses(testCatalog(100_000))
+	for _, c := range []struct {
+		name     string
+		expected int
+	}{{"presized", 100_000}, {"grown", 0}} {
+		b.Run(c.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for range b.N {
+				collect(batches, c.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+// Option adjusts a Config in NewScraper, failing on invalid values.
+type Option func(*Config) error
+
+// BasicAuth is the user and password of HTTP Basic authentication. It
+// prints with the password masked.
+type BasicAuth struct {
+	User     string
+	Password string
+}
+
+func (a BasicAuth) String() string {
+	return a.User + ":****"
+}
+
+// WithBasicAuth authenticates every request with HTTP Basic auth.
+func WithBasicAuth(user, password string) Option {
+	return func(cfg *Config) error {
+		if user == "" {
+			return errors.New("basic auth needs a user")
+		}
+		cfg.BasicAuth = &BasicAuth{User: user, Password: password}
+		return nil
+	}
+}
+
+// WithBearerToken authenticates every request with a bearer token, e.g.
+// an API key.
+func WithBearerToken(token string) Option {
+	return func(cfg *Config) error {
+		if token == "" {
+			return errors.New("empty bearer token")
+		}
+		cfg.BearerToken = token
+		return nil
+	}
+}
+
+// WithBaseURL sets the products endpoint, which must be an absolute http(s)
+// URL. Its path and query are kept; the scraper adds its params to them.
+func WithBaseURL(raw string) Option {
+	return func(cfg *Config) error {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return fmt.Errorf("invalid base URL %q: %w", raw, err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return fmt.Errorf("invalid base URL %q: scheme must be http or https", raw)
+		}
+		if u.Host == "" {
+			return fmt.Errorf("invalid base URL %q: missing host", raw)
+		}
+		cfg.BaseURL = u
+		return nil
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestWithBaseURL(t *testing.T) {
+	for _, tc := range []struct {
+		name, raw string
+		ok        bool
+		want      url.Values // the query sent for Interval{1, 2}
+	}{
+		{"valid", "https://shop.test/api/products", true, url.Values{minPriceParam: {"1"}, maxPriceParam: {"2"}}},
+		{"with a query", "https://shop.test/products?lang=en&minPrice=5", true, url.Values{"lang": {"en"}, minPriceParam: {"1"}, maxPriceParam: {"2"}}},
+		{"malformed", "https://shop test/%zz", false, nil},
+		{"relative", "/products", false, nil},
+		{"not http", "ftp://shop.test/products", false, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := NewScraper(testConfig(nil), WithBaseURL(tc.raw))
+			if (err == nil) != tc.ok {
+				t.Fatalf("err %v, want one: %v", err, !tc.ok)
+			}
+			if err != nil {
+				return
+			}
+			u, err := url.Parse(buildURL(s.cfg, Interval{1, 2}, pageRef{}, nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := u.Query(); got.Encode() != tc.want.Encode() {
+				t.Errorf("query %s, want %s", got.Encode(), tc.want.Encode())
+			}
+			if base, _ := url.Parse(tc.raw); u.Host != base.Host || u.Path != base.Path {
+				t.Errorf("requested %s, want %s", u, tc.raw)
+			}
+		})
+	}
+}
+
+func TestWithBasicAuth(t *testing.T) {
+	catalog := testCatalog(3000)
+	// "alice:p@ss:w0rd", the password holding a colon.
+	const want = "Basic YWxpY2U6cEBzczp3MHJk"
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	cfg := testConfig(DoerFunc(func(req *http.Request) (*http.Response, error) {
+		if got := req.Header.Get("Authorization"); got != want {
+			t.Errorf("Authorization %q, want %q", got, want)
+			return simulatedResponse(req, http.StatusUnauthorized, nil), nil
+		}
+		return catalog.Do(req)
+	}))
+	cfg.Logger = logger
+	cfg.Middleware = []Middleware{Logging(logger, nil)}
+	s, err := NewScraper(cfg, WithBaseURL("http://shop.test/products"), WithBasicAuth("alice", "p@ss:w0rd"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := s.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Complete || len(res.Products) != catalog.Valid() {
+		t.Errorf("complete %v with %d products, want %d", res.Complete, len(res.Products), catalog.Valid())
+	}
+	if l := logs.String(); strings.Contains(l, "p@ss") || strings.Contains(l, "YWxpY2U6") {
+		t.Error("credentials logged")
+	}
+	if got := fmt.Sprint(cfg.BasicAuth); got != "alice:****" {
+		t.Errorf("basic auth prints as %q", got)
+	}
+
+	// One way to authenticate at a time, with a user.
+	cfg = testConfig(catalog)
+	cfg.BearerToken = "secret"
+	if _, err := NewScraper(cfg, WithBaseURL("http://shop.test/products"), WithBasicAuth("alice", "x")); err == nil {
+		t.Error("basic auth and a bearer token both accepted")
+	}
+	if _, err := NewScraper(testConfig(catalog), WithBaseURL("http://shop.test/products"), WithBasicAuth("", "x")); err == nil {
+		t.Error("basic auth without a user accepted")
+	}
+}
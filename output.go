@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// OutputBackend opens the destination of -out URIs of one scheme. With
+// appendTo the products are added to what is there (see Config.SinceID),
+// and appended tells whether there was anything. The writer's Close
+// commits the output, e.g. uploads it.
+type OutputBackend interface {
+	Open(u *url.URL, appendTo bool) (w io.WriteCloser, appended bool, err error)
+}
+
+// outputBackends are the backends of -out by URI scheme.
+var outputBackends = map[string]OutputBackend{
+	"stdout": stdoutOutput{},
+	"file":   fileOutput{},
+	"s3":     s3Output{},
+}
+
+// RegisterOutput makes -out open the URIs of scheme with b, replacing the
+// backend it had if any. It must be called before the run, e.g. from init.
+func RegisterOutput(scheme string, b OutputBackend) {
+	outputBackends[scheme] = b
+}
+
+// parseOutput reads an -out value: "-" or "stdout", a URI of a registered
+// scheme, or else a file path.
+func parseOutput(out string) (*url.URL, error) {
+	switch {
+	case out == "-" || out == "stdout":
+		return &url.URL{Scheme: "stdout"}, nil
+	case !strings.Contains(out, "://"):
+		return &url.URL{Scheme: "file", Path: out}, nil
+	}
+	u, err := url.Parse(out)
+	if err != nil {
+		return nil, fmt.Errorf("invalid output %q: %w", out, err)
+	}
+	if _, ok := outputBackends[u.Scheme]; !ok {
+		return nil, fmt.Errorf("invalid output %q: no backend for %s://", out, u.Scheme)
+	}
+	return u, nil
+}
+
+// openOutput opens the destination of an -out value.
+func openOutput(out string, appendTo bool) (io.WriteCloser, bool, error) {
+	u, err := parseOutput(out)
+	if err != nil {
+		return nil, false, err
+	}
+	return outputBackends[u.Scheme].Open(u, appendTo)
+}
+
+// localOutput is the path of an -out file, if it is one.
+func localOutput(out string) (string, bool) {
+	u, err := parseOutput(out)
+	if err != nil || u.Scheme != "file" {
+		return "", false
+	}
+	return filePath(u), true
+}
+
+// filePath is the path of a file URI; file://name is relative.
+func filePath(u *url.URL) string {
+	return u.Host + u.Path
+}
+
+type stdoutOutput struct{}
+
+func (stdoutOutput) Open(*url.URL, bool) (io.WriteCloser, bool, error) {
+	return nopWriteCloser{os.Stdout}, false, nil
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+type fileOutput struct{}
+
+func (fileOutput) Open(u *url.URL, appendTo bool) (io.WriteCloser, bool, error) {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if appendTo {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	f, err := os.OpenFile(filePath(u), flags, 0o644)
+	if err != nil {
+		return nil, false, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, false, err
+	}
+	return f, fi.Size() > 0, nil
+}
+
+// s3Output stands in for S3, which isn't built in: register a backend for
+// s3 with an SDK to write there.
+type s3Output struct{}
+
+func (s3Output) Open(u *url.URL, _ bool) (io.WriteCloser, bool, error) {
+	return nil, false, fmt.Errorf("output %s: no S3 client built in, register an OutputBackend for s3", u.Redacted())
+}
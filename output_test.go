@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/url"
+	"testing"
+)
+
+// memOutput is an OutputBackend keeping what was written, by URI.
+type memOutput struct {
+	written map[string]*memWriter
+}
+
+type memWriter struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (w *memWriter) Close() error {
+	w.closed = true
+	return nil
+}
+
+func (b *memOutput) Open(u *url.URL, _ bool) (io.WriteCloser, bool, error) {
+	w := &memWriter{}
+	b.written[u.String()] = w
+	return w, false, nil
+}
+
+func TestRegisterOutput(t *testing.T) {
+	mem := &memOutput{written: map[string]*memWriter{}}
+	RegisterOutput("mem", mem)
+	defer delete(outputBackends, "mem")
+
+	_, _, restore := capture(t)
+	res, err := run(context.Background(), []string{"-simulate", "3000", "-rps", "1e6", "-quiet", "-out", "mem://bucket/products.ndjson"})
+	restore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Close()
+
+	w, ok := mem.written["mem://bucket/products.ndjson"]
+	if !ok || !w.closed {
+		t.Fatalf("outputs %v, want mem://bucket/products.ndjson written and closed", mem.written)
+	}
+	lines := 0
+	for dec := json.NewDecoder(w); dec.More(); lines++ {
+		var p Product
+		if err := dec.Decode(&p); err != nil || p.ID == "" {
+			t.Fatalf("line %d isn't a product: %v", lines+1, err)
+		}
+	}
+	if lines != res.Collected {
+		t.Errorf("%d products written, want %d", lines, res.Collected)
+	}
+}
+
+func TestParseOutput(t *testing.T) {
+	for _, tc := range []struct {
+		out    string
+		scheme string
+		path   string // of files
+		ok     bool
+	}{
+		{"-", "stdout", "", true},
+		{"stdout", "stdout", "", true},
+		{"out/products.ndjson", "file", "out/products.ndjson", true},
+		{"file:///tmp/products.ndjson", "file", "/tmp/products.ndjson", true},
+		{"file://products.ndjson", "file", "products.ndjson", true},
+		{"s3://bucket/key", "s3", "", true},
+		{"gopher://host/products", "", "", false},
+	} {
+		u, err := parseOutput(tc.out)
+		if (err == nil) != tc.ok {
+			t.Errorf("%s: err %v, want one: %v", tc.out, err, !tc.ok)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if u.Scheme != tc.scheme || tc.scheme == "file" && filePath(u) != tc.path {
+			t.Errorf("%s: %s output at %q, want %s at %q", tc.out, u.Scheme, filePath(u), tc.scheme, tc.path)
+		}
+	}
+	if _, _, err := openOutput("s3://bucket/key", false); err == nil {
+		t.Error("s3 output opened without a registered backend")
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// Pause stops sending new requests until Resume. Requests in flight
+// complete and the queue is kept, so the run picks up where it left off.
+func (s *Scraper) Pause() {
+	s.pauseMu.Lock()
+	defer s.pauseMu.Unlock()
+	if s.resumed != nil {
+		return
+	}
+	s.resumed = make(chan struct{})
+	s.pausedAt = s.cfg.Clock.Now()
+	s.cfg.Logger.Info("paused")
+}
+
+func (s *Scraper) Resume() {
+	s.pauseMu.Lock()
+	defer s.pauseMu.Unlock()
+	if s.resumed == nil {
+		return
+	}
+	close(s.resumed)
+	s.resumed = nil
+	s.pausedFor += s.cfg.Clock.Now().Sub(s.pausedAt)
+	s.cfg.Logger.Info("resumed")
+}
+
+// paused reports whether the scraper is paused and for how long it has
+// been paused in total, the current pause included.
+func (s *Scraper) paused() (bool, time.Duration) {
+	s.pauseMu.Lock()
+	defer s.pauseMu.Unlock()
+	if s.resumed == nil {
+		return false, s.pausedFor
+	}
+	return true, s.pausedFor + s.cfg.Clock.Now().Sub(s.pausedAt)
+}
+
+// waitResumed blocks while the scraper is paused.
+func (s *Scraper) waitResumed(ctx context.Context) error {
+	s.pauseMu.Lock()
+	resumed := s.resumed
+	s.pauseMu.Unlock()
+	if resumed == nil {
+		return nil
+	}
+
+	select {
+	case <-resumed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// eta extrapolates the time left from the pace of finished intervals,
+// leaving out time spent paused. It is 0 until something finished.
+func (s *Scraper) eta() time.Duration {
+	done := s.done.Load()
+	if done == 0 {
+		return 0
+	}
+	_, pausedFor := s.paused()
+	active := s.cfg.Clock.Now().Sub(s.startTime) - pausedFor
+	return time.Duration(float64(active) / float64(done) * float64(s.queue.depth()))
+}
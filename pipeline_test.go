@@ -0,0 +1,328 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// afterRequests runs f instead of the request once n were sent, returning
+// its response.
+func afterRequests(n int64, f func(req *http.Request) (*http.Response, error)) Middleware {
+	var sent atomic.Int64
+	return func(next Doer) Doer {
+		return DoerFunc(func(req *http.Request) (*http.Response, error) {
+			if sent.Add(1) > n {
+				return f(req)
+			}
+			return next.Do(req)
+		})
+	}
+}
+
+type failingSink struct{ after, written int }
+
+func (s *failingSink) WriteProduct(Product) error {
+	if s.written++; s.written > s.after {
+		return errors.New("disk full")
+	}
+	return nil
+}
+
+func (s *failingSink) Flush() error { return nil }
+
+// goroutines lists the goroutines running the package's code, but the
+// caller's, by the "goroutine N" header of their stacks.
+func goroutines() map[string]string {
+	buf := make([]byte, 1<<16)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	// The package is named by its import path in stacks, even as main.
+	pkg := strings.TrimSuffix(runtime.FuncForPC(reflect.ValueOf(testConfig).Pointer()).Name(), "testConfig")
+	stacks := strings.Split(string(buf), "\n\n")
+	gs := map[string]string{}
+	for _, stack := range stacks[1:] {
+		header, _, _ := strings.Cut(stack, " [")
+		if strings.Contains(stack, "\n"+pkg) {
+			gs[header] = stack
+		}
+	}
+	return gs
+}
+
+// checkLeaks fails t if goroutines of the package are left that weren't
+// there before, once they had a moment to return.
+func checkLeaks(t *testing.T, before map[string]string) {
+	t.Helper()
+	var leaked []string
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		leaked = leaked[:0]
+		for g, stack := range goroutines() {
+			if _, ok := before[g]; !ok {
+				leaked = append(leaked, stack)
+			}
+		}
+		if len(leaked) == 0 || time.Now().After(deadline) {
+			break
+		}
+	}
+	for _, stack := range leaked {
+		t.Errorf("leaked goroutine:\n%s", stack)
+	}
+}
+
+// TestRunStops runs the pipeline through each way it stops, meant for
+// -race: every stage must have stopped, leaving no goroutine behind, and
+// the channels the run owns be closed when Run returns.
+func TestRunStops(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for _, tc := range []struct {
+		name      string
+		ctx       context.Context
+		setup     func(cfg *Config)
+		wantErr   bool
+		truncated bool
+	}{
+		{"complete", context.Background(), func(*Config) {}, false, false},
+		{"cancelled", ctx, func(cfg *Config) {
+			cfg.Middleware = append(cfg.Middleware, afterRequests(5, func(req *http.Request) (*http.Response, error) {
+				cancel()
+				return nil, req.Context().Err()
+			}))
+		}, false, true},
+		{"max products", context.Background(), func(cfg *Config) { cfg.MaxProducts = 2500 }, false, true},
+		{"fatal sink error", context.Background(), func(cfg *Config) { cfg.Sink = &failingSink{after: 100} }, true, true},
+		{"revoked credentials", context.Background(), func(cfg *Config) {
+			cfg.Middleware = append(cfg.Middleware, afterRequests(5, func(*http.Request) (*http.Response, error) {
+				return &http.Response{StatusCode: http.StatusForbidden, Header: http.Header{}, Body: http.NoBody}, nil
+			}))
+		}, true, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			defer checkLeaks(t, goroutines())
+			cfg := testConfig(testCatalog(20_000))
+			cfg.MaxWorkers = 8
+			tc.setup(cfg)
+			s := testScraper(t, cfg)
+			events, failed := s.Events(), s.FailedIntervals()
+			var drained sync.WaitGroup
+			drained.Add(2)
+			go func() {
+				defer drained.Done()
+				for range events {
+				}
+			}()
+			go func() {
+				defer drained.Done()
+				for range failed {
+				}
+			}()
+			res, err := s.Run(tc.ctx)
+			// Both close when Run returns.
+			drained.Wait()
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("err %v, want one: %v", err, tc.wantErr)
+			}
+			if res == nil {
+				return
+			}
+			if res.Truncated != tc.truncated {
+				t.Errorf("truncated %v (%s), want %v", res.Truncated, res.Stats.StopReason, tc.truncated)
+			}
+			if n := s.workers.Load(); n != 0 {
+				t.Errorf("%d workers left", n)
+			}
+		})
+	}
+}
+
+// TestFetchStageCancelled cancels the run partway: the fetch stage stops,
+// the collect stage still takes in everything the workers handed over.
+func TestFetchStageCancelled(t *testing.T) {
+	defer checkLeaks(t, goroutines())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cfg := testConfig(testCatalog(20_000))
+	cfg.MaxWorkers = 8
+	cfg.ProductBuffer = 2 * apiLimit
+	cfg.Middleware = append(cfg.Middleware, afterRequests(50, func(req *http.Request) (*http.Response, error) {
+		cancel()
+		return nil, req.Context().Err()
+	}))
+	res, err := testScraper(t, cfg).Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Truncated || res.Stats.StopReason != context.Canceled.Error() {
+		t.Errorf("truncated %v, stopped %q, want a cancelled run", res.Truncated, res.Stats.StopReason)
+	}
+	if got := int64(res.Collected + len(res.InvalidPrices)); got == 0 || got != res.Stats.Products {
+		t.Errorf("%d products collected of the %d handed over", got, res.Stats.Products)
+	}
+}
+
+// TestCollectStageCancelled cancels the collect stage on its own, its
+// channels left open: the collector and the error list stop, keeping what
+// they took in.
+func TestCollectStageCancelled(t *testing.T) {
+	catalog := testCatalog(5000)
+	batches := responses(catalog)
+	c := make(chan foundProducts)
+	e := make(chan FailedInterval)
+	g, ctx := newGroup(context.Background())
+	pl := getProductsList(ctx, c, 0, 0, func() {}, nil, nil, true, nil, g)
+	el := getErrorsList(ctx, e, g)
+	c <- batches[0]
+	c <- batches[1]
+	e <- FailedInterval{Interval: Interval{1, 2}}
+
+	g.cancel(nil)
+	done := make(chan error)
+	go func() { done <- g.Wait() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("collect stage didn't stop")
+	}
+	want := 0
+	for _, b := range batches[:2] {
+		for _, p := range b.products {
+			if validPrice(p) {
+				want++
+			}
+		}
+	}
+	if pl.collected != want || len(el.intervals) != 1 {
+		t.Errorf("%d products and %d failures kept, want %d and 1", pl.collected, len(el.intervals), want)
+	}
+}
+
+func TestCredentialsRevoked(t *testing.T) {
+	cfg := testConfig(testCatalog(5000))
+	cfg.Middleware = append(cfg.Middleware, afterRequests(3, func(req *http.Request) (*http.Response, error) {
+		return simulatedResponse(req, http.StatusUnauthorized, nil), nil
+	}))
+	res, err := testScraper(t, cfg).Run(context.Background())
+	var se *StatusError
+	if !errors.As(err, &se) || se.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("err %v, want the 401", err)
+	}
+	// The interval being requested is named.
+	if !strings.Contains(err.Error(), "requesting [") {
+		t.Errorf("err %q doesn't say what was in flight", err)
+	}
+	if res != nil && (res.Complete || len(res.Uncovered) == 0) {
+		t.Errorf("complete %v, %d intervals uncovered", res.Complete, len(res.Uncovered))
+	}
+}
+
+func TestRunTwice(t *testing.T) {
+	s := testScraper(t, testConfig(testCatalog(100)))
+	if _, err := s.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Run(context.Background()); !errors.Is(err, errRanTwice) {
+		t.Errorf("second run: %v, want %v", err, errRanTwice)
+	}
+}
+
+// TestWorkerPanics panics in the client on a share of requests, and always
+// on one interval: the others are retried to completion, that one fails,
+// and the run doesn't hang for lack of workers.
+func TestWorkerPanics(t *testing.T) {
+	catalog := testCatalog(20_000)
+	var sent atomic.Int64
+	cfg := testConfig(DoerFunc(func(req *http.Request) (*http.Response, error) {
+		q := req.URL.Query()
+		lo, _ := strconv.ParseFloat(q.Get(minPriceParam), 32)
+		hi, _ := strconv.ParseFloat(q.Get(maxPriceParam), 32)
+		// The probes of the total run outside of the workers.
+		probe := lo == 0 && hi == float64(maxPrice)
+		if n := sent.Add(1); !probe && (n%3 == 0 || lo == 50_000) {
+			panic("injected")
+		}
+		return catalog.Do(req)
+	}))
+	cfg.MaxWorkers = 4
+	cfg.Intervals = []Interval{{0, 50_000}, {50_000, maxPrice}}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	res, err := testScraper(t, cfg).Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ctx.Err() != nil {
+		t.Fatal("run hung")
+	}
+	if res.Stats.Panics == 0 {
+		t.Error("no panic counted")
+	}
+	if got := res.FailedIntervals(); !slices.Equal(got, []Interval{{50_000, maxPrice}}) {
+		t.Errorf("failed %v, want the always panicking interval", got)
+	}
+	want := 0
+	for _, p := range catalog.products {
+		if validPrice(p) && p.Price < 50_000 {
+			want++
+		}
+	}
+	if len(res.Products) != want {
+		t.Errorf("%d products, want %d", len(res.Products), want)
+	}
+}
+
+// TestRunPanics panics in the probe of the total, on Run's own goroutine:
+// the panic goes through once the stages stopped, rather than Run hanging
+// on them.
+func TestRunPanics(t *testing.T) {
+	defer checkLeaks(t, goroutines())
+	cfg := testConfig(DoerFunc(func(*http.Request) (*http.Response, error) { panic("injected") }))
+	s := testScraper(t, cfg)
+	defer func() {
+		if r := recover(); r != "injected" {
+			t.Errorf("recovered %v, want the injected panic", r)
+		}
+	}()
+	s.Run(context.Background())
+}
+
+// TestManyScrapers runs short-lived scrapers one after the other, the way
+// an embedder would, over every early return of Run too: none may leave a
+// goroutine behind.
+func TestManyScrapers(t *testing.T) {
+	defer checkLeaks(t, goroutines())
+	for i := range 30 {
+		cfg := testConfig(testCatalog(1000))
+		cfg.MaxWorkers = 4
+		switch i % 3 {
+		case 1:
+			// Fails on the probe of the total.
+			cfg.Client = DoerFunc(func(req *http.Request) (*http.Response, error) {
+				return simulatedResponse(req, http.StatusBadRequest, nil), nil
+			})
+		case 2:
+			cfg.Intervals = []Interval{{0, 5000}, {5000, maxPrice}}
+		}
+		testScraper(t, cfg).Run(context.Background())
+	}
+}
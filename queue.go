@@ -0,0 +1,129 @@
+package main
+
+import (
+	"container/heap"
+	"math/rand"
+	"sync"
+)
+
+// Order decides which pending interval is dispatched next.
+type Order int
+
+const (
+	// WidestFirst discovers where splitting is needed as early as possible.
+	WidestFirst Order = iota
+	NarrowestFirst
+	FIFO
+)
+
+type queueItem struct {
+	info IntervalInfo
+	seq  uint64
+}
+
+type intervalHeap struct {
+	items []queueItem
+	order Order
+	// precision is that of Config.PricePrecision: widths that only differ
+	// past it, in float32 rounding, are the same width, kept in push order.
+	precision int
+}
+
+func width(i Interval) float32 {
+	return i[1] - i[0]
+}
+
+func (h *intervalHeap) Len() int { return len(h.items) }
+
+func (h *intervalHeap) Less(i, j int) bool {
+	a, b := h.items[i], h.items[j]
+	switch wa, wb := width(a.info.interval), width(b.info.interval); {
+	case h.order == FIFO || sameBound(wa, wb, h.precision):
+	case h.order == WidestFirst:
+		return wa > wb
+	case h.order == NarrowestFirst:
+		return wa < wb
+	}
+	return a.seq < b.seq
+}
+
+func (h *intervalHeap) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
+
+func (h *intervalHeap) Push(x any) { h.items = append(h.items, x.(queueItem)) }
+
+func (h *intervalHeap) Pop() any {
+	n := len(h.items)
+	it := h.items[n-1]
+	h.items = h.items[:n-1]
+	return it
+}
+
+// intervalQueue replaces a plain FIFO channel so pending intervals can be
+// dispatched in a configurable order. push never blocks.
+type intervalQueue struct {
+	heap   intervalHeap
+	seq    uint64
+	rng    *rand.Rand // nil keeps retries at the back of the line
+	closed bool
+	mu     sync.Mutex
+	cond   *sync.Cond
+}
+
+func newIntervalQueue(order Order, precision int, rng *rand.Rand) *intervalQueue {
+	q := &intervalQueue{heap: intervalHeap{order: order, precision: precision}, rng: rng}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+func (q *intervalQueue) push(info IntervalInfo) {
+	q.mu.Lock()
+	seq := q.seq
+	if info.nRetry > 0 && q.rng != nil {
+		// Interleave the retry among pending work instead of appending it,
+		// so a failing region doesn't get retried in a burst.
+		seq -= uint64(q.rng.Intn(q.heap.Len() + 1))
+	}
+	heap.Push(&q.heap, queueItem{info: info, seq: seq})
+	q.seq++
+	q.mu.Unlock()
+	q.cond.Signal()
+}
+
+// pop blocks until an interval is available or the queue is closed.
+func (q *intervalQueue) pop() (IntervalInfo, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for q.heap.Len() == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if q.heap.Len() == 0 {
+		return IntervalInfo{}, false
+	}
+	return heap.Pop(&q.heap).(queueItem).info, true
+}
+
+func (q *intervalQueue) depth() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.heap.Len()
+}
+
+func (q *intervalQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.cond.Broadcast()
+}
+
+// dispatch feeds workers in queue order and closes out once the queue is
+// closed and drained.
+func (q *intervalQueue) dispatch(out chan<- IntervalInfo) {
+	for {
+		info, ok := q.pop()
+		if !ok {
+			close(out)
+			return
+		}
+		out <- info
+	}
+}
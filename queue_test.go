@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"slices"
+	"testing"
+)
+
+// BenchmarkOrder scrapes a catalog in each Order with one worker, tracking
+// the estimate of the intervals to go through that the ETA relies on, the
+// intervals done and queued. It reports how far into the run, as a share
+// of the requests, the estimate settled within 10% of the final count, and
+// how far off it was on average.
+func BenchmarkOrder(b *testing.B) {
+	catalog := testCatalog(50_000)
+	for _, o := range []struct {
+		name  string
+		order Order
+	}{{"widest", WidestFirst}, {"narrowest", NarrowestFirst}, {"fifo", FIFO}} {
+		b.Run(o.name, func(b *testing.B) {
+			var settled, meanErr float64
+			for range b.N {
+				var s *Scraper
+				var estimates []int64
+				cfg := testConfig(DoerFunc(func(req *http.Request) (*http.Response, error) {
+					if s.queue != nil {
+						estimates = append(estimates, s.done.Load()+int64(s.queue.depth())+1)
+					}
+					return catalog.Do(req)
+				}))
+				cfg.MaxWorkers = 1
+				cfg.Order = o.order
+				s = testScraper(b, cfg)
+				if _, err := s.Run(context.Background()); err != nil {
+					b.Fatal(err)
+				}
+				final := s.done.Load()
+				i := len(estimates)
+				for i > 0 && 10*abs(estimates[i-1]-final) <= final {
+					i--
+				}
+				settled = float64(i) / float64(len(estimates))
+				meanErr = 0
+				for _, e := range estimates {
+					meanErr += float64(abs(e-final)) / float64(final) / float64(len(estimates))
+				}
+			}
+			b.ReportMetric(100*settled, "settled-%")
+			b.ReportMetric(100*meanErr, "error-%")
+		})
+	}
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// TestWidestFirstShuffle queues a shuffled plan whose widths only differ
+// in float32 rounding: widest first, each seed still gives its own order.
+func TestWidestFirstShuffle(t *testing.T) {
+	plan := planIntervals(4000, 1000, Interval{0.01, 99_999.99})
+	widths := map[float32]bool{}
+	for _, i := range plan {
+		widths[width(i)] = true
+	}
+	if len(widths) != len(plan) {
+		t.Fatalf("widths %v, want each differing in rounding", widths)
+	}
+
+	order := func(seed int64) []Interval {
+		shuffled := slices.Clone(plan)
+		rng := rand.New(rand.NewSource(seed))
+		rng.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+		q := newIntervalQueue(WidestFirst, 2, nil)
+		for _, i := range shuffled {
+			q.push(IntervalInfo{interval: i})
+		}
+		q.close()
+		var got []Interval
+		for info, ok := q.pop(); ok; info, ok = q.pop() {
+			got = append(got, info.interval)
+		}
+		return got
+	}
+	if first, again, other := order(1), order(1), order(2); !slices.Equal(first, again) || slices.Equal(first, other) {
+		t.Errorf("seed 1 gave %v then %v, seed 2 %v: want the same, then another", first, again, other)
+	}
+
+	// Wider by more than the precision still goes first.
+	q := newIntervalQueue(WidestFirst, 2, nil)
+	q.push(IntervalInfo{interval: Interval{0, 10}})
+	q.push(IntervalInfo{interval: Interval{10, 20.01}})
+	if info, _ := q.pop(); info.interval != (Interval{10, 20.01}) {
+		t.Errorf("popped %v first, want the wider one", info.interval)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// RateLimiter paces requests: Wait blocks until one may be sent.
+type RateLimiter interface {
+	Wait(ctx context.Context) error
+}
+
+// RateLimitObserver is implemented by limiters that adapt to the
+// remaining budget reported by the API.
+type RateLimitObserver interface {
+	Observe(remaining int, reset time.Time)
+}
+
+type tokenBucket struct {
+	tokens  chan struct{}
+	refresh time.Duration
+	// Observe pauses the bucket once the API reports reserve or fewer
+	// requests remaining.
+	reserve int
+	clock   Clock
+
+	mu     sync.Mutex
+	resume time.Time
+}
+
+// Limiters that can tell their current rate, in requests per second,
+// report it in the stats.
+type rater interface {
+	Rate() float64
+}
+
+// Limiters that can tell how many requests they would let through right
+// away guide the autoscaler.
+type tokenCounter interface {
+	available() int
+}
+
+// newTokenBucket returns a bucket of size tokens, refilled one every
+// refresh by refill.
+func newTokenBucket(size int, refresh time.Duration, clock Clock) *tokenBucket {
+	return &tokenBucket{tokens: make(chan struct{}, size), refresh: refresh, clock: clock}
+}
+
+// refill frees a token every refresh until done is closed.
+func (b *tokenBucket) refill(done <-chan struct{}) {
+	ticker := b.clock.NewTicker(b.refresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+			select {
+			case <-b.tokens:
+			case <-ticker.C():
+			case <-done:
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+func (b *tokenBucket) Wait(ctx context.Context) error {
+	b.mu.Lock()
+	pause := b.resume.Sub(b.clock.Now())
+	b.mu.Unlock()
+
+	if pause > 0 {
+		if err := sleep(ctx, b.clock, pause); err != nil {
+			return err
+		}
+	}
+
+	select {
+	case b.tokens <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (b *tokenBucket) Observe(remaining int, reset time.Time) {
+	if remaining > b.reserve {
+		return
+	}
+	b.mu.Lock()
+	if reset.After(b.resume) {
+		b.resume = reset
+	}
+	b.mu.Unlock()
+}
+
+// available is the number of tokens that could be taken right now.
+func (b *tokenBucket) available() int {
+	return cap(b.tokens) - len(b.tokens)
+}
+
+func (b *tokenBucket) Rate() float64 {
+	return float64(time.Second) / float64(b.refresh)
+}
+
+// slowStartFloor is the fraction of the target rate a ramp starts at.
+const slowStartFloor float64 = 0.1
+
+// slowStart wraps any RateLimiter, spacing requests so the rate climbs
+// linearly from a fraction of target to target over ramp. Once warmed up
+// it only defers to the wrapped limiter.
+type slowStart struct {
+	next   RateLimiter
+	target float64
+	ramp   time.Duration
+	clock  Clock
+	logger *slog.Logger
+
+	mu     sync.Mutex
+	start  time.Time
+	last   time.Time // when the last request was let through
+	logged float64   // last rate logged, to log the schedule in steps
+}
+
+func newSlowStart(next RateLimiter, target float64, ramp time.Duration, clock Clock, logger *slog.Logger) *slowStart {
+	l := &slowStart{next: next, target: target, ramp: ramp, clock: clock, logger: logger}
+	l.Restart()
+	return l
+}
+
+// Restart ramps up again from the floor rate, e.g. after the API recovered
+// from an outage.
+func (l *slowStart) Restart() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.start = l.clock.Now()
+	l.logged = 0
+	l.logger.Debug("slow start", "from", l.target*slowStartFloor, "to", l.target, "over", l.ramp)
+}
+
+// RestartWarmUp ramps the request rate up again over Config.WarmUp, as at
+// the start of the run. It does nothing without a warm-up.
+func (s *Scraper) RestartWarmUp() {
+	if l, ok := s.limiter.(*slowStart); ok {
+		l.Restart()
+	}
+}
+
+func (l *slowStart) rate(now time.Time) float64 {
+	f := float64(now.Sub(l.start)) / float64(l.ramp)
+	if f >= 1 {
+		return l.target
+	}
+	return l.target * (slowStartFloor + (1-slowStartFloor)*f)
+}
+
+func (l *slowStart) Rate() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate(l.clock.Now())
+}
+
+func (l *slowStart) Wait(ctx context.Context) error {
+	l.mu.Lock()
+	now := l.clock.Now()
+	r := l.rate(now)
+	var delay time.Duration
+	if r < l.target {
+		at := l.last.Add(time.Duration(float64(time.Second) / r))
+		if at.After(now) {
+			delay = at.Sub(now)
+			now = at
+		}
+	}
+	l.last = now
+	if r-l.logged >= l.target*slowStartFloor || (r == l.target && l.logged != r) {
+		l.logger.Debug("slow start rate", "rps", r)
+		l.logged = r
+	}
+	l.mu.Unlock()
+
+	if delay > 0 {
+		if err := sleep(ctx, l.clock, delay); err != nil {
+			return err
+		}
+	}
+	return l.next.Wait(ctx)
+}
+
+func (l *slowStart) Observe(remaining int, reset time.Time) {
+	if o, ok := l.next.(RateLimitObserver); ok {
+		o.Observe(remaining, reset)
+	}
+}
+
+func (l *slowStart) available() int {
+	if c, ok := l.next.(tokenCounter); ok {
+		return c.available()
+	}
+	return 0
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+type unlimited struct{}
+
+func (unlimited) Wait(context.Context) error { return nil }
+
+func TestSlowStartRestart(t *testing.T) {
+	clock := newFakeClock()
+	l := newSlowStart(unlimited{}, 100, time.Minute, clock, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if r := l.rate(clock.Now()); r != 100*slowStartFloor {
+		t.Fatalf("rate at start %v, want %v", r, 100*slowStartFloor)
+	}
+	clock.Advance(time.Minute)
+	if r := l.rate(clock.Now()); r != 100 {
+		t.Fatalf("rate after the ramp %v, want 100", r)
+	}
+	s := &Scraper{limiter: l}
+	s.RestartWarmUp()
+	if r := l.rate(clock.Now()); r != 100*slowStartFloor {
+		t.Errorf("rate after RestartWarmUp %v, want %v", r, 100*slowStartFloor)
+	}
+}
+
+// TestRateLimitHeaders scrapes an API allowing 25 requests a second, which
+// answers 429 past them: the scraper must pause on its headers instead.
+func TestRateLimitHeaders(t *testing.T) {
+	const limit = 25
+	catalog := testCatalog(10_000)
+	var mu sync.Mutex
+	var windowEnd time.Time
+	var sent, tooMany int
+	cfg := testConfig(DoerFunc(func(req *http.Request) (*http.Response, error) {
+		mu.Lock()
+		now := time.Now()
+		if now.After(windowEnd) {
+			windowEnd, sent = now.Add(time.Second), 0
+		}
+		sent++
+		remaining, reset := limit-sent, int(math.Ceil(windowEnd.Sub(now).Seconds()))
+		if remaining < 0 {
+			tooMany++
+		}
+		mu.Unlock()
+
+		if remaining < 0 {
+			return simulatedResponse(req, http.StatusTooManyRequests, nil), nil
+		}
+		resp, err := catalog.Do(req)
+		if err == nil {
+			resp.Header.Set(rateRemainingHeader, strconv.Itoa(remaining))
+			resp.Header.Set(rateResetHeader, strconv.Itoa(reset))
+		}
+		return resp, err
+	}))
+	cfg.MaxWorkers = 2
+	res, err := testScraper(t, cfg).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Complete || len(res.Products) != catalog.Valid() {
+		t.Errorf("complete %v with %d products, want %d", res.Complete, len(res.Products), catalog.Valid())
+	}
+	if res.Stats.Requests <= limit {
+		t.Errorf("%d requests, not enough to exhaust a window", res.Stats.Requests)
+	}
+	if tooMany > 0 {
+		t.Errorf("%d requests over the limit", tooMany)
+	}
+}
+
+// pacer is a RateLimiter on a fake clock that it moves on itself: the nth
+// request goes through n periods in, at once, until end, when it cancels
+// the run. Whatever waits for the clock, like the retry lane, sees the
+// time of the requests.
+type pacer struct {
+	clock  *fakeClock
+	every  time.Duration
+	end    time.Time
+	cancel context.CancelFunc
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+func (p *pacer) Wait(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.next.IsZero() {
+		p.next = p.clock.Now()
+	}
+	if !p.next.Before(p.end) {
+		p.cancel()
+		return context.Canceled
+	}
+	p.clock.Advance(p.next.Sub(p.clock.Now()))
+	p.next = p.next.Add(p.every)
+	return nil
+}
+
+// TestRetryLane fails every other interval that fits in a response on its
+// first attempt, over the first second of a run at 40 requests a second:
+// with the retries on a lane of 2 a second, fresh work gets all but the
+// few requests the lane let through.
+func TestRetryLane(t *testing.T) {
+	const window = time.Second
+	catalog := testCatalog(50_000)
+	run := func(fail bool) (fresh, retries int) {
+		var mu sync.Mutex
+		seen := map[string]bool{}
+		var leaves int
+		cfg := testConfig(DoerFunc(func(req *http.Request) (*http.Response, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if seen[req.URL.RawQuery] {
+				retries++
+				return catalog.Do(req)
+			}
+			seen[req.URL.RawQuery] = true
+			fresh++
+			// Failing the intervals that won't split holds no fresh work
+			// back.
+			q := req.URL.Query()
+			lo, _ := strconv.ParseFloat(q.Get(minPriceParam), 32)
+			hi, _ := strconv.ParseFloat(q.Get(maxPriceParam), 32)
+			from, _ := slices.BinarySearchFunc(catalog.products, float32(lo), cmpPrice)
+			to, _ := slices.BinarySearchFunc(catalog.products, float32(hi), cmpPrice)
+			if fail && to-from <= apiLimit {
+				if leaves++; leaves%2 == 0 {
+					return simulatedResponse(req, http.StatusServiceUnavailable, nil), nil
+				}
+			}
+			return catalog.Do(req)
+		}))
+		clock := newFakeClock()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		cfg.Clock = clock
+		cfg.RateLimiter = &pacer{clock: clock, every: window / 40, end: clock.Now().Add(window), cancel: cancel}
+		cfg.RetryRPS = 2
+		// Retries are interleaved among fresh work rather than queued
+		// behind it.
+		cfg.Shuffle = true
+		cfg.Backoff = BackoffFunc(func(int) time.Duration { return 0 })
+		if _, err := testScraper(t, cfg).Run(ctx); err != nil {
+			t.Fatal(err)
+		}
+		return fresh, retries
+	}
+
+	if fresh, retries := run(false); fresh != 40 || retries != 0 {
+		t.Errorf("%d fresh requests and %d retries without failures, want 40 and none", fresh, retries)
+	}
+	// The lane lets a retry through at once, then one every 500ms; how
+	// many of those make the window depends on how soon the main queue
+	// hands them out, but never more.
+	fresh, retries := run(true)
+	if retries > 3 || fresh+retries != 40 {
+		t.Errorf("%d fresh requests and %d retries with failures, want 40 in all, at most 3 retries", fresh, retries)
+	}
+}
+
+// TestRetryLaneTokens steps the retry lane's clock a period at a time:
+// the lane passes one retry on at once, then exactly one per period.
+func TestRetryLaneTokens(t *testing.T) {
+	clock := newFakeClock()
+	cfg := testConfig(DoerFunc(func(*http.Request) (*http.Response, error) {
+		return nil, errors.New("unused")
+	}))
+	cfg.Clock = clock
+	s := testScraper(t, cfg)
+	s.queue = newIntervalQueue(FIFO, cfg.PricePrecision, nil)
+	s.retryLane = newIntervalQueue(FIFO, cfg.PricePrecision, nil)
+	for i := range 10 {
+		s.retryLane.push(IntervalInfo{interval: Interval{float32(i), float32(i + 1)}, nRetry: 1})
+	}
+	done := make(chan struct{})
+	lane := newTokenBucket(1, 500*time.Millisecond, clock)
+	go lane.refill(done)
+	waitForWaiters(t, clock, 1)
+	pumped := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		s.pumpRetries(ctx, lane)
+		close(pumped)
+	}()
+
+	passed := func(want int) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); s.queue.depth() < want; time.Sleep(time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("%d retries passed on, want %d", s.queue.depth(), want)
+			}
+		}
+		// Give a lane that lets too many through the time to show it.
+		time.Sleep(10 * time.Millisecond)
+		if got := s.queue.depth(); got != want {
+			t.Fatalf("%d retries passed on, want exactly %d", got, want)
+		}
+	}
+	passed(1)
+	for k := range 4 {
+		clock.Advance(500 * time.Millisecond)
+		passed(k + 2)
+	}
+
+	// Once the run is over, the rest drain at once.
+	cancel()
+	s.retryLane.close()
+	close(done)
+	<-pumped
+	if got := s.queue.depth(); got != 10 {
+		t.Errorf("%d retries passed on after the run, want all 10", got)
+	}
+}
+
+// slowLimiter lets requests through after a fixed wait.
+type slowLimiter time.Duration
+
+func (l slowLimiter) Wait(ctx context.Context) error {
+	select {
+	case <-time.After(time.Duration(l)):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// TestTimeoutIncludesWait scrapes a fast API through a limiter slower than
+// the request timeout: only counting the wait in it times requests out.
+func TestTimeoutIncludesWait(t *testing.T) {
+	catalog := testCatalog(500)
+	for _, includes := range []bool{false, true} {
+		cfg := testConfig(catalog)
+		cfg.RateLimiter = slowLimiter(100 * time.Millisecond)
+		cfg.RequestTimeout = 50 * time.Millisecond
+		cfg.TimeoutIncludesWait = includes
+		cfg.Backoff = BackoffFunc(func(int) time.Duration { return time.Millisecond })
+		cfg.Intervals = []Interval{{0, maxPrice}}
+		res, err := testScraper(t, cfg).Run(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !includes {
+			if !res.Complete || len(res.Products) != catalog.Valid() {
+				t.Errorf("wait not counted: complete %v with %d products, want %d", res.Complete, len(res.Products), catalog.Valid())
+			}
+			continue
+		}
+		if res.Complete || len(res.Failed) != 1 || !errors.Is(res.Failed[0].Err, context.DeadlineExceeded) {
+			t.Errorf("wait counted: complete %v, failed %v, want the interval timed out", res.Complete, res.Failed)
+		}
+	}
+}
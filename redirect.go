@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"slices"
+)
+
+// defaultMaxRedirects is how many redirects a request follows by default:
+// the API has no reason to redirect much, if at all.
+const defaultMaxRedirects = 3
+
+var errRedirect = errors.New("redirect refused")
+
+// RedirectError fails an interval whose request was redirected to an HTML
+// page, most likely a login page, instead of the API.
+type RedirectError struct {
+	Location string
+	Chain    []string // the URLs redirected through, the first requested
+}
+
+func (e *RedirectError) Error() string {
+	return fmt.Sprintf("redirected to an HTML page at %s", e.Location)
+}
+
+func (cfg *Config) maxRedirects() int {
+	switch {
+	case cfg.MaxRedirects < 0:
+		return 0
+	case cfg.MaxRedirects == 0:
+		return defaultMaxRedirects
+	}
+	return cfg.MaxRedirects
+}
+
+// checkRedirect is the CheckRedirect of the clients the scraper makes,
+// enforcing Config.MaxRedirects and Config.AllowCrossHostRedirects.
+func (cfg *Config) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) > cfg.maxRedirects() {
+		return fmt.Errorf("%w: more than %d redirects", errRedirect, cfg.maxRedirects())
+	}
+	if from := via[0].URL; req.URL.Host != from.Host && !cfg.AllowCrossHostRedirects {
+		return fmt.Errorf("%w: from %s to another host, %s", errRedirect, from.Host, req.URL.Redacted())
+	}
+	return nil
+}
+
+// withRedirectPolicy gives c the scraper's redirect policy, unless it has
+// its own; c isn't modified.
+func (cfg *Config) withRedirectPolicy(c Doer) Doer {
+	hc, ok := c.(*http.Client)
+	if !ok || hc.CheckRedirect != nil {
+		return c
+	}
+	withPolicy := *hc
+	withPolicy.CheckRedirect = cfg.checkRedirect
+	return &withPolicy
+}
+
+// redirectChain lists the URLs a response was redirected through, ending
+// with its own, or nil if it wasn't.
+func redirectChain(resp *http.Response) []string {
+	var chain []string
+	for r := resp.Request; r != nil; {
+		chain = append(chain, r.URL.Redacted())
+		if r.Response == nil {
+			break
+		}
+		r = r.Response.Request
+	}
+	if len(chain) < 2 {
+		return nil
+	}
+	slices.Reverse(chain)
+	return chain
+}
+
+// checkRedirected fails a response the API redirected to an HTML page,
+// which no retry is likely to get past.
+func checkRedirected(resp *http.Response) error {
+	chain := redirectChain(resp)
+	if chain == nil {
+		return nil
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return nil
+	}
+	return &RedirectError{Location: chain[len(chain)-1], Chain: chain}
+}
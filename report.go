@@ -0,0 +1,209 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// reportVersion is bumped on incompatible changes to runReport.
+const reportVersion int = 1
+
+// runReport is the machine-readable summary of a run written by -report.
+type runReport struct {
+	Version  int          `json:"version"`
+	Started  time.Time    `json:"started"`
+	Finished time.Time    `json:"finished"`
+	Error    string       `json:"error,omitempty"`
+	Config   reportConfig `json:"config"`
+
+	// Unset when the run failed before scraping.
+	Stats        *Stats     `json:"stats,omitempty"`
+	Complete     bool       `json:"complete"`
+	Truncated    bool       `json:"truncated"`
+	StopReason   string     `json:"stop_reason,omitempty"`
+	Products     int        `json:"products"`
+	InitialTotal int        `json:"initial_total"`
+	FinalTotal   int        `json:"final_total"`
+	Shortfall    int        `json:"shortfall"`
+	Failed       []Interval `json:"failed"`
+	Uncovered    []Interval `json:"uncovered"`
+	Partial      []Interval `json:"partial,omitempty"`
+	Warnings     []string   `json:"warnings,omitempty"`
+	// SpotChecks are those of -spot-checks.
+	SpotChecks []SpotCheck `json:"spot_checks,omitempty"`
+	SpotMisses int         `json:"spot_misses,omitempty"`
+	// Watermark is the -updated-since of the next incremental run; Deleted
+	// lists the IDs -detect-deletions dropped from the snapshot.
+	Watermark *time.Time  `json:"watermark,omitempty"`
+	Deleted   []ProductID `json:"deleted,omitempty"`
+	// MaxID is the -since-id of the next run.
+	MaxID int `json:"max_id,omitempty"`
+
+	Outputs []reportOutput `json:"outputs"`
+}
+
+type reportConfig struct {
+	URL            string              `json:"url"`
+	ExtraParams    map[string][]string `json:"extra_params,omitempty"`
+	PriceRange     Interval            `json:"price_range"`
+	Intervals      int                 `json:"intervals,omitempty"`
+	MinWorkers     int                 `json:"min_workers"`
+	MaxWorkers     int                 `json:"max_workers"`
+	RPS            float64             `json:"rps"`
+	RetryRPS       float64             `json:"retry_rps,omitempty"`
+	MaxRequests    int64               `json:"max_requests,omitempty"`
+	MaxProducts    int                 `json:"max_products,omitempty"`
+	UpdatedSince   *time.Time          `json:"updated_since,omitempty"`
+	Sample         float64             `json:"sample,omitempty"`
+	Seed           int64               `json:"seed"`
+	SplitRatio     float32             `json:"split_ratio"`
+	RetryableCodes []int               `json:"retryable_status"`
+}
+
+type reportOutput struct {
+	Path   string `json:"path"`
+	Bytes  int64  `json:"bytes"`
+	SHA256 string `json:"sha256"`
+}
+
+// secretParam tells query params whose values must not be reported.
+func secretParam(name string) bool {
+	name = strings.ToLower(name)
+	for _, s := range []string{"key", "token", "secret", "password", "auth", "sig"} {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func newReportConfig(cfg *Config) reportConfig {
+	rc := reportConfig{
+		PriceRange:     cfg.priceRange(),
+		Intervals:      len(cfg.Intervals),
+		MinWorkers:     cfg.MinWorkers,
+		MaxWorkers:     cfg.MaxWorkers,
+		RPS:            cfg.RPS,
+		RetryRPS:       cfg.RetryRPS,
+		MaxRequests:    cfg.MaxRequests,
+		MaxProducts:    cfg.MaxProducts,
+		Sample:         cfg.Sample,
+		Seed:           cfg.Seed,
+		SplitRatio:     cfg.SplitRatio,
+		RetryableCodes: cfg.RetryableStatus,
+	}
+	if !cfg.UpdatedSince.IsZero() {
+		rc.UpdatedSince = &cfg.UpdatedSince
+	}
+	if cfg.BaseURL != nil {
+		u := *cfg.BaseURL
+		q := u.Query()
+		for k := range q {
+			if secretParam(k) {
+				q.Set(k, "REDACTED")
+			}
+		}
+		u.RawQuery = q.Encode()
+		rc.URL = u.Redacted()
+	}
+	for k, v := range cfg.ExtraParams {
+		if rc.ExtraParams == nil {
+			rc.ExtraParams = map[string][]string{}
+		}
+		if secretParam(k) {
+			v = []string{"REDACTED"}
+		}
+		rc.ExtraParams[k] = v
+	}
+	return rc
+}
+
+// newReport describes a run from what it got to, finished now by
+// cfg.Clock; res is nil if it failed before scraping.
+func newReport(cfg *Config, started time.Time, res *Result, err error, outputs []string) *runReport {
+	r := &runReport{
+		Version:  reportVersion,
+		Started:  started,
+		Finished: cfg.Clock.Now(),
+		Config:   newReportConfig(cfg),
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	if res != nil {
+		r.Stats = &res.Stats
+		r.Complete = res.Complete
+		r.Truncated = res.Truncated
+		if res.Truncated {
+			r.StopReason = res.Stats.StopReason
+		}
+		r.Products = res.Collected
+		r.InitialTotal = res.InitialTotal
+		r.FinalTotal = res.FinalTotal
+		r.Shortfall = res.Shortfall
+		r.Failed = res.FailedIntervals()
+		r.Uncovered = res.Uncovered
+		r.Partial = res.Partial
+		r.Warnings = res.Warnings
+		r.SpotChecks = res.SpotChecks
+		r.SpotMisses = res.SpotMisses
+		r.Watermark = &res.Watermark
+	}
+	for _, o := range outputs {
+		path, ok := localOutput(o)
+		if !ok {
+			continue
+		}
+		out, err := checksum(path)
+		if err != nil {
+			continue
+		}
+		r.Outputs = append(r.Outputs, out)
+	}
+	return r
+}
+
+func checksum(path string) (reportOutput, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return reportOutput{}, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return reportOutput{}, err
+	}
+	return reportOutput{Path: path, Bytes: n, SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+// readWatermark returns the watermark of the report at path.
+func readWatermark(path string) (time.Time, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var r runReport
+	if err := json.Unmarshal(data, &r); err != nil {
+		return time.Time{}, fmt.Errorf("%s: %w", path, err)
+	}
+	if r.Watermark == nil {
+		return time.Time{}, fmt.Errorf("%s: no watermark, the run failed before scraping", path)
+	}
+	return *r.Watermark, nil
+}
+
+func writeReport(path string, r *runReport) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
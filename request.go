@@ -0,0 +1,538 @@
+package main
+
+import (
+	"context"
+	crand "crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// pageRef is where a page of an interval starts: at an offset, or at a
+// cursor returned with the previous page. The zero pageRef is the first.
+type pageRef struct {
+	offset int
+	cursor string
+}
+
+// buildURL adds the interval, the page, ID range and update time if any
+// and the extra params to the base URL's own query, if it has one.
+func buildURL(cfg *Config, interval Interval, at pageRef, ids *[2]int) string {
+	if cfg.MaxPriceExclusive && sameBound(interval[1], cfg.priceRange()[1], cfg.PricePrecision) {
+		interval[1] += float32(math.Pow10(-cfg.PricePrecision))
+	}
+	u := *cfg.BaseURL
+	params := u.Query()
+	for k, v := range cfg.ExtraParams {
+		params[k] = append([]string(nil), v...)
+	}
+	params.Set(cfg.MinPriceParam, formatPrice(cfg, interval[0], false))
+	params.Set(cfg.MaxPriceParam, formatPrice(cfg, interval[1], true))
+	if at.offset > 0 {
+		params.Set(cfg.OffsetParam, strconv.Itoa(at.offset))
+	}
+	if at.cursor != "" {
+		params.Set(cfg.CursorParam, at.cursor)
+	}
+	if ids == nil && cfg.SinceID > 0 {
+		r := cfg.idRange()
+		ids = &r
+	}
+	if ids != nil {
+		params.Set(cfg.MinIDParam, strconv.Itoa(ids[0]))
+		params.Set(cfg.MaxIDParam, strconv.Itoa(ids[1]))
+	}
+	if !cfg.UpdatedSince.IsZero() {
+		params.Set(cfg.UpdatedSinceParam, cfg.UpdatedSince.UTC().Format(time.RFC3339))
+	}
+	u.RawQuery = params.Encode()
+
+	return u.String()
+}
+
+// PriceFormat is how interval bounds are written in the query.
+type PriceFormat int
+
+const (
+	// PriceFloat writes the shortest decimal that reads back as the bound.
+	PriceFloat PriceFormat = iota
+	// PriceDecimals writes Config.PricePrecision decimals.
+	PriceDecimals
+	// PriceCents writes a whole number of hundredths, PriceUnits of units.
+	PriceCents
+	PriceUnits
+)
+
+// formatPrice writes bound b of an interval in cfg.PriceFormat. Rounding
+// widens the interval, down for a lower bound and up for an upper one, so
+// that no product is left out and the bounds never cross; the overlap of
+// neighbouring intervals is deduped.
+func formatPrice(cfg *Config, b float32, upper bool) string {
+	switch cfg.PriceFormat {
+	case PriceDecimals:
+		scale := math.Pow10(cfg.PricePrecision)
+		return strconv.FormatFloat(roundBound(float64(b)*scale, upper)/scale, 'f', cfg.PricePrecision, 64)
+	case PriceCents:
+		return strconv.FormatFloat(roundBound(float64(b)*100, upper), 'f', 0, 64)
+	case PriceUnits:
+		return strconv.FormatFloat(roundBound(float64(b), upper), 'f', 0, 64)
+	}
+	return strconv.FormatFloat(float64(b), 'f', -1, 32)
+}
+
+// roundBound rounds x up or down to a whole number, except when it is
+// that number give or take float32 noise (12.34 is 1234.0001 cents).
+func roundBound(x float64, up bool) float64 {
+	if r := math.Round(x); math.Abs(x-r) < 1e-3 {
+		return r
+	}
+	if up {
+		return math.Ceil(x)
+	}
+	return math.Floor(x)
+}
+
+// newRequestID returns a random (version 4) UUID.
+func newRequestID() string {
+	var b [16]byte
+	if _, err := crand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// request requests a page of interval, or of its ids if not nil. Its
+// products are only handed over by the caller, once it accepted them: a
+// response may still fail, be retried or its interval cancelled.
+func (s *Scraper) request(ctx context.Context, interval Interval, at pageRef, ids *[2]int) (_ *Response, err error) {
+	defer func() {
+		if err != nil && ctx.Err() == nil {
+			s.countError(err)
+		}
+	}()
+
+	cfg := s.cfg
+	// The deadline runs from here, the wait for a token included, unless
+	// the rate limiter sets it once the token is granted.
+	reqCtx := ctx
+	if cfg.RequestTimeout > 0 && cfg.TimeoutIncludesWait {
+		var cancel context.CancelFunc
+		reqCtx, cancel = context.WithTimeout(ctx, cfg.RequestTimeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodGet, buildURL(cfg, interval, at, ids), nil)
+	if err != nil {
+		return nil, err
+	}
+	// Correlates our logs with the API's.
+	requestID := newRequestID()
+	req.Header.Set(requestIDHeader, requestID)
+	if accept := cfg.accept(); accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	resp, key, err := s.sendAuthorized(ctx, s.doer, req, true)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	// Each key of a pool has its own quota: running out of one doesn't
+	// hold the others back.
+	if key < 0 {
+		s.observeRateLimit(resp.Header)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		io.Copy(io.Discard, resp.Body) // lets the connection be reused
+		return nil, &StatusError{StatusCode: resp.StatusCode}
+	}
+	if err := checkRedirected(resp); err != nil {
+		io.Copy(io.Discard, resp.Body)
+		cfg.Logger.Warn("redirected away from the API", "request_id", requestID, "interval", interval, "redirects", err.(*RedirectError).Chain)
+		return nil, err
+	}
+	// Another version's schema would decode to garbage, if at all.
+	if err := cfg.checkContentType(resp.Header); err != nil {
+		io.Copy(io.Discard, resp.Body)
+		cfg.Logger.Warn("unexpected response", "request_id", requestID, "interval", interval, "err", err)
+		return nil, err
+	}
+
+	// Keep what was read for the logs, all of it if it may be dead-lettered.
+	captured := &capWriter{max: bodySnippetSize}
+	if cfg.DeadLetterDir != "" {
+		captured.max = -1
+	}
+	response, err := decodeResponse(io.TeeReader(resp.Body, captured), s.schema, cfg.StrictSchema)
+	if err != nil {
+		io.Copy(captured, resp.Body)
+		body := captured.buf
+		// HTML error pages and truncated payloads are easier to tell
+		// apart by looking at them.
+		cfg.Logger.Warn("error decoding JSON", "request_id", requestID, "interval", interval,
+			"status", resp.StatusCode, "body", string(body[:min(len(body), bodySnippetSize)]), "err", err)
+		return nil, &DecodeError{StatusCode: resp.StatusCode, Body: body, Err: err}
+	}
+	io.Copy(io.Discard, resp.Body)
+	if err := s.validate(interval, response); err != nil {
+		cfg.Logger.Warn("invalid response", "request_id", requestID, "interval", interval, "err", err)
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// decodeResponse reads a Response with the fields of schema off r one
+// product at a time, so large bodies are never buffered whole. A strict
+// decode fails on fields it doesn't know and products missing some, see
+// Config.StrictSchema.
+func decodeResponse(r io.Reader, schema ResponseSchema, strict bool) (*Response, error) {
+	dec := json.NewDecoder(r)
+	if err := expectDelim(dec, '{'); err != nil {
+		return nil, err
+	}
+
+	var res Response
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t {
+		case schema.Total:
+			err = dec.Decode(&res.Total)
+		case schema.Count:
+			err = dec.Decode(&res.Count)
+		case schema.Products:
+			err = decodeProducts(dec, func(p Product) { res.Products = append(res.Products, p) }, strict)
+		case schema.NextCursor:
+			err = dec.Decode(&res.NextCursor)
+		default:
+			if strict {
+				return nil, &SchemaError{Field: fmt.Sprint(t), In: "response"}
+			}
+			var skip json.RawMessage
+			err = dec.Decode(&skip)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := expectDelim(dec, '}'); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+func decodeProducts(dec *json.Decoder, add func(Product), strict bool) error {
+	t, err := dec.Token()
+	if err != nil || t == nil {
+		return err
+	}
+	if t != json.Delim('[') {
+		return fmt.Errorf("products: unexpected %v", t)
+	}
+	for dec.More() {
+		var p Product
+		if strict {
+			var raw json.RawMessage
+			if err := dec.Decode(&raw); err != nil {
+				return err
+			}
+			if err := decodeStrict(raw, &p); err != nil {
+				return err
+			}
+		} else if err := dec.Decode(&p); err != nil {
+			return err
+		}
+		add(p)
+	}
+	return expectDelim(dec, ']')
+}
+
+func expectDelim(dec *json.Decoder, d json.Delim) error {
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if t != d {
+		return fmt.Errorf("expected %v, got %v", d, t)
+	}
+	return nil
+}
+
+// capWriter keeps the first max bytes written to it, all of them if max is
+// negative, and discards the rest.
+type capWriter struct {
+	buf []byte
+	max int
+}
+
+func (w *capWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	if w.max >= 0 {
+		n = min(n, w.max-len(w.buf))
+	}
+	w.buf = append(w.buf, p[:n]...)
+	return len(p), nil
+}
+
+// Ping makes a single, minimal request over the whole price range to check
+// the endpoint is reachable, accepts our credentials and answers JSON. It
+// bypasses the rate limiter and the request budget.
+func (s *Scraper) Ping(ctx context.Context) error {
+	if _, _, err := s.probe(ctx, s.cfg.priceRange(), 1, nil); err != nil {
+		return fmt.Errorf("ping %s: %w", s.cfg.BaseURL.Redacted(), err)
+	}
+	return nil
+}
+
+// probe requests interval, asking for at most limit products, outside of
+// the run's rate limiter and budget.
+func (s *Scraper) probe(ctx context.Context, interval Interval, limit int, ids *[2]int) (*Response, http.Header, error) {
+	cfg := s.cfg
+	u, err := url.Parse(buildURL(cfg, interval, pageRef{}, ids))
+	if err != nil {
+		return nil, nil, err
+	}
+	q := u.Query()
+	q.Set("limit", strconv.Itoa(limit))
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set(requestIDHeader, newRequestID())
+	if accept := s.cfg.accept(); accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	resp, _, err := s.sendAuthorized(ctx, Chain(s.client, s.middleware...), req, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return nil, resp.Header, fmt.Errorf("credentials rejected: %w", &StatusError{StatusCode: resp.StatusCode})
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return nil, resp.Header, &StatusError{StatusCode: resp.StatusCode}
+	}
+	if err := checkRedirected(resp); err != nil {
+		return nil, resp.Header, err
+	}
+	if err := s.cfg.checkContentType(resp.Header); err != nil {
+		return nil, resp.Header, err
+	}
+	res, err := decodeResponse(resp.Body, s.schema, s.cfg.StrictSchema)
+	if err != nil {
+		return nil, resp.Header, fmt.Errorf("response isn't the expected JSON: %w", err)
+	}
+	return res, resp.Header, nil
+}
+
+// sendAuthorized sends req through doer with the credentials of
+// authorize. A key of Config.BearerTokens the API rejects is burned and
+// req sent again at once with the next one, until none is left: rotating
+// isn't another attempt at the interval, only another request of the
+// budget when budget is set. It returns the index of the key the response
+// was sent with, or -1.
+func (s *Scraper) sendAuthorized(ctx context.Context, doer Doer, req *http.Request, budget bool) (*http.Response, int, error) {
+	for {
+		key, err := s.authorize(req)
+		if err != nil {
+			return nil, key, err
+		}
+		resp, err := doer.Do(req)
+		if err != nil {
+			return nil, key, err
+		}
+		err = s.rotateKey(key, resp)
+		if err == nil {
+			return resp, key, nil
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if !errors.Is(err, errKeyRotated) {
+			return nil, key, err
+		}
+		if budget && !s.acquire(ctx) {
+			return nil, key, errBudgetExhausted
+		}
+		req = req.Clone(req.Context())
+	}
+}
+
+// authorize sets the credentials of Config.BearerToken, Config.BasicAuth
+// or Config.BearerTokens on req, returning the index of the key of the
+// latter or -1.
+func (s *Scraper) authorize(req *http.Request) (int, error) {
+	switch {
+	case s.cfg.BearerToken != "":
+		req.Header.Set("Authorization", "Bearer "+s.cfg.BearerToken)
+	case s.cfg.BasicAuth != nil:
+		req.SetBasicAuth(s.cfg.BasicAuth.User, s.cfg.BasicAuth.Password)
+	case s.keys != nil:
+		key, token, err := s.keys.next()
+		if err != nil {
+			return -1, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		return key, nil
+	}
+	return -1, nil
+}
+
+// StatusError is returned for responses with a non-2xx status.
+type StatusError struct {
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// DecodeError is returned when a 2xx response body isn't valid JSON. Body
+// is the raw payload, for the dead letters.
+type DecodeError struct {
+	StatusCode int
+	Body       []byte
+	Err        error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("decoding %d response: %v", e.StatusCode, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error { return e.Err }
+
+// isTransportError reports network failures and bodies cut short, as
+// opposed to the server answering with an error. TLS and certificate
+// errors aren't: no retry gets past them. Nor is every net.Error, which
+// *url.Error wrapping anything implements.
+func isTransportError(err error) bool {
+	if isTLSError(err) {
+		return false
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	// net/http doesn't export its HTTP/2 stream error type.
+	return strings.Contains(err.Error(), "stream error")
+}
+
+// isTLSError tells failed handshakes and certificates.
+func isTLSError(err error) bool {
+	var (
+		verifyErr  *tls.CertificateVerificationError
+		recordErr  tls.RecordHeaderError
+		alertErr   tls.AlertError
+		authority  x509.UnknownAuthorityError
+		hostname   x509.HostnameError
+		invalid    x509.CertificateInvalidError
+		systemRoot x509.SystemRootsError
+	)
+	return errors.As(err, &verifyErr) || errors.As(err, &recordErr) || errors.As(err, &alertErr) ||
+		errors.As(err, &authority) || errors.As(err, &hostname) || errors.As(err, &invalid) || errors.As(err, &systemRoot)
+}
+
+// retryable reports whether a request that failed with err is worth
+// retrying. Transport errors are; of the error statuses, only the
+// Config.RetryableStatus ones are.
+func (s *Scraper) retryable(err error) bool {
+	var re *RedirectError
+	var sch *SchemaError
+	if errors.Is(err, errRedirect) || errors.As(err, &re) || errors.As(err, &sch) {
+		return false
+	}
+	if isTransportError(err) {
+		return true
+	}
+	if errors.Is(err, errUnsplittable) || errors.Is(err, ErrCircuitOpen) || errors.Is(err, errContentType) {
+		return false
+	}
+	if errors.Is(err, errInvalidResponse) {
+		return s.cfg.RetryInvalidIntervals
+	}
+	var se *StatusError
+	if errors.As(err, &se) {
+		return slices.Contains(s.cfg.RetryableStatus, se.StatusCode)
+	}
+	return true
+}
+
+// revokedCredentials tells responses that no request will get past, like
+// credentials revoked mid-run.
+func revokedCredentials(err error) bool {
+	if errors.Is(err, errKeysBurned) {
+		return true
+	}
+	var se *StatusError
+	return errors.As(err, &se) && (se.StatusCode == http.StatusUnauthorized || se.StatusCode == http.StatusForbidden)
+}
+
+func (s *Scraper) countError(err error) {
+	var se *StatusError
+	switch {
+	case errors.Is(err, ErrCircuitOpen):
+		s.circuitOpen.Add(1)
+	case isDNSError(err):
+		s.dnsErrors.Add(1)
+	case errors.Is(err, errRedirect):
+		s.otherErrors.Add(1)
+	case isTransportError(err):
+		s.networkErrors.Add(1)
+	case errors.As(err, &se):
+		s.statusErrors.Add(1)
+	default:
+		s.otherErrors.Add(1)
+	}
+}
+
+// observeRateLimit passes the API's remaining budget to the limiter, if it
+// can use it.
+func (s *Scraper) observeRateLimit(h http.Header) {
+	o, ok := s.limiter.(RateLimitObserver)
+	if !ok {
+		return
+	}
+	remaining, err := strconv.Atoi(h.Get(s.cfg.RateRemainingHeader))
+	if err != nil {
+		return
+	}
+	reset, err := strconv.ParseInt(h.Get(s.cfg.RateResetHeader), 10, 64)
+	if err != nil {
+		return
+	}
+
+	// APIs send either a Unix timestamp or a number of seconds from now.
+	var resetAt time.Time
+	if reset > 1e9 {
+		resetAt = time.Unix(reset, 0)
+	} else {
+		resetAt = s.cfg.Clock.Now().Add(time.Duration(reset) * time.Second)
+	}
+	o.Observe(remaining, resetAt)
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestIsTransportError(t *testing.T) {
+	urlErr := func(err error) error { return &url.Error{Op: "Get", URL: "https://shop.test/products", Err: err} }
+	for _, tc := range []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"body cut short", fmt.Errorf("decoding: %w", io.ErrUnexpectedEOF), true},
+		{"connection reset", urlErr(&net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}), true},
+		{"connection refused", urlErr(&net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}), true},
+		{"broken pipe", fmt.Errorf("writing: %w", syscall.EPIPE), true},
+		{"timeout", urlErr(context.DeadlineExceeded), true},
+		{"http2 stream error", errors.New("http2: stream error: stream ID 3; INTERNAL_ERROR"), true},
+		{"url error around anything", urlErr(errors.New("unsupported protocol scheme")), false},
+		{"unknown authority", urlErr(x509.UnknownAuthorityError{}), false},
+		{"wrong host", urlErr(x509.HostnameError{Host: "shop.test"}), false},
+		{"expired", urlErr(x509.CertificateInvalidError{Reason: x509.Expired}), false},
+		{"verification", urlErr(&tls.CertificateVerificationError{Err: x509.UnknownAuthorityError{}}), false},
+		{"not tls", urlErr(tls.RecordHeaderError{Msg: "first record does not look like a TLS handshake"}), false},
+		{"alert", urlErr(&net.OpError{Op: "remote error", Err: tls.AlertError(40)}), false},
+		{"status", &StatusError{StatusCode: http.StatusInternalServerError}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := isTransportError(tc.err); got != tc.want {
+				t.Errorf("isTransportError(%v) = %v, want %v", tc.err, got, tc.want)
+			}
+		})
+	}
+}
+
+// TestRetryCutConnections serves a catalog whose first responses to
+// intervals are cut short by closing the connection mid-body.
+func TestRetryCutConnections(t *testing.T) {
+	catalog := testCatalog(5000)
+	var served atomic.Int64
+	var cut sync.Map // URLs already cut once
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp, err := catalog.Do(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		w.Header().Set("Content-Type", "application/json")
+		// Past the initial request, the first response to each URL is cut.
+		if _, seen := cut.LoadOrStore(r.URL.String(), true); served.Add(1) == 1 || seen {
+			w.Write(body)
+			return
+		}
+		w.Header().Set("Content-Length", fmt.Sprint(len(body)))
+		w.WriteHeader(http.StatusOK)
+		w.Write(body[:len(body)/2])
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	}))
+	defer srv.Close()
+
+	cfg := testConfig(nil)
+	s, err := NewScraper(cfg, WithBaseURL(srv.URL+"/products"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := s.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Complete || res.Collected != catalog.Valid() {
+		t.Errorf("complete %v with %d products, want %d", res.Complete, res.Collected, catalog.Valid())
+	}
+	if res.Stats.NetworkErrors == 0 {
+		t.Error("no network error counted")
+	}
+	// Nothing of the cut responses was handed over.
+	if res.Stats.Products != int64(catalog.Len()) {
+		t.Errorf("%d products counted, want %d", res.Stats.Products, catalog.Len())
+	}
+}
+
+// TestDecodeProductsBeforeBodyIsRead decodes products off a body whose end
+// is only written once the first product was decoded.
+func TestDecodeProductsBeforeBodyIsRead(t *testing.T) {
+	const n = 900
+	products := func(from, to int) string {
+		var b bytes.Buffer
+		for i := from; i < to; i++ {
+			if i > from {
+				b.WriteByte(',')
+			}
+			fmt.Fprintf(&b, `{"id": %d, "name": "p", "price": %d}`, i+1, i+1)
+		}
+		return b.String()
+	}
+	first := make(chan struct{})
+	flowed := make(chan bool, 1)
+	r, w := io.Pipe()
+	go func() {
+		io.WriteString(w, "["+products(0, 200)+",")
+		select {
+		case <-first:
+			flowed <- true
+		case <-time.After(5 * time.Second):
+			flowed <- false
+		}
+		io.WriteString(w, products(200, n)+"]")
+		w.Close()
+	}()
+
+	var decoded []Product
+	err := decodeProducts(json.NewDecoder(r), func(p Product) {
+		if len(decoded) == 0 {
+			close(first)
+		}
+		decoded = append(decoded, p)
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !<-flowed {
+		t.Error("no product decoded before the body was read whole")
+	}
+	if len(decoded) != n {
+		t.Errorf("%d products decoded, want %d", len(decoded), n)
+	}
+}
+
+func TestBuildURLExtraParams(t *testing.T) {
+	cfg := testConfig(nil)
+	cfg.ExtraParams = url.Values{"category": {"shoes"}, "tag": {"new", "sale"}}
+	s := testScraper(t, cfg)
+	u, err := url.Parse(buildURL(s.cfg, Interval{10, 20.5}, pageRef{}, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	for k, want := range map[string][]string{
+		minPriceParam: {"10"},
+		maxPriceParam: {"20.5"},
+		"category":    {"shoes"},
+		"tag":         {"new", "sale"},
+	} {
+		if got := q[k]; !slices.Equal(got, want) {
+			t.Errorf("%s = %q, want %q in %s", k, got, want, u)
+		}
+	}
+
+	// The scraper's own params can't be overridden.
+	cfg = testConfig(nil)
+	cfg.ExtraParams = url.Values{maxPriceParam: {"1"}}
+	if _, err := NewScraper(cfg, WithBaseURL("http://shop.test/products")); err == nil {
+		t.Errorf("extra param %s accepted", maxPriceParam)
+	}
+}
+
+func TestBuildURLPrices(t *testing.T) {
+	for _, tc := range []struct {
+		format   PriceFormat
+		min, max string // param names, the defaults if empty
+		interval Interval
+		want     string
+	}{
+		{PriceFloat, "", "", Interval{10, 20.5}, "maxPrice=20.5&minPrice=10"},
+		{PriceFloat, "", "", Interval{0.1, 12.34}, "maxPrice=12.34&minPrice=0.1"},
+		{PriceDecimals, "", "", Interval{10, 20.5}, "maxPrice=20.50&minPrice=10.00"},
+		{PriceDecimals, "", "", Interval{12.345, 12.346}, "maxPrice=12.35&minPrice=12.34"},
+		{PriceCents, "price_min", "price_max", Interval{12.34, 56.78}, "price_max=5678&price_min=1234"},
+		{PriceCents, "price_min", "price_max", Interval{12.345, 12.346}, "price_max=1235&price_min=1234"},
+		{PriceCents, "price_min", "price_max", Interval{0, 0.01}, "price_max=1&price_min=0"},
+		{PriceUnits, "", "", Interval{9.99, 10.01}, "maxPrice=11&minPrice=9"},
+		{PriceUnits, "", "", Interval{10.2, 10.4}, "maxPrice=11&minPrice=10"},
+		{PriceUnits, "", "", Interval{12, 13}, "maxPrice=13&minPrice=12"},
+	} {
+		cfg := testConfig(nil)
+		cfg.PriceFormat = tc.format
+		cfg.MinPriceParam = tc.min
+		cfg.MaxPriceParam = tc.max
+		s := testScraper(t, cfg)
+		u, err := url.Parse(buildURL(s.cfg, tc.interval, pageRef{}, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u.RawQuery != tc.want {
+			t.Errorf("format %d, %v: query %q, want %q", tc.format, tc.interval, u.RawQuery, tc.want)
+		}
+	}
+}
+
+// BenchmarkBuildURL builds the URL of a first page, and of a later one with
+// extra params.
+func BenchmarkBuildURL(b *testing.B) {
+	for _, c := range []struct {
+		name  string
+		extra url.Values
+		at    pageRef
+	}{
+		{"first", nil, pageRef{}},
+		{"paged", url.Values{"category": {"shoes"}, "tag": {"new", "sale"}}, pageRef{offset: 3000}},
+	} {
+		b.Run(c.name, func(b *testing.B) {
+			cfg := testConfig(nil)
+			cfg.ExtraParams = c.extra
+			s := testScraper(b, cfg)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := range b.N {
+				buildURL(s.cfg, Interval{float32(i % 1000), 1000.5}, c.at, nil)
+			}
+		})
+	}
+}
+
+func TestRetryableStatus(t *testing.T) {
+	for _, tc := range []struct {
+		status  int
+		retried bool
+	}{
+		{http.StatusNotFound, false},
+		{http.StatusServiceUnavailable, true},
+	} {
+		t.Run(http.StatusText(tc.status), func(t *testing.T) {
+			catalog := testCatalog(3000)
+			var mu sync.Mutex
+			seen := map[string]bool{}
+			// Past the initial probe, every interval fails its first attempt.
+			cfg := testConfig(DoerFunc(func(req *http.Request) (*http.Response, error) {
+				mu.Lock()
+				first := !seen[req.URL.RawQuery]
+				seen[req.URL.RawQuery] = true
+				probe := len(seen) == 1
+				mu.Unlock()
+				if first && !probe {
+					return simulatedResponse(req, tc.status, nil), nil
+				}
+				return catalog.Do(req)
+			}))
+			res, err := testScraper(t, cfg).Run(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.retried {
+				if !res.Complete || len(res.Failed) > 0 || res.Stats.Retries == 0 {
+					t.Errorf("complete %v, %d failed after %d retries, want every interval retried", res.Complete, len(res.Failed), res.Stats.Retries)
+				}
+				return
+			}
+			if len(res.Failed) == 0 || res.Stats.Retries > 0 {
+				t.Errorf("%d failed after %d retries, want the intervals failed at once", len(res.Failed), res.Stats.Retries)
+			}
+			for _, f := range res.Failed {
+				var se *StatusError
+				if f.Attempts != 1 || !errors.As(f.Err, &se) || se.StatusCode != tc.status {
+					t.Errorf("%v failed after %d attempts: %v", f.Interval, f.Attempts, f.Err)
+				}
+			}
+		})
+	}
+}
+
+// FuzzDecodeResponse feeds arbitrary bodies to the decoder, and to a run
+// whose every response has them: neither may panic.
+func FuzzDecodeResponse(f *testing.F) {
+	for _, seed := range []string{
+		`{"total": 2, "count": 2, "products": [{"id": 1, "name": "a", "price": 1.5}, {"id": "SKU-2", "name": "b", "price": 3}]}`,
+		`{"total": 0, "count": 0, "products": []}`,
+		`{"total": 5000, "count": 1000, "products": null, "nextCursor": "abc"}`,
+		`{"total": -1, "count": 1e300, "products": [{}]}`,
+		`{"products": [{"id": 1, "price": "1"}], "total": 1}`,
+		`{"total": 1, "count": 1, "products": [{"id": 1, "price": 1}`,
+		`[]`,
+		`<html>`,
+		``,
+	} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, body []byte) {
+		for _, strict := range []bool{false, true} {
+			decodeResponse(bytes.NewReader(body), responseSchemas[""], strict)
+		}
+
+		cfg := testConfig(DoerFunc(func(req *http.Request) (*http.Response, error) {
+			return simulatedResponse(req, http.StatusOK, body), nil
+		}))
+		cfg.MaxRequests = 20
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		res, _ := testScraper(t, cfg).Run(ctx)
+		if ctx.Err() != nil {
+			t.Fatal("run timed out")
+		}
+		if res != nil && res.Stats.Panics > 0 {
+			t.Errorf("%d workers panicked", res.Stats.Panics)
+		}
+	})
+}
+
+func TestPing(t *testing.T) {
+	catalog := testCatalog(100)
+	cfg := testConfig(DoerFunc(func(req *http.Request) (*http.Response, error) {
+		if req.URL.Query().Get("limit") != "1" {
+			t.Errorf("ping asked for limit %q, want 1", req.URL.Query().Get("limit"))
+		}
+		switch req.Header.Get("Authorization") {
+		case "Bearer secret":
+			return catalog.Do(req)
+		case "Bearer html":
+			resp := simulatedResponse(req, http.StatusOK, []byte("<html>"))
+			resp.Header.Set("Content-Type", "text/html")
+			return resp, nil
+		}
+		return simulatedResponse(req, http.StatusUnauthorized, nil), nil
+	}))
+	for _, tc := range []struct {
+		token  string
+		status int // of the error, 0 if none
+		ok     bool
+	}{
+		{"secret", 0, true},
+		{"revoked", http.StatusUnauthorized, false},
+		{"html", 0, false},
+	} {
+		cfg.BearerToken = tc.token
+		err := testScraper(t, cfg).Ping(context.Background())
+		var se *StatusError
+		if (err == nil) != tc.ok || tc.status != 0 && (!errors.As(err, &se) || se.StatusCode != tc.status) {
+			t.Errorf("token %q: ping: %v", tc.token, err)
+		}
+	}
+}
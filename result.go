@@ -0,0 +1,159 @@
+package main
+
+import (
+	"io"
+	"maps"
+	"slices"
+)
+
+// FailedIntervals lists the intervals of Failed, e.g. to retry them.
+func (r *Result) FailedIntervals() []Interval {
+	intervals := make([]Interval, len(r.Failed))
+	for i, f := range r.Failed {
+		intervals[i] = f.Interval
+	}
+	return intervals
+}
+
+// WriteNDJSON writes Products, spilled ones included, to w, one JSON
+// object per line.
+func (r *Result) WriteNDJSON(w io.Writer) error {
+	sink := NewNDJSONSink(w)
+	if err := r.EachProduct(sink.WriteProduct); err != nil {
+		return err
+	}
+	return sink.Flush()
+}
+
+// Merge adds a later run resuming or retrying part of r's to r, as if both
+// had been a single run. Products are deduped by ID, keeping r's, and
+// Histogram counts each once. The failures of r that other fetched
+// anything within are dropped; other's Uncovered, Skipped and FinalTotal
+// replace r's, being more recent. Only in-memory products are merged,
+// spilled ones stay in their SpillFile.
+func (r *Result) Merge(other *Result) {
+	seen := make(map[ProductID]struct{}, len(r.Products)+len(r.InvalidPrices))
+	for _, p := range slices.Concat(r.Products, r.InvalidPrices) {
+		seen[p.ID] = struct{}{}
+	}
+	if r.ByInterval == nil {
+		r.ByInterval = map[Interval][]Product{}
+	}
+	if r.Histogram == nil {
+		r.Histogram = map[Interval]int{}
+	}
+	added := map[Interval]int{}
+	for i, products := range other.ByInterval {
+		for _, p := range products {
+			if _, ok := seen[p.ID]; !ok {
+				r.ByInterval[i] = append(r.ByInterval[i], p)
+				added[i]++
+			}
+		}
+	}
+	for _, p := range other.Products {
+		if _, ok := seen[p.ID]; !ok {
+			seen[p.ID] = struct{}{}
+			r.Products = append(r.Products, p)
+			r.Collected++
+		}
+	}
+	// Products aren't kept with Config.StatsOnly, only counted.
+	if len(other.Products) == 0 {
+		r.Collected += other.Collected
+	}
+	// Only the products other added count; where they weren't kept to
+	// tell, overlapping runs counted the same ones.
+	for i, n := range other.Histogram {
+		if len(other.ByInterval[i]) == n {
+			r.Histogram[i] += added[i]
+		} else {
+			r.Histogram[i] = max(r.Histogram[i], n)
+		}
+	}
+	for _, p := range other.InvalidPrices {
+		if _, ok := seen[p.ID]; !ok {
+			seen[p.ID] = struct{}{}
+			r.InvalidPrices = append(r.InvalidPrices, p)
+		}
+	}
+
+	failed := slices.DeleteFunc(r.Failed, func(f FailedInterval) bool {
+		for i := range other.Histogram {
+			if f.Interval[0] <= i[0] && i[1] <= f.Interval[1] {
+				return true
+			}
+		}
+		return slices.ContainsFunc(other.Failed, func(o FailedInterval) bool { return o.Interval == f.Interval })
+	})
+	r.Failed = append(failed, other.Failed...)
+
+	if r.InitialTotal == 0 {
+		r.InitialTotal = other.InitialTotal
+	}
+	if other.FinalTotal != 0 {
+		r.FinalTotal = other.FinalTotal
+	}
+	r.Truncated = other.Truncated
+	r.Uncovered = other.Uncovered
+	r.Partial = append(r.Partial, other.Partial...)
+	r.Skipped = other.Skipped
+	r.Complete = !r.Truncated && len(r.Failed) == 0 && len(r.Skipped) == 0
+	r.Shortfall = other.Shortfall
+	r.SplitTree = append(r.SplitTree, other.SplitTree...)
+	r.Warnings = append(r.Warnings, other.Warnings...)
+	r.Stats = r.Stats.merge(other.Stats)
+}
+
+// merge sums the counters of two runs; other's snapshot values (stop
+// reason, workers, rate, coverage, estimates) win.
+func (st Stats) merge(other Stats) Stats {
+	m := other
+	m.Requests += st.Requests
+	m.MaxRequests += st.MaxRequests
+	m.Products += st.Products
+	m.PeakWorkers = max(st.PeakWorkers, other.PeakWorkers)
+	m.ScaleUps += st.ScaleUps
+	m.ScaleDowns += st.ScaleDowns
+	m.PolitenessSleep += st.PolitenessSleep
+	m.TokenWait += st.TokenWait
+	m.NetworkErrors += st.NetworkErrors
+	m.DNSErrors += st.DNSErrors
+	m.StatusErrors += st.StatusErrors
+	m.OtherErrors += st.OtherErrors
+	m.CircuitOpen += st.CircuitOpen
+	m.CountMismatches += st.CountMismatches
+	m.OverLimitCounts += st.OverLimitCounts
+	m.SkippedCovered += st.SkippedCovered
+	m.ExcludedWidth += st.ExcludedWidth
+	m.ExcludedProducts += st.ExcludedProducts
+	m.AboveMaxPrice += st.AboveMaxPrice
+	m.Cancelled += st.Cancelled
+	m.ResponseDuplicates += st.ResponseDuplicates
+	m.FilteredOut += st.FilteredOut
+	m.SinkFailures += st.SinkFailures
+	m.Panics += st.Panics
+	m.Pages += st.Pages
+	m.Retries += st.Retries
+	m.RetryLaneWait += st.RetryLaneWait
+	m.Paused += st.Paused
+	m.SessionWarmUps += st.SessionWarmUps
+	m.DNSLookups += st.DNSLookups
+	m.DNSStaleAnswers += st.DNSStaleAnswers
+	m.Keys = mergeKeyStats(st.Keys, other.Keys)
+	m.LocalAddrRequests = maps.Clone(other.LocalAddrRequests)
+	for a, n := range st.LocalAddrRequests {
+		if m.LocalAddrRequests == nil {
+			m.LocalAddrRequests = map[string]int64{}
+		}
+		m.LocalAddrRequests[a] += n
+	}
+	m.WorkerRequests = maps.Clone(other.WorkerRequests)
+	for w, n := range st.WorkerRequests {
+		if m.WorkerRequests == nil {
+			m.WorkerRequests = map[int]int64{}
+		}
+		m.WorkerRequests[w] += n
+	}
+	return m
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	r := &Result{
+		Products:  []Product{{ID: "1"}, {ID: "2"}, {ID: "3", Name: "first"}},
+		Collected: 3,
+		Failed:    []FailedInterval{{Interval: Interval{10, 20}}, {Interval: Interval{30, 40}}},
+		Stats:     Stats{Requests: 5, Retries: 1, PeakWorkers: 4, StopReason: "max requests"},
+	}
+	other := &Result{
+		Products:  []Product{{ID: "3", Name: "again"}, {ID: "4"}},
+		Collected: 2,
+		Histogram: map[Interval]int{{10, 15}: 2},
+		Failed:    []FailedInterval{{Interval: Interval{50, 60}}},
+		Stats:     Stats{Requests: 7, PeakWorkers: 2, StopReason: "completed"},
+	}
+	r.Merge(other)
+
+	var ids []ProductID
+	for _, p := range r.Products {
+		ids = append(ids, p.ID)
+	}
+	if !slices.Equal(ids, []ProductID{"1", "2", "3", "4"}) || r.Products[2].Name != "first" || r.Collected != 4 {
+		t.Errorf("merged products %v (%d collected), want 1 to 4, keeping the first 3", ids, r.Collected)
+	}
+	// Something was fetched within [10 20] since it failed.
+	if got := r.FailedIntervals(); !slices.Equal(got, []Interval{{30, 40}, {50, 60}}) {
+		t.Errorf("merged failures %v, want [30 40] and [50 60]", got)
+	}
+	if r.Complete {
+		t.Error("complete with failures left")
+	}
+	if n := r.Histogram[Interval{10, 15}]; n != 2 {
+		t.Errorf("merged histogram counts %d in [10 15], want 2", n)
+	}
+	if st := r.Stats; st.Requests != 12 || st.Retries != 1 || st.PeakWorkers != 4 || st.StopReason != "completed" {
+		t.Errorf("merged stats: %d requests, %d retries, %d peak workers, stopped %q", st.Requests, st.Retries, st.PeakWorkers, st.StopReason)
+	}
+
+	// Without the products to dedup, a run over the same interval again
+	// counted the same ones.
+	counts := &Result{Histogram: map[Interval]int{{0, 10}: 3}}
+	counts.Merge(&Result{Histogram: map[Interval]int{{0, 10}: 3, {10, 20}: 1}})
+	if want := map[Interval]int{{0, 10}: 3, {10, 20}: 1}; !maps.Equal(counts.Histogram, want) {
+		t.Errorf("merged histogram %v, want %v", counts.Histogram, want)
+	}
+}
+
+// TestMergeResumed scrapes a catalog in two runs over overlapping halves
+// of the range: merged, they are one complete run.
+func TestMergeResumed(t *testing.T) {
+	catalog := testCatalog(5000)
+	var res *Result
+	for _, half := range []Interval{{0, 6000}, {4000, maxPrice}} {
+		cfg := testConfig(catalog)
+		cfg.Intervals = []Interval{half}
+		part, err := testScraper(t, cfg).Run(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res == nil {
+			res = part
+			continue
+		}
+		res.Merge(part)
+	}
+	if !res.Complete || len(res.Products) != catalog.Valid() || res.Collected != catalog.Valid() {
+		t.Errorf("complete %v with %d products, %d collected, want %d", res.Complete, len(res.Products), res.Collected, catalog.Valid())
+	}
+	// The products of [4000 6000] were fetched twice but count once.
+	var counted int
+	for _, n := range res.Histogram {
+		counted += n
+	}
+	if counted != catalog.Valid() {
+		t.Errorf("histogram counts %d products, want %d", counted, catalog.Valid())
+	}
+}
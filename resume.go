@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+)
+
+// readIntervals loads intervals saved by writeIntervals.
+func readIntervals(path string) ([]Interval, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var intervals []Interval
+	if err := json.Unmarshal(data, &intervals); err != nil {
+		return nil, err
+	}
+	return intervals, nil
+}
+
+// readIntervalPlan loads a hand-made plan: NDJSON lines of
+// {"min":..,"max":..}, in any order, of intervals that neither are
+// inverted nor overlap.
+func readIntervalPlan(path string, precision int) ([]Interval, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var plan []Interval
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for dec.More() {
+		var r struct {
+			Min *float32 `json:"min"`
+			Max *float32 `json:"max"`
+		}
+		if err := dec.Decode(&r); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if r.Min == nil || r.Max == nil {
+			return nil, fmt.Errorf("%s: interval %d needs a min and a max", path, len(plan)+1)
+		}
+		if *r.Max < *r.Min {
+			return nil, fmt.Errorf("%s: interval %d is inverted: [%v, %v]", path, len(plan)+1, *r.Min, *r.Max)
+		}
+		plan = append(plan, Interval{*r.Min, *r.Max})
+	}
+	if len(plan) == 0 {
+		return nil, fmt.Errorf("%s: no intervals", path)
+	}
+
+	sorted := slices.Clone(plan)
+	slices.SortFunc(sorted, func(a, b Interval) int { return cmp.Compare(a[0], b[0]) })
+	for i := 1; i < len(sorted); i++ {
+		if prev := sorted[i-1]; sorted[i][0] < prev[1] && !sameBound(sorted[i][0], prev[1], precision) {
+			return nil, fmt.Errorf("%s: intervals %v and %v overlap", path, prev, sorted[i])
+		}
+	}
+	return plan, nil
+}
+
+// writeIntervals saves intervals to path as a JSON array, or removes the
+// file when there is nothing left to save.
+func writeIntervals(path string, intervals []Interval) error {
+	if len(intervals) == 0 {
+		err := os.Remove(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	data, err := json.Marshal(intervals)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errRobotsDisallowed = errors.New("disallowed by robots.txt")
+
+// robotsVerdict is what a robots.txt says about our requests.
+type robotsVerdict struct {
+	Found   bool   // false without a robots.txt, which allows everything
+	Group   string // the user-agent of the group that applies, if any
+	Allowed bool
+	Rule    string // deciding the verdict, e.g. "Disallow: /products"
+	// CrawlDelay is the time asked for between requests, from Crawl-delay
+	// or Request-rate.
+	CrawlDelay time.Duration
+}
+
+func (v robotsVerdict) String() string {
+	if !v.Found {
+		return "no robots.txt, allowed"
+	}
+	verdict := "allowed"
+	if !v.Allowed {
+		verdict = "disallowed"
+	}
+	if v.Rule != "" {
+		verdict += " by " + v.Rule
+	}
+	if v.Group != "" {
+		verdict += fmt.Sprintf(" for %q", v.Group)
+	}
+	if v.CrawlDelay > 0 {
+		verdict += fmt.Sprintf(", crawl delay %v", v.CrawlDelay)
+	}
+	return verdict
+}
+
+type robotsRule struct {
+	allow   bool
+	pattern string
+}
+
+type robotsGroup struct {
+	agents []string
+	rules  []robotsRule
+	delay  time.Duration
+}
+
+// parseRobots reads the groups of a robots.txt. Lines it doesn't know,
+// like Sitemap, Host or Clean-param, are skipped.
+func parseRobots(r io.Reader) ([]*robotsGroup, error) {
+	var groups []*robotsGroup
+	var g *robotsGroup
+	inAgents := false
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line, _, _ := strings.Cut(sc.Text(), "#")
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(key, "\ufeff")))
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "user-agent":
+			// Consecutive user-agent lines share a group.
+			if !inAgents {
+				g = &robotsGroup{}
+				groups = append(groups, g)
+				inAgents = true
+			}
+			g.agents = append(g.agents, strings.ToLower(value))
+			continue
+		case "allow", "disallow":
+			// An empty Disallow allows everything, like no rule.
+			if g != nil && value != "" {
+				g.rules = append(g.rules, robotsRule{allow: key == "allow", pattern: value})
+			}
+		case "crawl-delay":
+			if s, err := strconv.ParseFloat(value, 64); g != nil && err == nil && s > 0 {
+				g.delay = time.Duration(s * float64(time.Second))
+			}
+		case "request-rate":
+			// n/t, n requests per t seconds, maybe with a unit: "1/5s".
+			n, t, ok := strings.Cut(value, "/")
+			requests, err1 := strconv.ParseFloat(strings.TrimSpace(n), 64)
+			seconds, err2 := strconv.ParseFloat(strings.TrimRight(strings.TrimSpace(t), "s"), 64)
+			if g != nil && ok && err1 == nil && err2 == nil && requests > 0 && g.delay == 0 {
+				g.delay = time.Duration(seconds / requests * float64(time.Second))
+			}
+		}
+		inAgents = false
+	}
+	return groups, sc.Err()
+}
+
+// judgeRobots gives the verdict of groups on path (with its query) for
+// agent. The group of the longest user-agent within agent applies, or
+// else that of "*"; of its rules the longest matching pattern wins, Allow
+// winning ties.
+func judgeRobots(groups []*robotsGroup, agent, path string) robotsVerdict {
+	agent = strings.ToLower(agent)
+	best := ""
+	for _, g := range groups {
+		for _, a := range g.agents {
+			if a != "*" && strings.Contains(agent, a) && len(a) > len(best) {
+				best = a
+			}
+		}
+	}
+	if best == "" {
+		best = "*"
+	}
+
+	v := robotsVerdict{Found: true, Allowed: true}
+	var rule *robotsRule
+	for _, g := range groups {
+		for _, a := range g.agents {
+			if a != best {
+				continue
+			}
+			v.Group = best
+			v.CrawlDelay = max(v.CrawlDelay, g.delay)
+			for i, r := range g.rules {
+				if !robotsMatch(r.pattern, path) {
+					continue
+				}
+				if rule == nil || len(r.pattern) > len(rule.pattern) || len(r.pattern) == len(rule.pattern) && r.allow {
+					rule = &g.rules[i]
+				}
+			}
+		}
+	}
+	if rule != nil {
+		v.Allowed = rule.allow
+		v.Rule = "Disallow: " + rule.pattern
+		if rule.allow {
+			v.Rule = "Allow: " + rule.pattern
+		}
+	}
+	return v
+}
+
+// robotsMatch tells whether path starts with pattern, where * matches
+// any run of characters and a trailing $ anchors the end.
+func robotsMatch(pattern, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		if anchored {
+			return path == pattern
+		}
+		return strings.HasPrefix(path, pattern)
+	}
+
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	rest := path[len(parts[0]):]
+	for _, p := range parts[1 : len(parts)-1] {
+		i := strings.Index(rest, p)
+		if i < 0 {
+			return false
+		}
+		rest = rest[i+len(p):]
+	}
+	last := parts[len(parts)-1]
+	if anchored {
+		return strings.HasSuffix(rest, last)
+	}
+	return strings.Contains(rest, last)
+}
+
+// robots fetches the robots.txt of the API's host and judges the endpoint
+// by it for our user agents. A missing one (any 4xx) allows everything; a server error fails,
+// not to run against a robots.txt we couldn't read.
+func (s *Scraper) robots(ctx context.Context) (robotsVerdict, error) {
+	u := *s.cfg.BaseURL
+	path := u.EscapedPath()
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+	u.Path, u.RawPath, u.RawQuery, u.Fragment = "/robots.txt", "", "", ""
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return robotsVerdict{}, err
+	}
+	resp, err := Chain(s.client, s.middleware...).Do(req)
+	if err != nil {
+		return robotsVerdict{}, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 400 && resp.StatusCode < 500:
+		return robotsVerdict{Allowed: true}, nil
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return robotsVerdict{}, &StatusError{StatusCode: resp.StatusCode}
+	}
+	// Crawlers need not read past 500 KiB.
+	groups, err := parseRobots(io.LimitReader(resp.Body, 500<<10))
+	if err != nil {
+		return robotsVerdict{}, err
+	}
+	// With several user agents, the strictest verdict stands.
+	agents := s.cfg.UserAgents
+	if len(agents) == 0 {
+		agents = []string{s.cfg.UserAgent}
+	}
+	var v robotsVerdict
+	for i, agent := range agents {
+		av := judgeRobots(groups, agent, path)
+		if i == 0 || v.Allowed && !av.Allowed {
+			av.CrawlDelay = max(av.CrawlDelay, v.CrawlDelay)
+			v = av
+		}
+		v.CrawlDelay = max(v.CrawlDelay, av.CrawlDelay)
+	}
+	return v, nil
+}
+
+// obeyRobots refuses to run where robots.txt disallows the endpoint,
+// unless Config.IgnoreRobots, and lowers Config.RPS and Config.RetryRPS to
+// its crawl delay, which the run's token buckets then keep without a burst.
+func (s *Scraper) obeyRobots(ctx context.Context) error {
+	v, err := s.robots(ctx)
+	if err != nil {
+		return fmt.Errorf("reading robots.txt: %w", err)
+	}
+	s.cfg.Logger.Info("robots.txt", "verdict", v.String())
+	if !v.Allowed {
+		if !s.cfg.IgnoreRobots {
+			return fmt.Errorf("%s: %w (%s for %q)", s.cfg.BaseURL.Redacted(), errRobotsDisallowed, v.Rule, v.Group)
+		}
+		s.warn(fmt.Sprintf("robots.txt disallows %s, ignored", s.cfg.BaseURL.Redacted()))
+	}
+	if v.CrawlDelay <= 0 {
+		return nil
+	}
+	rps := float64(time.Second) / float64(v.CrawlDelay)
+	switch {
+	case s.cfg.RateLimiter != nil:
+		s.warn(fmt.Sprintf("robots.txt asks for a crawl delay of %v, which a custom RateLimiter doesn't enforce", v.CrawlDelay))
+	case s.cfg.RPS > rps:
+		s.cfg.Logger.Info("lowering the rate to robots.txt's crawl delay", "rps", rps, "was", s.cfg.RPS)
+		s.cfg.RPS = rps
+	}
+	if s.cfg.RateLimiter == nil {
+		s.crawlDelay = v.CrawlDelay
+	}
+	s.cfg.RetryRPS = min(s.cfg.RetryRPS, rps)
+	return nil
+}
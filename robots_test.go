@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestCrawlDelay scrapes, with a session to warm up and retries on their
+// own lane, an API whose robots.txt asks for 10s between requests: no two
+// are sent closer, the first ones included.
+func TestCrawlDelay(t *testing.T) {
+	catalog := testCatalog(3000)
+	clock := newFakeClock()
+	var mu sync.Mutex
+	var sent []time.Time
+	cfg := testConfig(DoerFunc(func(req *http.Request) (*http.Response, error) {
+		if req.URL.Path == "/robots.txt" {
+			return simulatedResponse(req, http.StatusOK, []byte("User-agent: *\nCrawl-delay: 10\n")), nil
+		}
+		mu.Lock()
+		sent = append(sent, clock.Now())
+		mu.Unlock()
+		if req.URL.Path == "/landing" {
+			return simulatedResponse(req, http.StatusOK, nil), nil
+		}
+		return catalog.Do(req)
+	}))
+	cfg.Clock = clock
+	cfg.RespectRobots = true
+	cfg.SessionURL = "http://shop.test/landing"
+	cfg.RetryRPS = 100
+	s := testScraper(t, cfg)
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(time.Millisecond):
+				clock.Advance(time.Second)
+			}
+		}
+	}()
+	res, err := s.Run(context.Background())
+	close(done)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Complete {
+		t.Fatal("incomplete run")
+	}
+	if s.cfg.RPS != 0.1 || s.cfg.RetryRPS != 0.1 {
+		t.Errorf("%v RPS, %v for retries, want both 0.1", s.cfg.RPS, s.cfg.RetryRPS)
+	}
+	if len(sent) < 3 {
+		t.Fatalf("%d requests", len(sent))
+	}
+	for i := 1; i < len(sent); i++ {
+		if gap := sent[i].Sub(sent[i-1]); gap < 10*time.Second {
+			t.Errorf("request %d sent %v after the previous one", i+1, gap)
+		}
+	}
+}
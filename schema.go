@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var errContentType = errors.New("unexpected response content type")
+
+// ResponseSchema names the top-level fields of the responses of an API
+// version, and the media type asking for it.
+type ResponseSchema struct {
+	// MediaType is sent as Accept unless Config.Accept is set.
+	MediaType  string
+	Total      string
+	Count      string
+	Products   string
+	NextCursor string
+}
+
+// responseSchemas are the known schemas by Config.APIVersion. Only "", the
+// one the scraper was written against, is built in: it asks for no media
+// type, so set Config.Accept for a server whose default version is another.
+var responseSchemas = map[string]ResponseSchema{
+	"": {Total: "total", Count: "count", Products: "products", NextCursor: "nextCursor"},
+}
+
+// RegisterSchema makes the schema of an API version known, to be selected
+// with Config.APIVersion, e.g. one naming its fields differently, asked
+// for with its own media type. It must be called before the run, e.g.
+// from init.
+func RegisterSchema(version string, s ResponseSchema) {
+	responseSchemas[version] = s
+}
+
+// schemaVersions lists the known API versions, the original one as "".
+func schemaVersions() []string {
+	var versions []string
+	for v := range responseSchemas {
+		versions = append(versions, strconv.Quote(v))
+	}
+	slices.Sort(versions)
+	return versions
+}
+
+// accept is the Accept header to send, if any.
+func (cfg *Config) accept() string {
+	if cfg.Accept != "" {
+		return cfg.Accept
+	}
+	return responseSchemas[cfg.APIVersion].MediaType
+}
+
+// checkContentType fails a response of another media type than the ones
+// asked for, which would have a schema we can't decode, e.g. a server
+// falling back to its default version. Nothing is checked without an
+// Accept header.
+func (cfg *Config) checkContentType(h http.Header) error {
+	accept := cfg.accept()
+	if accept == "" {
+		return nil
+	}
+	got, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		return fmt.Errorf("%w %q, asked for %q", errContentType, h.Get("Content-Type"), accept)
+	}
+	for _, want := range strings.Split(accept, ",") {
+		want, _, err := mime.ParseMediaType(strings.TrimSpace(want))
+		if err == nil && (want == got || want == "*/*") {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w %q, asked for %q", errContentType, got, accept)
+}
+
+// requiredProductFields are those a product must have in a strict decode.
+var requiredProductFields = []string{"id", "name", "price"}
+
+// SchemaError fails a response with a field the strict decode doesn't
+// know, or a product missing one it needs, as when the API renames a
+// field. Retrying won't help.
+type SchemaError struct {
+	Field   string
+	In      string // "response" or "product"
+	Missing bool
+}
+
+func (e *SchemaError) Error() string {
+	if e.Missing {
+		return fmt.Sprintf("schema: %s has no %q field", e.In, e.Field)
+	}
+	return fmt.Sprintf("schema: unexpected field %q in %s", e.Field, e.In)
+}
+
+// decodeStrict decodes a product, failing with a SchemaError on unknown
+// or missing fields.
+func decodeStrict(raw json.RawMessage, p *Product) error {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(p); err != nil {
+		// encoding/json has no error type for unknown fields.
+		if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+			if f, uerr := strconv.Unquote(field); uerr == nil {
+				field = f
+			}
+			return &SchemaError{Field: field, In: "product"}
+		}
+		return err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return err
+	}
+	for _, f := range requiredProductFields {
+		if _, ok := fields[f]; !ok {
+			return &SchemaError{Field: f, In: "product", Missing: true}
+		}
+	}
+	return nil
+}
+
+// driftFraction of the products having a zero price, or no name, hints
+// that the API renamed the field, see Scraper.warnSchemaDrift.
+const driftFraction = 0.5
+
+// warnSchemaDrift warns in the Result of a run whose products mostly lack
+// a price or a name, which a lenient decode leaves zero.
+func (s *Scraper) warnSchemaDrift() {
+	n := s.products.Load()
+	if n < 10 {
+		return
+	}
+	for _, c := range []struct {
+		field string
+		count int64
+	}{{"price", s.zeroPrices.Load()}, {"name", s.emptyNames.Load()}} {
+		if float64(c.count) >= driftFraction*float64(n) {
+			s.warn(fmt.Sprintf("schema drift? %d of %d products have no %s: check the API's field names, or fail on unknown fields with -strict-schema", c.count, n, c.field))
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// registerTestSchema registers, for the test, a version naming its fields
+// differently from the original one.
+func registerTestSchema(t *testing.T) ResponseSchema {
+	schema := ResponseSchema{MediaType: "application/vnd.shop.test+json", Total: "hits", Count: "size", Products: "items", NextCursor: "next"}
+	RegisterSchema("test", schema)
+	t.Cleanup(func() { delete(responseSchemas, "test") })
+	return schema
+}
+
+func TestAPIVersion(t *testing.T) {
+	schema := registerTestSchema(t)
+	catalog := testCatalog(3000)
+	// The catalog answers in the original schema: rename its fields.
+	var accepts, fallbacks atomic.Int64
+	cfg := testConfig(DoerFunc(func(req *http.Request) (*http.Response, error) {
+		resp, err := catalog.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if req.Header.Get("Accept") != schema.MediaType {
+			fallbacks.Add(1)
+			return resp, nil
+		}
+		accepts.Add(1)
+		var fields map[string]json.RawMessage
+		if err := json.NewDecoder(resp.Body).Decode(&fields); err != nil {
+			return nil, err
+		}
+		renamed := map[string]json.RawMessage{}
+		for from, to := range map[string]string{"total": "hits", "count": "size", "products": "items"} {
+			renamed[to] = fields[from]
+		}
+		body, err := json.Marshal(renamed)
+		if err != nil {
+			return nil, err
+		}
+		resp.Header.Set("Content-Type", schema.MediaType+"; charset=utf-8")
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		return resp, nil
+	}))
+	cfg.APIVersion = "test"
+	res, err := testScraper(t, cfg).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Complete || len(res.Products) != catalog.Valid() {
+		t.Errorf("complete %v with %d products, want %d", res.Complete, len(res.Products), catalog.Valid())
+	}
+	if accepts.Load() == 0 || fallbacks.Load() > 0 {
+		t.Errorf("%d requests asked for %s, %d didn't", accepts.Load(), schema.MediaType, fallbacks.Load())
+	}
+}
+
+func TestUnknownAPIVersion(t *testing.T) {
+	cfg := testConfig(testCatalog(10))
+	cfg.APIVersion = "v2"
+	if _, err := NewScraper(cfg, WithBaseURL("http://shop.test/products")); err == nil || !strings.Contains(err.Error(), "unknown APIVersion") {
+		t.Errorf("NewScraper with an unregistered APIVersion: %v", err)
+	}
+}
+
+func TestCheckContentType(t *testing.T) {
+	schema := registerTestSchema(t)
+	for _, tc := range []struct {
+		version, accept, contentType string
+		ok                           bool
+	}{
+		{"", "", "text/plain", true},
+		{"test", "", schema.MediaType, true},
+		{"test", "", schema.MediaType + "; charset=utf-8", true},
+		{"test", "", "application/json", false},
+		{"test", "", "", false},
+		{"", "application/json, " + schema.MediaType, schema.MediaType, true},
+		{"", "application/json", "text/html", false},
+		{"", "*/*", "text/html", true},
+	} {
+		cfg := &Config{APIVersion: tc.version, Accept: tc.accept}
+		err := cfg.checkContentType(http.Header{"Content-Type": {tc.contentType}})
+		if (err == nil) != tc.ok || err != nil && !errors.Is(err, errContentType) {
+			t.Errorf("version %q, accept %q: content type %q: %v", tc.version, tc.accept, tc.contentType, err)
+		}
+	}
+}
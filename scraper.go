@@ -0,0 +1,1493 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	errBudgetExhausted   = errors.New("request budget exhausted")
+	errMaxProducts       = errors.New("max products reached")
+	errInvalidPrices     = errors.New("response has products with invalid prices")
+	errShortfall         = errors.New("collected fewer products than the API's total")
+	errNoPagination      = errors.New("the API ignored the page offset")
+	errUnsplittable      = errors.New("too many products for the narrowest interval")
+	errIntervalCancelled = errors.New("interval cancelled")
+	errCursorLoop        = errors.New("the API returned a cursor twice")
+	errRanTwice          = errors.New("the scraper already ran: make a new one to run again")
+)
+
+type Stats struct {
+	// StopReason says why the run ended, e.g. "completed", the request
+	// budget running out or the run being cancelled.
+	StopReason  string
+	Requests    int64
+	MaxRequests int64
+	Products    int64
+	Workers     int // workers alive when the run ended
+	PeakWorkers int
+	ScaleUps    int
+	ScaleDowns  int
+	// Summed over all workers.
+	PolitenessSleep time.Duration
+	TokenWait       time.Duration
+	// Failed requests: network errors and truncated bodies, failed DNS
+	// lookups of the API's host, error statuses from the server, and
+	// anything else (e.g. bad JSON).
+	NetworkErrors int64
+	DNSErrors     int64
+	StatusErrors  int64
+	OtherErrors   int64
+	// CircuitOpen counts requests a CircuitBreaker failed without sending.
+	CircuitOpen int64
+	// Responses whose count disagreed with their products, and whose count
+	// exceeded apiLimit.
+	CountMismatches int64
+	OverLimitCounts int64
+	// Covered is the fraction of the planned price space fetched in full;
+	// SkippedCovered counts intervals dropped as already covered.
+	Covered        float64
+	SkippedCovered int64
+	// ExcludedWidth is the price space left out of the plan by
+	// Config.ExcludeRanges; ExcludedProducts counts the products dropped
+	// for being priced within them.
+	ExcludedWidth    float64
+	ExcludedProducts int64
+	// AboveMaxPrice counts the products dropped for being priced above
+	// the price range, returned through Config.MaxPriceExclusive's
+	// widening by an API whose bound is inclusive after all.
+	AboveMaxPrice int64
+	// Cancelled counts intervals given up on with Scraper.CancelInterval.
+	Cancelled int64
+	// ResponseDuplicates counts products repeated within a response.
+	ResponseDuplicates int64
+	// FilteredOut counts unique products left out by Config.AllowIDs and
+	// Config.DenyIDs.
+	FilteredOut int64
+	// SinkFailures counts products the sink failed to write that were
+	// dead-lettered instead.
+	SinkFailures int64
+	// Pages counts requests for pages after the first, see
+	// Config.PaginateBelow and Config.CursorParam.
+	Pages int64
+	// Panics counts attempts that panicked, which are retried.
+	Panics int64
+	// Retries counts retry attempts queued; RetryLaneWait is the time
+	// they were held back by Config.RetryRPS in total.
+	Retries       int64
+	RetryLaneWait time.Duration
+	// Keys tells the requests sent with each of Config.BearerTokens, and
+	// which were burned.
+	Keys []KeyStats
+	// SessionWarmUps counts the visits to Config.SessionURL.
+	SessionWarmUps int64
+	// DNSLookups counts the lookups of Config.DNSCacheTTL's cache, and
+	// DNSStaleAnswers those it answered from an expired entry as the DNS
+	// server failed.
+	DNSLookups      int64
+	DNSStaleAnswers int64
+	// LocalAddrRequests counts the requests sent from each of
+	// Config.LocalAddrs.
+	LocalAddrRequests map[string]int64
+	// WorkerRequests counts the requests made by each worker, by number
+	// from 1; those made outside workers, for the totals, are under 0.
+	// They sum up to Requests.
+	WorkerRequests map[int]int64
+	// Paused is the time spent paused with Scraper.Pause.
+	Paused time.Duration
+	// Rate is the limiter's requests per second, if it reports one; it
+	// moves during a warm-up.
+	Rate float64
+	// Extrapolated from the sampled intervals when Config.Sample is set.
+	SampledIntervals  int
+	EstimatedProducts int64
+	EstimatedRequests int64
+}
+
+type Scraper struct {
+	cfg      *Config
+	warnings []string
+	queue    *intervalQueue
+	limiter  RateLimiter
+	// running is set while limiter paces requests, see extraTurn.
+	running atomic.Bool
+	// crawlDelay is robots.txt's, for Config.RespectRobots.
+	crawlDelay time.Duration
+	// Holds retries back when Config.RetryRPS is set.
+	retryLane *intervalQueue
+	doer      Doer
+	pChan     chan foundProducts
+	eChan     chan FailedInterval
+	wg        sync.WaitGroup
+
+	// client sends the requests, before Config.Middleware: Config.Client,
+	// the one of Config.LocalAddrs or that resolving through the DNS
+	// cache, within the session if any.
+	client  Doer
+	local   *localAddrs
+	dns     *dnsCache
+	session *session
+	keys    *keyPool // see Config.BearerTokens
+	// middleware is Config.Middleware after setting the User-Agent.
+	middleware []Middleware
+	uaMu       sync.Mutex
+	uaRng      *rand.Rand
+	schema     ResponseSchema // of Config.APIVersion
+	uncovered  []Interval
+	partial    []Interval
+	// Requests by worker, see Stats.WorkerRequests.
+	workerRequests map[int]int64
+	mu             sync.Mutex
+	// What has been fetched in full, out of space (the plan's total width).
+	covered coverage
+	space   float64
+	// Config.ExcludeRanges, merged.
+	excluded      coverage
+	excludedWidth float64
+	cancelled     coverage   // see CancelInterval
+	tree          *splitTree // nil unless Config.SplitTreeDepth is set
+
+	retire chan struct{}
+	// The fetch stage: every goroutine of the run but the collectors, see
+	// spawn. A fatal error fails it, aborting the run.
+	fetch  *group
+	ran    atomic.Bool // see Run
+	events chan Event
+	failed chan FailedInterval // see FailedIntervals
+	// IDs of the products found, for Config.SpotChecks.
+	spotIDs map[ProductID]struct{}
+	spotMu  sync.Mutex
+	// Only touched by Run and the autoscaler goroutine, never concurrently.
+	peakWorkers, scaleUps, scaleDowns int
+
+	workers  atomic.Int32
+	started  atomic.Int64 // workers ever started, to number them and seed their RNGs
+	requests atomic.Int64
+	products atomic.Int64
+	done     atomic.Int64 // intervals finished, split or failed
+	slept    atomic.Int64 // nanoseconds of politeness delay
+	waited   atomic.Int64 // nanoseconds waiting for rate-limit tokens
+	retries  atomic.Int64
+	// failed requests by kind, see countError
+	networkErrors, statusErrors, otherErrors atomic.Int64
+	dnsErrors                                atomic.Int64
+	circuitOpen                              atomic.Int64
+	countMismatches, overLimitCounts         atomic.Int64
+	skippedCovered, cancelledIntervals       atomic.Int64
+	excludedProducts, aboveMaxPrice          atomic.Int64
+	zeroPrices, emptyNames                   atomic.Int64 // see warnSchemaDrift
+	pages                                    atomic.Int64 // beyond the first
+	responseDups                             atomic.Int64
+	sinkFailures                             atomic.Int64
+	panics                                   atomic.Int64
+	// Set up by sinkFailed on the first product dead-lettered.
+	deadProducts ProductSink
+
+	// nanoseconds retries spent in the retry lane
+	retryWaited atomic.Int64
+	// Set once a request was refused for lack of budget.
+	exhausted atomic.Bool
+
+	startTime time.Time
+	pauseMu   sync.Mutex
+	resumed   chan struct{} // non-nil while paused, closed on Resume
+	pausedAt  time.Time
+	pausedFor time.Duration // previous pauses
+}
+
+func NewScraper(cfg *Config, opts ...Option) (*Scraper, error) {
+	for _, opt := range opts {
+		if err := opt(cfg); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.BaseURL == nil {
+		u, err := url.Parse(apiURL)
+		if err != nil {
+			return nil, err
+		}
+		cfg.BaseURL = u
+	}
+	auths := 0
+	for _, set := range []bool{cfg.BearerToken != "", cfg.BasicAuth != nil, len(cfg.BearerTokens) > 0} {
+		if set {
+			auths++
+		}
+	}
+	if auths > 1 {
+		return nil, errors.New("only one of BearerToken, BasicAuth and BearerTokens can be set")
+	}
+	if r := cfg.PriceRange; r != nil && !(0 <= r[0] && r[0] < r[1] && r[1] <= maxPrice) {
+		return nil, fmt.Errorf("invalid price range %v: want 0 <= lo < hi <= %v", *r, maxPrice)
+	}
+	for _, r := range cfg.ExcludeRanges {
+		if !(0 <= r[0] && r[0] < r[1]) {
+			return nil, fmt.Errorf("invalid excluded range %v: want 0 <= lo < hi", r)
+		}
+	}
+	if r := cfg.SplitRatio; r != 0 && !(0 < r && r < 1) {
+		return nil, fmt.Errorf("invalid split ratio %v: want 0 < ratio < 1", r)
+	}
+	if cfg.SplitRatio == 0 {
+		cfg.SplitRatio = defaultSplitRatio
+	}
+	if cfg.SinkFailure == SinkFailDeadLetter && cfg.DeadLetterDir == "" {
+		return nil, errors.New("dead-lettering sink failures needs a DeadLetterDir")
+	}
+	if cfg.PricePrecision <= 0 {
+		cfg.PricePrecision = pricePrecision
+	}
+	if cfg.OffsetParam == "" {
+		cfg.OffsetParam = offsetParam
+	}
+	if cfg.CursorParam == "" {
+		cfg.CursorParam = cursorParam
+	}
+	if (cfg.MinIDParam == "") != (cfg.MaxIDParam == "") {
+		return nil, errors.New("splitting by ID needs both MinIDParam and MaxIDParam")
+	}
+	if cfg.SinceID != 0 && !(0 < cfg.SinceID && cfg.SinceID < maxID) {
+		return nil, fmt.Errorf("invalid SinceID %d: want 0 < id < %d", cfg.SinceID, maxID)
+	}
+	if cfg.SinceID != 0 && cfg.MinIDParam == "" {
+		return nil, errors.New("SinceID needs MinIDParam and MaxIDParam")
+	}
+	if cfg.NoSplit && cfg.SplitUntilComplete {
+		return nil, errors.New("NoSplit and SplitUntilComplete can't be used together")
+	}
+	if cfg.MinPriceParam == "" {
+		cfg.MinPriceParam = minPriceParam
+	}
+	if cfg.MaxPriceParam == "" {
+		cfg.MaxPriceParam = maxPriceParam
+	}
+	if cfg.UpdatedSinceParam == "" {
+		cfg.UpdatedSinceParam = updatedSinceParam
+	}
+	reserved := []string{cfg.MinPriceParam, cfg.MaxPriceParam, cfg.MinIDParam, cfg.MaxIDParam, cfg.OffsetParam, cfg.CursorParam, "limit"}
+	if !cfg.UpdatedSince.IsZero() {
+		reserved = append(reserved, cfg.UpdatedSinceParam)
+	}
+	for _, name := range reserved {
+		if cfg.ExtraParams.Has(name) {
+			return nil, fmt.Errorf("extra param %q is set by the scraper itself", name)
+		}
+	}
+	if cfg.MaxWorkers <= 0 {
+		cfg.MaxWorkers = workerNum
+	}
+	if cfg.MinWorkers <= 0 {
+		cfg.MinWorkers = 1
+	}
+	if cfg.MinWorkers > cfg.MaxWorkers {
+		cfg.MinWorkers = cfg.MaxWorkers
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = systemClock{}
+	}
+	var dns *dnsCache
+	if cfg.DNSCacheTTL > 0 || cfg.Resolver != "" {
+		if cfg.Client != nil {
+			return nil, errors.New("DNSCacheTTL and Resolver can't be used with a Client")
+		}
+		var err error
+		if dns, err = newDNSCache(cfg.Resolver, cfg.DNSCacheTTL, cfg.Clock); err != nil {
+			return nil, err
+		}
+	}
+	var local *localAddrs
+	var client Doer
+	switch {
+	case len(cfg.LocalAddrs) > 0:
+		if cfg.Client != nil {
+			return nil, errors.New("LocalAddrs can't be used with a Client")
+		}
+		var err error
+		if local, err = newLocalAddrs(cfg.LocalAddrs, dns, cfg.checkRedirect); err != nil {
+			return nil, err
+		}
+		client = local
+	case dns != nil:
+		dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.DialContext = dns.dialContext(dialer)
+		client = &http.Client{Transport: t, CheckRedirect: cfg.checkRedirect}
+	case cfg.Client == nil:
+		cfg.Client = &http.Client{CheckRedirect: cfg.checkRedirect}
+	default:
+		client = cfg.withRedirectPolicy(cfg.Client)
+	}
+	if cfg.UserAgent == "" {
+		cfg.UserAgent = defaultUserAgent()
+	}
+	if cfg.SessionURL != "" {
+		if _, err := url.Parse(cfg.SessionURL); err != nil {
+			return nil, fmt.Errorf("invalid SessionURL: %w", err)
+		}
+		if cfg.SessionMethod == "" {
+			cfg.SessionMethod = http.MethodGet
+		}
+	}
+	if _, ok := responseSchemas[cfg.APIVersion]; !ok {
+		return nil, fmt.Errorf("unknown APIVersion %q, known: %s", cfg.APIVersion, strings.Join(schemaVersions(), ", "))
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+	if cfg.RetryableStatus == nil {
+		cfg.RetryableStatus = defaultRetryableStatus
+	}
+	if cfg.RPS <= 0 {
+		cfg.RPS = float64(time.Second / refreshRate)
+	}
+	if cfg.RateRemainingHeader == "" {
+		cfg.RateRemainingHeader = rateRemainingHeader
+	}
+	if cfg.RateResetHeader == "" {
+		cfg.RateResetHeader = rateResetHeader
+	}
+	if cfg.ProductBuffer <= 0 {
+		cfg.ProductBuffer = productBufferSize
+	}
+	if cfg.ErrorBuffer <= 0 {
+		cfg.ErrorBuffer = errorBufferSize
+	}
+
+	s := &Scraper{cfg: cfg, local: local, dns: dns, client: cfg.Client, uaRng: rand.New(rand.NewSource(cfg.Seed)),
+		schema: responseSchemas[cfg.APIVersion]}
+	// The User-Agent is set first, for the middleware to see.
+	s.middleware = append([]Middleware{s.userAgent}, cfg.Middleware...)
+	if client != nil {
+		s.client = client
+	}
+	if len(cfg.BearerTokens) > 0 {
+		s.keys = newKeyPool(cfg.BearerTokens, cfg.Logger)
+	}
+	if cfg.SessionURL != "" {
+		s.session = newSession(s.client, s.middleware, s.extraTurn, cfg)
+		s.client = s.session
+	}
+	// Workers beyond the burst mostly wait for tokens, holding memory and
+	// sockets for nothing.
+	if cfg.RateLimiter == nil && cfg.MaxWorkers > overProvisionFactor*tokenBucketSize {
+		s.warn(fmt.Sprintf("%d max workers for a rate-limit burst of %d: most will wait for tokens", cfg.MaxWorkers, tokenBucketSize))
+	}
+	return s, nil
+}
+
+// warn logs a configuration warning, also reported in Result.Warnings.
+func (s *Scraper) warn(msg string) {
+	s.cfg.Logger.Warn(msg)
+	s.warnings = append(s.warnings, msg)
+}
+
+// priceRange is the root interval of the scrape.
+func (cfg *Config) priceRange() Interval {
+	if cfg.PriceRange != nil {
+		return *cfg.PriceRange
+	}
+	return Interval{0, maxPrice}
+}
+
+// idRange is the inclusive range of IDs to scrape.
+func (cfg *Config) idRange() [2]int {
+	if cfg.SinceID > 0 {
+		return [2]int{cfg.SinceID + 1, maxID}
+	}
+	return [2]int{0, maxID}
+}
+
+// idFilter applies AllowIDs and DenyIDs, if any.
+func (cfg *Config) idFilter() func(Product) bool {
+	switch {
+	case len(cfg.AllowIDs) > 0:
+		return func(p Product) bool {
+			_, ok := cfg.AllowIDs[p.ID]
+			return ok
+		}
+	case len(cfg.DenyIDs) > 0:
+		return func(p Product) bool {
+			_, ok := cfg.DenyIDs[p.ID]
+			return !ok
+		}
+	}
+	return nil
+}
+
+// Run scrapes until every interval is covered or ctx is done. Work still
+// pending when ctx is cancelled is reported in Result.Uncovered. A Scraper
+// runs once: Run fails if called again, as its channels are closed.
+func (s *Scraper) Run(ctx context.Context) (*Result, error) {
+	if !s.ran.CompareAndSwap(false, true) {
+		return nil, errRanTwice
+	}
+	fetch, ctx := newGroup(ctx)
+	s.fetch = fetch
+	s.startTime = s.cfg.Clock.Now()
+	// The collect stage normally ends with its channels, but mustn't
+	// outlive the run if it returns without closing them. It outlives the
+	// fetch stage's ctx though, to drain the workers.
+	collect, collectCtx := newGroup(context.WithoutCancel(ctx))
+	// stop ends the fetch stage's goroutines that don't watch its ctx:
+	// the token refill and the queue dispatchers.
+	done := make(chan struct{})
+	stop := sync.OnceFunc(func() {
+		close(done)
+		if s.queue != nil {
+			s.queue.close()
+		}
+		if s.retryLane != nil {
+			s.retryLane.close()
+		}
+	})
+	// Nothing the run started outlives it, however it returns, even
+	// panicking.
+	defer func() {
+		s.running.Store(false)
+		fetch.cancel(nil)
+		stop()
+		fetch.Wait()
+		collect.cancel(nil)
+		collect.Wait()
+		if s.events != nil {
+			close(s.events)
+		}
+		if s.failed != nil {
+			close(s.failed)
+		}
+	}()
+
+	cfg := s.cfg
+	s.pChan = make(chan foundProducts, max(cfg.ProductBuffer/apiLimit, 1))
+	s.eChan = make(chan FailedInterval, cfg.ErrorBuffer)
+	s.retire = make(chan struct{})
+	iChan := make(chan IntervalInfo)
+	var queueRng *rand.Rand
+	if cfg.Shuffle {
+		queueRng = rand.New(rand.NewSource(cfg.Seed))
+	}
+	s.queue = newIntervalQueue(cfg.Order, cfg.PricePrecision, queueRng)
+	s.covered.precision = cfg.PricePrecision
+	s.excluded.precision = cfg.PricePrecision
+	s.cancelled.precision = cfg.PricePrecision
+	for _, r := range cfg.ExcludeRanges {
+		s.excluded.add(r)
+	}
+	if cfg.SplitTreeDepth > 0 {
+		s.tree = newSplitTree(cfg.SplitTreeDepth, cfg.PricePrecision)
+	}
+	if cfg.RespectRobots {
+		if err := s.obeyRobots(ctx); err != nil {
+			return nil, err
+		}
+	}
+	s.limiter = cfg.RateLimiter
+	if s.limiter == nil {
+		burst := tokenBucketSize
+		if s.crawlDelay > 0 {
+			burst = 1
+		}
+		tb := newTokenBucket(burst, time.Duration(float64(time.Second)/cfg.RPS), cfg.Clock)
+		s.spawn(func() { tb.refill(done) })
+		// Every worker may have a request in flight when the API reports
+		// its remaining budget.
+		tb.reserve = cfg.MaxWorkers
+		s.limiter = tb
+	}
+	if cfg.WarmUp > 0 {
+		s.limiter = newSlowStart(s.limiter, cfg.RPS, cfg.WarmUp, cfg.Clock, cfg.Logger)
+	}
+	s.doer = Chain(s.client, slices.Concat(s.middleware, []Middleware{s.rateLimit})...)
+	s.running.Store(true)
+
+	if s.session != nil {
+		if err := s.session.warmUp(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.DetectMaxPriceBound {
+		if err := s.detectMaxPriceBound(ctx); err != nil {
+			return nil, err
+		}
+	}
+	plan := cfg.Intervals
+	initialTotal := 0
+	empty := false
+	if plan == nil {
+		// Initial request to make estimation of intervals
+		res, err := s.initialReq(ctx)
+		if err != nil {
+			return nil, err
+		}
+		initialTotal = res.Total
+
+		root := cfg.priceRange()
+		// An empty catalog (or range) makes an empty, complete run without
+		// further requests.
+		empty = res.Total == 0
+		if empty {
+			cfg.Logger.Info("no products to scrape", "range", root)
+		}
+		plan = planIntervals(res.Total, apiLimit, root)
+	}
+	if len(cfg.ExcludeRanges) > 0 {
+		plan = s.trimExcluded(plan)
+	}
+	if cfg.Shuffle {
+		plan = append([]Interval(nil), plan...)
+		rng := rand.New(rand.NewSource(cfg.Seed))
+		rng.Shuffle(len(plan), func(i, j int) { plan[i], plan[j] = plan[j], plan[i] })
+	}
+
+	var skipped []Interval
+	planned := len(plan)
+	if cfg.Sample > 0 && cfg.Sample < 1 {
+		plan, skipped = sample(plan, cfg.Sample, cfg.Seed)
+	}
+	initialRequests := s.requests.Load()
+
+	cfg.Logger.Info("interval plan", "intervals", len(plan), "skipped", len(skipped), "total", initialTotal)
+	if cfg.OnPlan != nil {
+		cfg.OnPlan(slices.Clone(plan))
+	}
+	if cfg.PreFanoutDelay > 0 {
+		// Cancelling here still goes through the pipeline, which reports
+		// the whole plan as uncovered.
+		sleep(ctx, cfg.Clock, cfg.PreFanoutDelay)
+	}
+
+	for _, interval := range plan {
+		s.space += float64(width(interval))
+	}
+	s.wg.Add(len(plan))
+	for _, interval := range plan {
+		s.queue.push(IntervalInfo{interval: interval, nRetry: 0})
+	}
+
+	if cfg.RetryRPS > 0 {
+		s.retryLane = newIntervalQueue(FIFO, cfg.PricePrecision, nil)
+		tb := newTokenBucket(1, time.Duration(float64(time.Second)/cfg.RetryRPS), cfg.Clock)
+		s.spawn(func() { tb.refill(done) })
+		s.spawn(func() { s.pumpRetries(ctx, tb) })
+	}
+	s.spawn(func() { s.queue.dispatch(iChan) })
+	for i := 0; i < cfg.MinWorkers; i++ {
+		s.startWorker(ctx, iChan)
+	}
+
+	sink := cfg.Sink
+	if sink != nil {
+		sink = guardedSink{ProductSink: sink, onErr: s.sinkFailed}
+	}
+	spill := &spillSink{dir: cfg.SpillDir}
+	var spillTo *spiller
+	if cfg.SpillAfter > 0 && !cfg.StatsOnly {
+		// Unlike the sink's, a spilled product is part of the Result: losing
+		// one is always fatal.
+		spillTo = &spiller{
+			dir:     cfg.SpillDir,
+			runSize: cfg.SpillAfter,
+			out: guardedSink{ProductSink: spill, onErr: func(p Product, err error) {
+				s.fatal(fmt.Errorf("spilling product %s: %w", p.ID, err))
+			}},
+			fail: func(err error) { s.fatal(fmt.Errorf("spilling products: %w", err)) },
+		}
+	}
+	pl := getProductsList(collectCtx, s.pChan, cfg.MaxProducts, initialTotal, func() { fetch.cancel(errMaxProducts) }, cfg.idFilter(), sink, !cfg.StatsOnly, spillTo, collect)
+	el := getErrorsList(collectCtx, s.eChan, collect)
+
+	stopScaler := make(chan struct{})
+	s.spawn(func() { s.autoscale(ctx, iChan, stopScaler) })
+
+	s.wg.Wait()
+	close(stopScaler)
+
+	// The catalog may have changed during the run, re-read the total so
+	// callers can tell churn from missing products.
+	finalTotal := 0
+	if ctx.Err() == nil && !empty {
+		res, err := s.initialReq(ctx)
+		if err == nil {
+			finalTotal = res.Total
+		} else if !errors.Is(err, errBudgetExhausted) {
+			cfg.Logger.Warn("final total probe failed", "err", err)
+		}
+	}
+
+	var spotChecks []SpotCheck
+	if cfg.SpotChecks > 0 && ctx.Err() == nil && !empty {
+		spotChecks = s.spotCheck(ctx)
+	}
+
+	// Every interval is accounted for, shut the stages down in order. The
+	// fetch stage (queues, workers, rate limiting, autoscaling) stops
+	// first; once it has, nothing writes to pChan and eChan anymore and
+	// closing them ends the collect stage, which owns the sink.
+	stop()
+	fetch.Wait()
+	close(s.pChan)
+	close(s.eChan)
+	collect.Wait()
+	// Read once the collect stage stopped too, a spill error being fatal.
+	fatalErr := fetch.Err()
+
+	stats := s.stats()
+	stats.FilteredOut = int64(pl.filtered)
+	if len(skipped) > 0 && len(plan) > 0 {
+		scale := float64(planned) / float64(len(plan))
+		stats.SampledIntervals = len(plan)
+		stats.EstimatedProducts = int64(float64(stats.Products) * scale)
+		stats.EstimatedRequests = initialRequests + int64(float64(stats.Requests-initialRequests)*scale)
+	}
+	switch {
+	case s.exhausted.Load():
+		stats.StopReason = errBudgetExhausted.Error()
+	case ctx.Err() != nil:
+		stats.StopReason = context.Cause(ctx).Error()
+	default:
+		stats.StopReason = "completed"
+	}
+
+	s.warnSchemaDrift()
+	truncated := len(s.uncovered) > 0 || ctx.Err() != nil
+	spillErr := spill.Flush()
+	res := &Result{
+		Products:      pl.products,
+		SpillFile:     spill.path(),
+		ByInterval:    pl.byInterval,
+		Collected:     pl.collected,
+		Histogram:     pl.histogram.counts,
+		InvalidPrices: pl.invalid,
+		Failed:        el.intervals,
+		Complete:      !truncated && len(el.intervals) == 0 && len(skipped) == 0,
+		InitialTotal:  initialTotal,
+		FinalTotal:    finalTotal,
+		Truncated:     truncated,
+		Uncovered:     s.uncovered,
+		Partial:       s.partial,
+		Skipped:       skipped,
+		Warnings:      s.warnings,
+		Watermark:     s.startTime,
+		SpotChecks:    spotChecks,
+		Stats:         stats,
+	}
+	spotErr := s.settleSpotChecks(spotChecks, res)
+	for _, c := range spotChecks {
+		res.SpotMisses += len(c.Missing)
+	}
+	if s.tree != nil {
+		res.SplitTree = s.tree.roots
+	}
+
+	if len(res.Partial) > 0 {
+		cfg.Logger.Warn("intervals collected partially without splitting", "intervals", len(res.Partial))
+	}
+	// Only a complete run is expected to match the totals.
+	if res.Complete && len(cfg.ExcludeRanges) == 0 && len(res.Partial) == 0 {
+		res.Shortfall = shortfall(pl.collected+len(pl.invalid)+pl.filtered, initialTotal, finalTotal, cfg.CoverageTolerance)
+	}
+	if res.SpotMisses > 0 {
+		cfg.Logger.Warn("spot checks found missed products", "checks", len(spotChecks), "missing", res.SpotMisses)
+	}
+	if res.Shortfall > 0 {
+		cfg.Logger.Warn("coverage shortfall", "missing", res.Shortfall, "initial_total", initialTotal, "final_total", finalTotal)
+	}
+
+	var err error
+	if fatalErr != nil {
+		err = fmt.Errorf("run aborted with %d intervals left: %w", len(s.uncovered), fatalErr)
+	}
+	sinkErr := pl.sinkErr
+	if cfg.Sink != nil {
+		sinkErr = errors.Join(sinkErr, cfg.Sink.Flush())
+	}
+	if s.deadProducts != nil {
+		sinkErr = errors.Join(sinkErr, s.deadProducts.Flush())
+	}
+	if sinkErr != nil && fatalErr == nil {
+		err = fmt.Errorf("writing products: %w", sinkErr)
+	}
+	if spillErr != nil && fatalErr == nil {
+		err = errors.Join(err, fmt.Errorf("spilling products: %w", spillErr))
+	}
+	if spotErr != nil {
+		err = errors.Join(err, fmt.Errorf("settling spot checks: %w", spotErr))
+	}
+	if res.Shortfall > 0 && cfg.StrictCoverage {
+		err = errors.Join(err, fmt.Errorf("%w: %d missing", errShortfall, res.Shortfall))
+	}
+	return res, err
+}
+
+// shortfall is how many products short of the totals a run came, 0 when
+// it is within tolerance (a fraction) of the lower one. Anything between
+// the initial and final totals is explained by the catalog changing.
+func shortfall(collected, initialTotal, finalTotal int, tolerance float64) int {
+	expected := initialTotal
+	if finalTotal > 0 {
+		expected = min(expected, finalTotal)
+	}
+	missing := expected - collected
+	if float64(missing) <= float64(expected)*tolerance {
+		return 0
+	}
+	return missing
+}
+
+// sample keeps a seeded-random fraction of plan, at least one interval.
+func sample(plan []Interval, fraction float64, seed int64) (kept, skipped []Interval) {
+	rng := rand.New(rand.NewSource(seed))
+	for _, interval := range plan {
+		if rng.Float64() < fraction {
+			kept = append(kept, interval)
+		} else {
+			skipped = append(skipped, interval)
+		}
+	}
+	if len(kept) == 0 && len(skipped) > 0 {
+		i := rng.Intn(len(skipped))
+		kept = append(kept, skipped[i])
+		skipped = slices.Delete(skipped, i, i+1)
+	}
+	return kept, skipped
+}
+
+func (s *Scraper) stats() Stats {
+	st := Stats{
+		Requests:    s.requests.Load(),
+		MaxRequests: s.cfg.MaxRequests,
+		Products:    s.products.Load(),
+		Workers:     int(s.workers.Load()),
+		PeakWorkers: s.peakWorkers,
+		ScaleUps:    s.scaleUps,
+		ScaleDowns:  s.scaleDowns,
+
+		PolitenessSleep:    time.Duration(s.slept.Load()),
+		TokenWait:          time.Duration(s.waited.Load()),
+		NetworkErrors:      s.networkErrors.Load(),
+		DNSErrors:          s.dnsErrors.Load(),
+		StatusErrors:       s.statusErrors.Load(),
+		OtherErrors:        s.otherErrors.Load(),
+		CircuitOpen:        s.circuitOpen.Load(),
+		CountMismatches:    s.countMismatches.Load(),
+		OverLimitCounts:    s.overLimitCounts.Load(),
+		SkippedCovered:     s.skippedCovered.Load(),
+		ExcludedWidth:      s.excludedWidth,
+		ExcludedProducts:   s.excludedProducts.Load(),
+		AboveMaxPrice:      s.aboveMaxPrice.Load(),
+		Cancelled:          s.cancelledIntervals.Load(),
+		Pages:              s.pages.Load(),
+		ResponseDuplicates: s.responseDups.Load(),
+		SinkFailures:       s.sinkFailures.Load(),
+		Panics:             s.panics.Load(),
+		Retries:            s.retries.Load(),
+		RetryLaneWait:      time.Duration(s.retryWaited.Load()),
+	}
+	if r, ok := s.limiter.(rater); ok {
+		st.Rate = r.Rate()
+	}
+	if s.local != nil {
+		st.LocalAddrRequests = s.local.counts()
+	}
+	if s.dns != nil {
+		st.DNSLookups = s.dns.lookups.Load()
+		st.DNSStaleAnswers = s.dns.stale.Load()
+	}
+	if s.session != nil {
+		st.SessionWarmUps = s.session.warmUps.Load()
+	}
+	if s.keys != nil {
+		st.Keys = s.keys.snapshot()
+	}
+	s.mu.Lock()
+	st.WorkerRequests = maps.Clone(s.workerRequests)
+	s.mu.Unlock()
+	if s.space > 0 {
+		st.Covered = s.covered.width() / s.space
+	}
+	_, st.Paused = s.paused()
+	return st
+}
+
+// waitTurn waits until a request may be sent: the API is not paused and
+// the rate limiter lets it through.
+func (s *Scraper) waitTurn(ctx context.Context) error {
+	if err := s.waitResumed(ctx); err != nil {
+		return err
+	}
+	start := s.cfg.Clock.Now()
+	err := s.limiter.Wait(ctx)
+	s.waited.Add(int64(s.cfg.Clock.Now().Sub(start)))
+	return err
+}
+
+// extraTurn readies a request sent besides those of the intervals, like a
+// session warm-up: it is one more of the budget, and waits its turn. Out
+// of a run, for Ping, there is neither.
+func (s *Scraper) extraTurn(ctx context.Context) error {
+	if !s.running.Load() {
+		return nil
+	}
+	if !s.acquire(ctx) {
+		return errBudgetExhausted
+	}
+	return s.waitTurn(ctx)
+}
+
+// rateLimit is RateLimit on the scraper's limiter, accounting the wait.
+// No tokens are taken while the scraper is paused.
+func (s *Scraper) rateLimit(next Doer) Doer {
+	return DoerFunc(func(req *http.Request) (*http.Response, error) {
+		if err := s.waitTurn(req.Context()); err != nil {
+			return nil, err
+		}
+		if s.cfg.RequestTimeout <= 0 || s.cfg.TimeoutIncludesWait {
+			return next.Do(req)
+		}
+		ctx, cancel := context.WithTimeout(req.Context(), s.cfg.RequestTimeout)
+		resp, err := next.Do(req.WithContext(ctx))
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		resp.Body = cancelBody{resp.Body, cancel}
+		return resp, nil
+	})
+}
+
+// cancelBody releases the context of a response once its body is closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b cancelBody) Close() error {
+	defer b.cancel()
+	return b.ReadCloser.Close()
+}
+
+func (s *Scraper) startWorker(ctx context.Context, iChan <-chan IntervalInfo) {
+	n := int(s.workers.Add(1))
+	s.peakWorkers = max(s.peakWorkers, n)
+	id := s.started.Add(1)
+	rng := rand.New(rand.NewSource(s.cfg.Seed + id))
+	ctx = context.WithValue(ctx, workerKey{}, int(id))
+	s.spawn(func() { s.worker(ctx, iChan, rng) })
+}
+
+// autoscale adds workers while intervals are queued and rate-limit tokens
+// go unused, and retires idle workers once the queue is empty.
+func (s *Scraper) autoscale(ctx context.Context, iChan <-chan IntervalInfo, stop <-chan struct{}) {
+	scale := s.cfg.Clock.NewTicker(scaleInterval)
+	defer scale.Stop()
+	var progress <-chan time.Time
+	if s.cfg.ProgressInterval > 0 {
+		t := s.cfg.Clock.NewTicker(s.cfg.ProgressInterval)
+		defer t.Stop()
+		progress = t.C()
+	}
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-progress:
+			st := s.stats()
+			paused, _ := s.paused()
+			s.cfg.Logger.Info("progress", "done", s.done.Load(), "queued", s.queue.depth(),
+				"requests", st.Requests, "products", st.Products, "workers", st.Workers, "rps", st.Rate,
+				"covered", fmt.Sprintf("%.1f%%", st.Covered*100),
+				"eta", s.eta().Round(time.Second), "paused", paused)
+		case <-scale.C():
+			if paused, _ := s.paused(); paused {
+				continue
+			}
+			depth := s.queue.depth()
+			freeTokens := depth
+			if c, ok := s.limiter.(tokenCounter); ok {
+				freeTokens = c.available()
+			}
+			workers := int(s.workers.Load())
+
+			if add := min(depth, freeTokens, s.cfg.MaxWorkers-workers); add > 0 {
+				for i := 0; i < add; i++ {
+					s.startWorker(ctx, iChan)
+				}
+				s.scaleUps++
+				s.cfg.Logger.Info("scaling up", "workers", workers+add, "queued", depth, "freeTokens", freeTokens)
+				continue
+			}
+
+			if depth == 0 && workers > s.cfg.MinWorkers {
+				// Only a worker blocked waiting for work can receive, so an
+				// in-flight interval is never abandoned.
+				select {
+				case s.retire <- struct{}{}:
+					s.scaleDowns++
+					s.cfg.Logger.Info("scaling down", "workers", workers-1)
+				default:
+				}
+			}
+		}
+	}
+}
+
+// acquire takes one request from the budget, if there is one.
+func (s *Scraper) acquire(ctx context.Context) bool {
+	for {
+		n := s.requests.Load()
+		if s.cfg.MaxRequests > 0 && n >= s.cfg.MaxRequests {
+			s.exhausted.Store(true)
+			return false
+		}
+		if s.requests.CompareAndSwap(n, n+1) {
+			break
+		}
+	}
+
+	id, _ := workerFrom(ctx)
+	s.mu.Lock()
+	if s.workerRequests == nil {
+		s.workerRequests = map[int]int64{}
+	}
+	s.workerRequests[id]++
+	s.mu.Unlock()
+	return true
+}
+
+type workerKey struct{}
+
+// workerFrom returns the number of the worker ctx belongs to, starting at
+// 1, if any.
+func workerFrom(ctx context.Context) (int, bool) {
+	id, ok := ctx.Value(workerKey{}).(int)
+	return id, ok
+}
+
+// planIntervals splits root into equal intervals of about limit of total
+// products each, at least one unless total is zero. Each bound is computed
+// from root rather than by adding up widths, which would drift in float32,
+// and the last one is root's own.
+func planIntervals(total, limit int, root Interval) []Interval {
+	nIntervals := total / limit
+	if total > 0 {
+		nIntervals = max(nIntervals, 1)
+	}
+	bound := func(i int) float32 {
+		if i == nIntervals {
+			return root[1]
+		}
+		return root[0] + (root[1]-root[0])*float32(i)/float32(nIntervals)
+	}
+
+	plan := make([]Interval, 0, nIntervals)
+	for i := 0; i < nIntervals; i++ {
+		plan = append(plan, Interval{bound(i), bound(i + 1)})
+	}
+	return plan
+}
+
+func (s *Scraper) initialReq(ctx context.Context) (*Response, error) {
+	interval := s.cfg.priceRange()
+
+	var err error
+	for nRetry := 0; nRetry <= maxRetries; nRetry++ {
+		if nRetry > 0 && s.cfg.Backoff != nil {
+			if err := sleep(ctx, s.cfg.Clock, s.cfg.Backoff.Next(nRetry)); err != nil {
+				return nil, err
+			}
+		}
+		if !s.acquire(ctx) {
+			return nil, errBudgetExhausted
+		}
+		var res *Response
+		res, err = s.request(ctx, interval, pageRef{}, nil)
+		if err == nil {
+			return res, nil
+		}
+		if !s.retryable(err) {
+			break
+		}
+	}
+
+	s.deadLetter(interval, err)
+	return nil, err
+}
+
+// fail gives up on an interval after its attempt nRetry failed with err.
+func (s *Scraper) fail(interval Interval, nRetry int, err error) {
+	s.deadLetter(interval, err)
+	s.tree.finish(interval, nodeFailed, 0)
+	s.emit(IntervalFailed{Interval: interval, Err: err})
+	f := FailedInterval{Interval: interval, Err: err, Attempts: nRetry + 1}
+	if s.failed != nil {
+		s.failed <- f
+	}
+	s.eChan <- f
+}
+
+// requeue schedules another attempt at an interval whose attempt nRetry
+// failed with err, after the Config.Backoff delay.
+func (s *Scraper) requeue(ctx context.Context, interval Interval, nRetry int, err error) {
+	s.emit(IntervalRetried{Interval: interval, Attempt: nRetry + 1, Err: err})
+	s.retries.Add(1)
+	s.wg.Add(1)
+	info := IntervalInfo{interval: interval, nRetry: nRetry + 1}
+	push := s.queue.push
+	if s.retryLane != nil {
+		push = s.retryLane.push
+	}
+	var d time.Duration
+	if s.cfg.Backoff != nil {
+		d = s.cfg.Backoff.Next(info.nRetry)
+	}
+	if d <= 0 {
+		push(info)
+		return
+	}
+	s.spawn(func() {
+		// Queued even if the run is cancelled meanwhile, for the worker
+		// to account for it.
+		sleep(ctx, s.cfg.Clock, d)
+		push(info)
+	})
+}
+
+// safeReq is recursiveReq, turning a panic into a failed attempt at the
+// interval so that neither the worker nor the run die with it.
+func (s *Scraper) safeReq(ctx context.Context, info IntervalInfo) (requested bool) {
+	// Keeps the run open until the interval is requeued; recursiveReq's
+	// own wg.Done has already run by the time we recover.
+	s.wg.Add(1)
+	defer s.wg.Done()
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		s.panics.Add(1)
+		worker, _ := workerFrom(ctx)
+		s.cfg.Logger.Error("worker panicked", "worker", worker, "interval", info.interval, "attempt", info.nRetry,
+			"panic", r, "stack", string(debug.Stack()))
+		requested = true
+		err := fmt.Errorf("panic: %v", r)
+		if info.nRetry >= maxRetries {
+			s.fail(info.interval, info.nRetry, err)
+			return
+		}
+		s.requeue(ctx, info.interval, info.nRetry, err)
+	}()
+	return s.recursiveReq(ctx, info)
+}
+
+// recursiveReq processes one interval and reports whether it made a request.
+func (s *Scraper) recursiveReq(ctx context.Context, intervalInfo IntervalInfo) (requested bool) {
+	defer s.wg.Done()
+	defer s.done.Add(1)
+
+	interval := intervalInfo.interval
+	nRetry := intervalInfo.nRetry
+
+	retry := func(err error) {
+		if revokedCredentials(err) {
+			s.fatal(fmt.Errorf("requesting %v: %w", interval, err))
+			s.addUncovered(interval)
+			s.tree.finish(interval, nodeUncovered, 0)
+			return
+		}
+		// Spent resending with the next key.
+		if errors.Is(err, errBudgetExhausted) {
+			s.addUncovered(interval)
+			s.tree.finish(interval, nodeUncovered, 0)
+			return
+		}
+		if nRetry == maxRetries || !s.retryable(err) {
+			s.fail(interval, nRetry, err)
+			return
+		}
+		s.requeue(ctx, interval, nRetry, err)
+	}
+
+	// Resumed plans, retries and splits can overlap what has already been
+	// fetched, or is being: only request the rest, queueing any extra
+	// pieces.
+	rest, release := s.covered.claim(ctx, interval)
+	defer release()
+	if len(rest) == 0 {
+		s.skippedCovered.Add(1)
+		return false
+	}
+	if rest = s.trimCancelled(interval, rest); len(rest) == 0 {
+		return false
+	}
+	if intervalKey(rest[0], s.cfg.PricePrecision) != intervalKey(interval, s.cfg.PricePrecision) {
+		interval = rest[0]
+		s.wg.Add(len(rest) - 1)
+		for _, r := range rest[1:] {
+			s.queue.push(IntervalInfo{interval: r, nRetry: nRetry})
+		}
+	}
+
+	// Once the budget is spent or the run is cancelled the queue drains
+	// without requesting, so whatever is left ends up in the uncovered list.
+	if ctx.Err() != nil || !s.acquire(ctx) {
+		s.addUncovered(interval)
+		s.tree.finish(interval, nodeUncovered, 0)
+		return false
+	}
+	requested = true
+
+	s.emit(IntervalStarted{Interval: interval, Attempt: nRetry})
+	start := s.cfg.Clock.Now()
+	res, err := s.request(ctx, interval, pageRef{}, nil)
+	s.tree.attempt(interval, s.cfg.Clock.Now().Sub(start))
+	if err != nil {
+		if ctx.Err() != nil {
+			s.addUncovered(interval)
+			s.tree.finish(interval, nodeUncovered, 0)
+			return
+		}
+		retry(err)
+		return
+	}
+	if s.skipCancelled(interval) {
+		return
+	}
+
+	if s.cfg.RetryInvalidPrices && nRetry < maxRetries && hasInvalidPrice(res.Products) {
+		retry(errInvalidPrices)
+		return
+	}
+
+	// The products are what we actually got, so they win over a count that
+	// disagrees. A count over the limit still means the API cut the
+	// interval short, however many products came back.
+	if res.Count != len(res.Products) {
+		s.countMismatches.Add(1)
+		s.cfg.Logger.Warn("count doesn't match products", "interval", interval, "count", res.Count, "products", len(res.Products))
+	}
+	if res.Count > apiLimit {
+		s.overLimitCounts.Add(1)
+	}
+
+	dif := (interval[1] - interval[0]) * s.cfg.SplitRatio
+	low := Interval{interval[0], interval[0] + dif}
+	high := Interval{interval[0] + dif, interval[1]}
+	p := s.cfg.PricePrecision
+	narrowest := sameBound(low[0], low[1], p) || sameBound(high[0], high[1], p)
+
+	// Splitting until complete trusts nothing but full pages: every
+	// interval is split down to a leaf, and leaves are paged through.
+	full := shouldSplit(res, s.cfg) || s.cfg.SplitUntilComplete
+	leaf := width(interval) < s.cfg.PaginateBelow || s.cfg.SplitUntilComplete && narrowest
+
+	if s.cfg.NoSplit {
+		if full || res.NextCursor != "" {
+			s.mu.Lock()
+			s.partial = append(s.partial, interval)
+			s.mu.Unlock()
+		}
+		s.complete(interval, res.Products)
+		return
+	}
+
+	// A paged interval within the split limit is cheaper to page through
+	// than to split, however many pages the API cuts it into.
+	if res.NextCursor != "" && !s.cfg.SplitUntilComplete &&
+		len(res.Products) < s.cfg.pageLimit() && res.Count <= apiLimit && res.Total <= apiLimit {
+		res.Products, err = s.followCursor(ctx, interval, res)
+		if err != nil {
+			if errors.Is(err, errIntervalCancelled) && s.skipCancelled(interval) {
+				return
+			}
+			if ctx.Err() != nil || errors.Is(err, errBudgetExhausted) {
+				s.addUncovered(interval)
+				s.tree.finish(interval, nodeUncovered, 0)
+				return
+			}
+			retry(err)
+			return
+		}
+		full = false
+	}
+
+	if full && leaf {
+		res.Products, err = s.paginate(ctx, interval, res.Products)
+		if err != nil {
+			if errors.Is(err, errIntervalCancelled) && s.skipCancelled(interval) {
+				return
+			}
+			if ctx.Err() != nil || errors.Is(err, errBudgetExhausted) {
+				s.addUncovered(interval)
+				s.tree.finish(interval, nodeUncovered, 0)
+				return
+			}
+			retry(err)
+			return
+		}
+		full = false
+	}
+
+	// Most intervals fit under the limit in one request. Products are sent
+	// inline: the collector drains pChan independently, and Run only closes
+	// it once every worker has returned.
+	if !full {
+		s.complete(interval, res.Products)
+		return
+	}
+
+	if narrowest {
+		// Down to the price precision, splitting can only go on by ID.
+		if s.cfg.MinIDParam == "" {
+			retry(fmt.Errorf("%w: %v", errUnsplittable, interval))
+			return
+		}
+		res.Products, err = s.splitByID(ctx, interval, s.cfg.idRange())
+		if err != nil {
+			if errors.Is(err, errIntervalCancelled) && s.skipCancelled(interval) {
+				return
+			}
+			if ctx.Err() != nil || errors.Is(err, errBudgetExhausted) {
+				s.addUncovered(interval)
+				s.tree.finish(interval, nodeUncovered, 0)
+				return
+			}
+			retry(err)
+			return
+		}
+		s.complete(interval, res.Products)
+		return
+	}
+
+	s.wg.Add(2)
+	s.tree.split(interval, len(res.Products), [2]Interval{low, high})
+	s.emit(IntervalSplit{Interval: interval, Children: [2]Interval{low, high}})
+	s.queue.push(IntervalInfo{interval: low, nRetry: 0})
+	s.queue.push(IntervalInfo{interval: high, nRetry: 0})
+	return
+}
+
+// complete hands over all the products of interval.
+func (s *Scraper) complete(interval Interval, products []Product) {
+	// The collector dedups across responses too, but repeats within one
+	// are a server bug worth counting.
+	if unique, dups := uniqueProducts(products); dups > 0 {
+		s.responseDups.Add(int64(dups))
+		s.cfg.Logger.Warn("duplicate products in response", "interval", interval, "duplicates", dups)
+		products = unique
+	}
+	products = s.dropExcluded(products)
+	products = s.dropAboveMaxPrice(interval, products)
+	s.recordSpotIDs(products)
+	for _, p := range products {
+		if p.Price == 0 {
+			s.zeroPrices.Add(1)
+		}
+		if p.Name == "" {
+			s.emptyNames.Add(1)
+		}
+	}
+	if len(products) > 0 {
+		s.pChan <- foundProducts{products: products, interval: interval}
+	}
+	s.products.Add(int64(len(products)))
+	s.covered.add(interval)
+	s.tree.finish(interval, nodeCompleted, len(products))
+	s.emit(IntervalCompleted{Interval: interval, N: len(products)})
+}
+
+// splitByID bisects the IDs of an interval too narrow to split by price
+// until each part fits in a response, and returns all of their products.
+func (s *Scraper) splitByID(ctx context.Context, interval Interval, ids [2]int) ([]Product, error) {
+	if s.isCancelled(interval) {
+		return nil, errIntervalCancelled
+	}
+	if !s.acquire(ctx) {
+		return nil, errBudgetExhausted
+	}
+	start := s.cfg.Clock.Now()
+	res, err := s.request(ctx, interval, pageRef{}, &ids)
+	s.tree.attempt(interval, s.cfg.Clock.Now().Sub(start))
+	if err != nil {
+		return nil, err
+	}
+	if !shouldSplit(res, s.cfg) {
+		return res.Products, nil
+	}
+	if ids[0] == ids[1] {
+		return nil, fmt.Errorf("%w: %v, id %d", errUnsplittable, interval, ids[0])
+	}
+
+	mid := ids[0] + (ids[1]-ids[0])/2
+	low, err := s.splitByID(ctx, interval, [2]int{ids[0], mid})
+	if err != nil {
+		return nil, err
+	}
+	high, err := s.splitByID(ctx, interval, [2]int{mid + 1, ids[1]})
+	if err != nil {
+		return nil, err
+	}
+	return append(low, high...), nil
+}
+
+// followCursor pages through an interval with the cursors the API
+// returns, given its first page, and returns all of its products.
+func (s *Scraper) followCursor(ctx context.Context, interval Interval, first *Response) ([]Product, error) {
+	products := first.Products
+	seen := map[string]struct{}{}
+	for cursor := first.NextCursor; cursor != ""; {
+		if _, ok := seen[cursor]; ok {
+			return nil, errCursorLoop
+		}
+		seen[cursor] = struct{}{}
+		if s.isCancelled(interval) {
+			return nil, errIntervalCancelled
+		}
+		if !s.acquire(ctx) {
+			return nil, errBudgetExhausted
+		}
+		start := s.cfg.Clock.Now()
+		res, err := s.request(ctx, interval, pageRef{cursor: cursor}, nil)
+		s.tree.attempt(interval, s.cfg.Clock.Now().Sub(start))
+		if err != nil {
+			return nil, err
+		}
+		s.pages.Add(1)
+		products = append(products, res.Products...)
+		cursor = res.NextCursor
+	}
+	return products, nil
+}
+
+// shouldSplit tells whether res may be missing products of its interval,
+// which then has to be split or paged through. A response at the limit
+// may have been cut exactly there, so only one under it is accepted:
+//
+//   - fewer products than the limit, and a count that doesn't claim
+//     more, are the whole interval;
+//   - as many products as the limit (apiLimit, or apiLimit-1 with
+//     Config.CappedBelowLimit) or more may not be;
+//   - nor may any response whose count exceeds apiLimit, however many
+//     products came back.
+//
+// The total isn't looked at: some APIs report that of the whole catalog.
+func shouldSplit(res *Response, cfg *Config) bool {
+	return len(res.Products) >= cfg.pageLimit() || res.Count > apiLimit
+}
+
+// pageLimit is the most products a response can hold.
+func (cfg *Config) pageLimit() int {
+	if cfg.CappedBelowLimit {
+		return apiLimit - 1
+	}
+	return apiLimit
+}
+
+// paginate pages through an interval too narrow to be worth splitting,
+// given the products of its first page, and returns all of them.
+func (s *Scraper) paginate(ctx context.Context, interval Interval, products []Product) ([]Product, error) {
+	for page := products; len(page) >= s.cfg.pageLimit(); {
+		if s.isCancelled(interval) {
+			return nil, errIntervalCancelled
+		}
+		if !s.acquire(ctx) {
+			return nil, errBudgetExhausted
+		}
+		start := s.cfg.Clock.Now()
+		res, err := s.request(ctx, interval, pageRef{offset: len(products)}, nil)
+		s.tree.attempt(interval, s.cfg.Clock.Now().Sub(start))
+		if err != nil {
+			return nil, err
+		}
+		s.pages.Add(1)
+		page = res.Products
+		if len(page) > 0 && page[0].ID == products[0].ID {
+			return nil, errNoPagination
+		}
+		products = append(products, page...)
+	}
+	return products, nil
+}
+
+// pumpRetries moves retries to the main queue no faster than the retry
+// lane's limiter allows, so failing intervals can't starve fresh work of
+// the main rate. They are passed on at once when ctx is done, to drain.
+func (s *Scraper) pumpRetries(ctx context.Context, limiter RateLimiter) {
+	for {
+		info, ok := s.retryLane.pop()
+		if !ok {
+			return
+		}
+		start := s.cfg.Clock.Now()
+		limiter.Wait(ctx)
+		s.retryWaited.Add(int64(s.cfg.Clock.Now().Sub(start)))
+		s.queue.push(info)
+	}
+}
+
+// spawn runs f in a goroutine that Run waits for before returning.
+func (s *Scraper) spawn(f func()) {
+	s.fetch.Go(func() error {
+		f()
+		return nil
+	})
+}
+
+// fatal aborts the run with err as its cause, unless it was already
+// aborted. Run returns the first such error.
+func (s *Scraper) fatal(err error) {
+	if s.fetch.fail(err) {
+		s.cfg.Logger.Error("aborting run", "err", err)
+	}
+}
+
+func (s *Scraper) addUncovered(interval Interval) {
+	s.mu.Lock()
+	s.uncovered = append(s.uncovered, interval)
+	s.mu.Unlock()
+}
+
+func (s *Scraper) worker(ctx context.Context, iChan <-chan IntervalInfo, rng *rand.Rand) {
+	defer s.workers.Add(-1)
+	for {
+		select {
+		case <-s.retire:
+			return
+		case intInfo, ok := <-iChan:
+			if !ok {
+				return
+			}
+			if s.safeReq(ctx, intInfo) {
+				s.politenessSleep(ctx, rng)
+			}
+		}
+	}
+}
+
+func (s *Scraper) politenessSleep(ctx context.Context, rng *rand.Rand) {
+	d := s.cfg.PolitenessDelay
+	if j := int64(s.cfg.PolitenessJitter); j > 0 {
+		d += time.Duration(rng.Int63n(2*j+1) - j)
+	}
+	if d <= 0 {
+		return
+	}
+
+	start := s.cfg.Clock.Now()
+	sleep(ctx, s.cfg.Clock, d)
+	s.slept.Add(int64(s.cfg.Clock.Now().Sub(start)))
+}
@@ -0,0 +1,1086 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"maps"
+	"math"
+	"math/rand"
+	"net/http"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testConfig is a quiet config scraping client, usually a Catalog.
+func testConfig(client Doer) *Config {
+	return &Config{
+		Client: client,
+		Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		RPS:    1e6,
+	}
+}
+
+// testScraper makes a scraper of cfg against a made-up host.
+func testScraper(t testing.TB, cfg *Config) *Scraper {
+	t.Helper()
+	s, err := NewScraper(cfg, WithBaseURL("http://shop.test/products"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestRunCatalog(t *testing.T) {
+	catalog := testCatalog(5000)
+	cfg := testConfig(catalog)
+	cfg.MaxWorkers = 4
+	res, err := testScraper(t, cfg).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Complete || len(res.Products) != catalog.Valid() {
+		t.Errorf("complete %v with %d products, want %d", res.Complete, len(res.Products), catalog.Valid())
+	}
+}
+
+func TestPlanIntervals(t *testing.T) {
+	for _, tc := range []struct {
+		total, limit int
+		root         Interval
+	}{
+		{0, 1000, Interval{0, maxPrice}},
+		{1, 1000, Interval{0, maxPrice}},
+		{999, 1000, Interval{0, maxPrice}},
+		{1000, 1000, Interval{0, maxPrice}},
+		{1001, 1000, Interval{0, maxPrice}},
+		{2500, 1000, Interval{0, maxPrice}},
+		{1_000_000, 1000, Interval{0, maxPrice}},
+		{70_000, 1000, Interval{0.01, 99_999.99}},
+		{3_000_000, 7, Interval{12.34, 56.78}},
+	} {
+		plan := planIntervals(tc.total, tc.limit, tc.root)
+		if want := tc.total / tc.limit; tc.total > 0 && len(plan) != max(want, 1) {
+			t.Errorf("%d/%d: %d intervals, want %d", tc.total, tc.limit, len(plan), max(want, 1))
+		}
+		if len(plan) == 0 {
+			continue
+		}
+		if plan[0][0] != tc.root[0] || plan[len(plan)-1][1] != tc.root[1] {
+			t.Errorf("%d/%d: plan spans %v to %v, want %v", tc.total, tc.limit, plan[0][0], plan[len(plan)-1][1], tc.root)
+		}
+		for i := 1; i < len(plan); i++ {
+			if plan[i][0] != plan[i-1][1] {
+				t.Fatalf("%d/%d: gap or overlap between %v and %v", tc.total, tc.limit, plan[i-1], plan[i])
+			}
+		}
+		// Equal widths, but for float32 rounding of either bound.
+		want := float64(width(tc.root)) / float64(len(plan))
+		ulp := float64(math.Nextafter32(tc.root[1], float32(math.Inf(1))) - tc.root[1])
+		for _, i := range plan {
+			if math.Abs(float64(width(i))-want) > want*1e-3+2*ulp {
+				t.Errorf("%d/%d: %v wide %v, want %v", tc.total, tc.limit, i, width(i), want)
+			}
+		}
+	}
+}
+
+func TestShouldSplit(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		n      int // products
+		count  int
+		total  int
+		capped bool
+		want   bool
+	}{
+		{"under the limit", apiLimit - 1, apiLimit - 1, 0, false, false},
+		{"empty", 0, 0, 0, false, false},
+		{"at the limit", apiLimit, apiLimit, 0, false, true},
+		{"count over the limit", apiLimit, apiLimit + 5, 0, false, true},
+		{"count over the limit, fewer products", 10, apiLimit + 1, 0, false, true},
+		{"total over the products", 10, 10, 11, false, false},
+		{"total of the whole catalog", 10, 10, 5000, false, false},
+		{"total matching the products", 10, 10, 10, false, false},
+		{"capped, under the cap", apiLimit - 2, apiLimit - 2, 0, true, false},
+		{"capped, at the cap", apiLimit - 1, apiLimit - 1, 0, true, true},
+		{"capped, count at the limit", apiLimit - 1, apiLimit, 0, true, true},
+	} {
+		res := &Response{Count: tc.count, Total: tc.total, Products: make([]Product, tc.n)}
+		cfg := &Config{CappedBelowLimit: tc.capped}
+		if got := shouldSplit(res, cfg); got != tc.want {
+			t.Errorf("%s: split %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+// testCatalog is a random catalog of n products, served to the default
+// params.
+func testCatalog(n int) *Catalog {
+	return RandomCatalog(n, 1, (&Config{}).catalogParams())
+}
+
+func TestInvalidPrices(t *testing.T) {
+	var products []Product
+	for i := range 300 {
+		p := Product{ID: ProductID(strconv.Itoa(i)), Price: float32(1 + i)}
+		if i%10 == 0 {
+			p.Price = 0
+		}
+		products = append(products, p)
+	}
+	for _, retry := range []bool{false, true} {
+		cfg := testConfig(NewCatalog(products, CatalogParams{}))
+		cfg.RetryInvalidPrices = retry
+		res, err := testScraper(t, cfg).Run(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(res.Products) != 270 || len(res.InvalidPrices) != 30 {
+			t.Errorf("retry %v: %d products and %d with an invalid price, want 270 and 30", retry, len(res.Products), len(res.InvalidPrices))
+		}
+		for _, p := range res.Products {
+			if !validPrice(p) {
+				t.Errorf("retry %v: product %s with price %v collected", retry, p.ID, p.Price)
+			}
+		}
+		for _, p := range res.InvalidPrices {
+			if validPrice(p) {
+				t.Errorf("retry %v: product %s with price %v reported invalid", retry, p.ID, p.Price)
+			}
+		}
+	}
+}
+
+func TestByInterval(t *testing.T) {
+	cfg := testConfig(skewedCatalog(20_000))
+	cfg.MaxWorkers = 4
+	res, err := testScraper(t, cfg).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	under := map[ProductID]Interval{}
+	for i, products := range res.ByInterval {
+		for _, p := range products {
+			if prev, ok := under[p.ID]; ok {
+				t.Errorf("product %s under %v and %v", p.ID, prev, i)
+			}
+			under[p.ID] = i
+			if p.Price < i[0] || p.Price > i[1] {
+				t.Errorf("product %s at %v, under %v", p.ID, p.Price, i)
+			}
+		}
+	}
+	for _, p := range res.Products {
+		if _, ok := under[p.ID]; !ok {
+			t.Errorf("product %s under no interval", p.ID)
+		}
+	}
+	if len(under) != len(res.Products) {
+		t.Errorf("%d products grouped by interval, %d collected", len(under), len(res.Products))
+	}
+}
+
+// skewedCatalog is a catalog of n products, nine in ten priced under 100.
+func skewedCatalog(n int) *Catalog {
+	rng := rand.New(rand.NewSource(1))
+	products := make([]Product, n)
+	for i := range products {
+		price := rng.Float64() * float64(maxPrice)
+		if i%10 != 0 {
+			price = rng.Float64() * 100
+		}
+		id := strconv.Itoa(i + 1)
+		products[i] = Product{ID: ProductID(id), Name: "p" + id, Price: float32(math.Round(price*100)/100 + 0.01)}
+	}
+	return NewCatalog(products, CatalogParams{})
+}
+
+func BenchmarkScrape(b *testing.B) {
+	for _, c := range []struct {
+		name    string
+		catalog *Catalog
+	}{
+		{"small", testCatalog(1000)},
+		{"medium", testCatalog(50_000)},
+		{"skewed", skewedCatalog(50_000)},
+	} {
+		b.Run(c.name, func(b *testing.B) {
+			b.ReportAllocs()
+			var intervals int64
+			var mallocs uint64
+			for range b.N {
+				var before, after runtime.MemStats
+				runtime.ReadMemStats(&before)
+				s := testScraper(b, testConfig(c.catalog))
+				if _, err := s.Run(context.Background()); err != nil {
+					b.Fatal(err)
+				}
+				runtime.ReadMemStats(&after)
+				intervals += s.done.Load()
+				mallocs += after.Mallocs - before.Mallocs
+			}
+			b.ReportMetric(float64(mallocs)/float64(intervals), "allocs/interval")
+		})
+	}
+}
+
+// TestAutoscale runs workers up while the queue is deep, then down while
+// the last interval is slow to answer, which must not be lost.
+func TestAutoscale(t *testing.T) {
+	catalog := testCatalog(20_000)
+	var s *Scraper
+	cfg := testConfig(DoerFunc(func(req *http.Request) (*http.Response, error) {
+		time.Sleep(20 * time.Millisecond)
+		// The last planned interval answers once workers were added, and
+		// then retired for lack of work.
+		if lo, _ := strconv.ParseFloat(req.URL.Query().Get(minPriceParam), 32); lo >= 95_000 {
+			deadline := time.Now().Add(10 * scaleInterval)
+			for s.workers.Load() == 1 && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			for n := s.workers.Load(); s.workers.Load() >= n && time.Now().Before(deadline); {
+				time.Sleep(10 * time.Millisecond)
+			}
+		}
+		return catalog.Do(req)
+	}))
+	cfg.MaxWorkers = 8
+	s = testScraper(t, cfg)
+	res, err := s.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Complete || len(res.Products) != catalog.Valid() {
+		t.Errorf("complete %v with %d products, want %d", res.Complete, len(res.Products), catalog.Valid())
+	}
+	if st := res.Stats; st.ScaleUps == 0 || st.PeakWorkers < 2 || st.ScaleDowns == 0 {
+		t.Errorf("%d scale ups to %d workers, %d scale downs, want some of each", st.ScaleUps, st.PeakWorkers, st.ScaleDowns)
+	}
+}
+
+func TestWorkerRequests(t *testing.T) {
+	catalog := testCatalog(20_000)
+	var mu sync.Mutex
+	seen := map[int]int64{}
+	cfg := testConfig(DoerFunc(func(req *http.Request) (*http.Response, error) {
+		id, _ := workerFrom(req.Context())
+		mu.Lock()
+		seen[id]++
+		mu.Unlock()
+		return catalog.Do(req)
+	}))
+	cfg.MinWorkers = 4
+	cfg.MaxWorkers = 4
+	res, err := testScraper(t, cfg).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sum int64
+	for _, n := range res.Stats.WorkerRequests {
+		sum += n
+	}
+	if sum != res.Stats.Requests || sum != catalog.Requests() {
+		t.Errorf("worker requests sum up to %d, %d requests made, %d received", sum, res.Stats.Requests, catalog.Requests())
+	}
+	if !maps.Equal(res.Stats.WorkerRequests, seen) {
+		t.Errorf("worker requests %v, the requests came from %v", res.Stats.WorkerRequests, seen)
+	}
+	for id := range 5 {
+		if id > 0 && res.Stats.WorkerRequests[id] == 0 {
+			t.Errorf("worker %d made no request", id)
+		}
+	}
+}
+
+func TestPriceRange(t *testing.T) {
+	catalog := testCatalog(20_000)
+	band := Interval{1000, 2500}
+	cfg := testConfig(catalog)
+	cfg.PriceRange = &band
+	res, err := testScraper(t, cfg).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := 0
+	for _, p := range catalog.products {
+		if validPrice(p) && p.Price >= band[0] && p.Price <= band[1] {
+			want++
+		}
+	}
+	if !res.Complete || len(res.Products) != want {
+		t.Errorf("complete %v with %d products, want %d", res.Complete, len(res.Products), want)
+	}
+	for _, p := range res.Products {
+		if p.Price < band[0] || p.Price > band[1] {
+			t.Errorf("product %s at %v, outside %v", p.ID, p.Price, band)
+		}
+	}
+}
+
+// TestSalePriceAxis splits on the sale price of products whose list price
+// is unrelated to it: both are still captured.
+func TestSalePriceAxis(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	want := make(map[ProductID]Product)
+	// The catalog serves products by sale price, as Price.
+	var bySale []Product
+	for i := range 5000 {
+		p := Product{
+			ID:        ProductID(strconv.Itoa(i)),
+			Price:     float32(1+rng.Intn(int(maxPrice)*100-1)) / 100,
+			SalePrice: float32(1+rng.Intn(int(maxPrice)*100-1)) / 100,
+		}
+		want[p.ID] = p
+		bySale = append(bySale, Product{ID: p.ID, Price: p.SalePrice, SalePrice: p.Price})
+	}
+	params := CatalogParams{MinPrice: "minSalePrice", MaxPrice: "maxSalePrice", Offset: offsetParam}
+	catalog := NewCatalog(bySale, params)
+
+	cfg := testConfig(DoerFunc(func(req *http.Request) (*http.Response, error) {
+		q := req.URL.Query()
+		if q.Has(minPriceParam) || q.Has(maxPriceParam) || !q.Has(params.MinPrice) {
+			t.Errorf("request %s, want a sale price range only", req.URL.RawQuery)
+		}
+		resp, err := catalog.Do(req)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			return resp, err
+		}
+		var res Response
+		if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+			return nil, err
+		}
+		for i, p := range res.Products {
+			res.Products[i].Price, res.Products[i].SalePrice = p.SalePrice, p.Price
+		}
+		body, err := json.Marshal(res)
+		if err != nil {
+			return nil, err
+		}
+		return simulatedResponse(req, http.StatusOK, body), nil
+	}))
+	cfg.MinPriceParam = params.MinPrice
+	cfg.MaxPriceParam = params.MaxPrice
+	res, err := testScraper(t, cfg).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Complete || len(res.Products) != len(want) {
+		t.Errorf("complete %v with %d products, want %d", res.Complete, len(res.Products), len(want))
+	}
+	for _, p := range res.Products {
+		if p != want[p.ID] {
+			t.Errorf("product %+v, want %+v", p, want[p.ID])
+		}
+	}
+}
+
+func TestPreFanoutDelay(t *testing.T) {
+	catalog := testCatalog(5000)
+	var planned time.Time
+	var fanout atomic.Int64
+	cfg := testConfig(DoerFunc(func(req *http.Request) (*http.Response, error) {
+		if !planned.IsZero() {
+			fanout.CompareAndSwap(0, int64(time.Since(planned)))
+		}
+		return catalog.Do(req)
+	}))
+	cfg.PreFanoutDelay = 200 * time.Millisecond
+	cfg.OnPlan = func([]Interval) { planned = time.Now() }
+	if _, err := testScraper(t, cfg).Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Duration(fanout.Load()); d < cfg.PreFanoutDelay {
+		t.Errorf("first interval requested %v after planning, want at least %v", d, cfg.PreFanoutDelay)
+	}
+
+	// Cancelled during the delay, the run stops without requesting any.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	planned = time.Time{}
+	fanout.Store(0)
+	cfg.PreFanoutDelay = time.Hour
+	cfg.OnPlan = func([]Interval) {
+		planned = time.Now()
+		time.AfterFunc(20*time.Millisecond, cancel)
+	}
+	start := time.Now()
+	res, _ := testScraper(t, cfg).Run(ctx)
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("cancelled run returned after %v", d)
+	}
+	if res == nil {
+		t.Fatal("no result")
+	}
+	if res.Complete || len(res.Products) > 0 {
+		t.Errorf("cancelled during the delay: complete %v with %d products", res.Complete, len(res.Products))
+	}
+	if fanout.Load() != 0 {
+		t.Error("interval requested after cancelling")
+	}
+}
+
+func TestTotalChanges(t *testing.T) {
+	before := testCatalog(5000)
+	// A hundred products go out of stock right after the initial probe.
+	after := NewCatalog(before.products[100:], CatalogParams{})
+	var sent atomic.Int64
+	cfg := testConfig(DoerFunc(func(req *http.Request) (*http.Response, error) {
+		if sent.Add(1) == 1 {
+			return before.Do(req)
+		}
+		return after.Do(req)
+	}))
+	res, err := testScraper(t, cfg).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.InitialTotal != before.Len() || res.FinalTotal != after.Len() {
+		t.Errorf("totals %d then %d, want %d then %d", res.InitialTotal, res.FinalTotal, before.Len(), after.Len())
+	}
+	if !res.Complete || res.Shortfall != 0 || len(res.Products) != after.Valid() {
+		t.Errorf("complete %v with %d products, shortfall %d, want %d products", res.Complete, len(res.Products), res.Shortfall, after.Valid())
+	}
+}
+
+// miscounted serves catalog with the count of each response replaced by
+// count(interval, products).
+func miscounted(catalog *Catalog, count func(i Interval, n int) int) Doer {
+	return DoerFunc(func(req *http.Request) (*http.Response, error) {
+		resp, err := catalog.Do(req)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			return resp, err
+		}
+		var res Response
+		if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+			return nil, err
+		}
+		q := req.URL.Query()
+		lo, _ := strconv.ParseFloat(q.Get(minPriceParam), 32)
+		hi, _ := strconv.ParseFloat(q.Get(maxPriceParam), 32)
+		res.Count = count(Interval{float32(lo), float32(hi)}, len(res.Products))
+		body, err := json.Marshal(res)
+		if err != nil {
+			return nil, err
+		}
+		return simulatedResponse(req, http.StatusOK, body), nil
+	})
+}
+
+func TestCountAnomalies(t *testing.T) {
+	catalog := testCatalog(20_000)
+	honest := testScraper(t, testConfig(catalog))
+	if _, err := honest.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// A count short of the products is trusted less than they are: the
+	// same intervals are accepted.
+	cfg := testConfig(miscounted(catalog, func(_ Interval, n int) int { return max(n-3, 0) }))
+	s := testScraper(t, cfg)
+	res, err := s.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Complete || len(res.Products) != catalog.Valid() {
+		t.Errorf("count short: complete %v with %d products, want %d", res.Complete, len(res.Products), catalog.Valid())
+	}
+	if res.Stats.CountMismatches == 0 || res.Stats.OverLimitCounts != 0 {
+		t.Errorf("count short: %d mismatches, %d over the limit", res.Stats.CountMismatches, res.Stats.OverLimitCounts)
+	}
+	if s.done.Load() != honest.done.Load() {
+		t.Errorf("count short: %d intervals done, %d with honest counts", s.done.Load(), honest.done.Load())
+	}
+
+	// A count over the limit splits the interval, whatever came back.
+	const wide = 2000
+	cfg = testConfig(miscounted(catalog, func(i Interval, n int) int {
+		if width(i) >= wide {
+			return apiLimit + 3
+		}
+		return n
+	}))
+	res, err = testScraper(t, cfg).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Complete || len(res.Products) != catalog.Valid() {
+		t.Errorf("count over the limit: complete %v with %d products, want %d", res.Complete, len(res.Products), catalog.Valid())
+	}
+	if res.Stats.OverLimitCounts == 0 {
+		t.Error("count over the limit: none counted")
+	}
+	for i := range res.ByInterval {
+		if width(i) >= wide {
+			t.Errorf("count over the limit: %v accepted", i)
+		}
+	}
+}
+
+// TestSplitUntilComplete scrapes an API cutting the responses of wide
+// intervals short, with a count and total to match: only ignoring them
+// collects everything.
+func TestSplitUntilComplete(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	products := make([]Product, 3000)
+	for i := range products {
+		products[i] = Product{ID: ProductID(strconv.Itoa(i + 1)), Price: float32(1+rng.Intn(9999)) / 100}
+	}
+	catalog := NewCatalog(products, (&Config{}).catalogParams())
+	client := DoerFunc(func(req *http.Request) (*http.Response, error) {
+		resp, err := catalog.Do(req)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			return resp, err
+		}
+		var res Response
+		if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+			return nil, err
+		}
+		q := req.URL.Query()
+		lo, _ := strconv.ParseFloat(q.Get(minPriceParam), 32)
+		hi, _ := strconv.ParseFloat(q.Get(maxPriceParam), 32)
+		if hi-lo >= 1 {
+			res.Products = res.Products[:min(len(res.Products), 500)]
+			res.Count, res.Total = len(res.Products), len(res.Products)
+		}
+		body, err := json.Marshal(res)
+		if err != nil {
+			return nil, err
+		}
+		return simulatedResponse(req, http.StatusOK, body), nil
+	})
+
+	for _, until := range []bool{false, true} {
+		cfg := testConfig(client)
+		cfg.Intervals = []Interval{{0, 100}}
+		cfg.PaginateBelow = 1
+		cfg.SplitUntilComplete = until
+		res, err := testScraper(t, cfg).Run(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := len(res.Products); until && (!res.Complete || got != len(products)) {
+			t.Errorf("split until complete: complete %v with %d products, want %d", res.Complete, got, len(products))
+		} else if !until && got >= len(products) {
+			t.Errorf("trusting counts: %d products, want some missed", got)
+		}
+		for i := range res.ByInterval {
+			if until && width(i) >= 1 {
+				t.Errorf("split until complete: %v accepted", i)
+			}
+		}
+	}
+}
+
+func TestNoSplit(t *testing.T) {
+	catalog := skewedCatalog(20_000)
+	cfg := testConfig(catalog)
+	cfg.NoSplit = true
+	var plan []Interval
+	cfg.OnPlan = func(p []Interval) { plan = p }
+	res, err := testScraper(t, cfg).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Nine in ten products are under 100, in the first planned interval.
+	if len(res.Partial) != 1 || res.Partial[0] != plan[0] {
+		t.Fatalf("partial %v, want the first of %d planned", res.Partial, len(plan))
+	}
+	if got := len(res.ByInterval[plan[0]]); got != apiLimit {
+		t.Errorf("%d products of %v collected, want its first page", got, plan[0])
+	}
+	if len(res.Products) >= catalog.Valid() || res.Shortfall != 0 {
+		t.Errorf("%d products of %d, shortfall %d, want the partial ones missing and no shortfall", len(res.Products), catalog.Valid(), res.Shortfall)
+	}
+	// The probe and final total, and one request per planned interval.
+	if n := catalog.Requests(); n != int64(len(plan))+2 {
+		t.Errorf("%d requests for %d planned intervals", n, len(plan))
+	}
+}
+
+func TestOnPlan(t *testing.T) {
+	catalog := testCatalog(20_000)
+	var requests atomic.Int64
+	cfg := testConfig(DoerFunc(func(req *http.Request) (*http.Response, error) {
+		requests.Add(1)
+		return catalog.Do(req)
+	}))
+	var plan []Interval
+	var before int64
+	cfg.OnPlan = func(p []Interval) {
+		plan = p
+		before = requests.Load()
+	}
+	if _, err := testScraper(t, cfg).Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if want := catalog.Len() / apiLimit; len(plan) != want {
+		t.Errorf("planned %d intervals, want %d", len(plan), want)
+	}
+	// Only the initial probe came before.
+	if before != 1 {
+		t.Errorf("%d requests before the plan, want 1", before)
+	}
+}
+
+func TestSplitRatio(t *testing.T) {
+	products := make([]Product, apiLimit+500)
+	for i := range products {
+		id := strconv.Itoa(i + 1)
+		products[i] = Product{ID: ProductID(id), Price: float32(i%1000) + 0.5}
+	}
+	cfg := testConfig(NewCatalog(products, CatalogParams{}))
+	cfg.SplitRatio = 0.25
+	cfg.SplitTreeDepth = 1
+	cfg.Intervals = []Interval{{0, 1000}}
+	res, err := testScraper(t, cfg).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Complete || len(res.Products) != len(products) {
+		t.Errorf("complete %v with %d products, want %d", res.Complete, len(res.Products), len(products))
+	}
+	root := res.SplitTree[0]
+	if len(root.Children) != 2 {
+		t.Fatalf("%v split in %d", root.Interval, len(root.Children))
+	}
+	if low, high := root.Children[0].Interval, root.Children[1].Interval; low != (Interval{0, 250}) || high != (Interval{250, 1000}) {
+		t.Errorf("%v split into %v and %v, want a quarter low", root.Interval, low, high)
+	}
+
+	for _, r := range []float32{-0.5, 1, 1.5} {
+		cfg := testConfig(testCatalog(10))
+		cfg.SplitRatio = r
+		if _, err := NewScraper(cfg, WithBaseURL("http://shop.test/products")); err == nil {
+			t.Errorf("split ratio %v accepted", r)
+		}
+	}
+}
+
+// TestSplitMidpoint splits an interval right at a product's price, for an
+// API with an inclusive and one with an exclusive max price: the product
+// is collected once either way.
+func TestSplitMidpoint(t *testing.T) {
+	products := make([]Product, apiLimit+500)
+	for i := range products {
+		products[i] = Product{ID: ProductID(strconv.Itoa(i + 1)), Price: float32(i%1000) + 0.5}
+	}
+	products = append(products, Product{ID: "mid", Price: 500})
+	catalog := NewCatalog(products, CatalogParams{})
+
+	for _, exclusive := range []bool{false, true} {
+		client := Doer(catalog)
+		if !exclusive {
+			client = inclusiveMax(catalog)
+		}
+		cfg := testConfig(client)
+		cfg.MaxPriceExclusive = exclusive
+		cfg.SplitTreeDepth = 1
+		cfg.Intervals = []Interval{{0, 1000}}
+		res, err := testScraper(t, cfg).Run(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		root := res.SplitTree[0]
+		if len(root.Children) != 2 || root.Children[0].Interval != (Interval{0, 500}) {
+			t.Fatalf("exclusive %v: %v split into %v, want at 500", exclusive, root.Interval, root.Children)
+		}
+		mid := 0
+		for _, p := range res.Products {
+			if p.ID == "mid" {
+				mid++
+			}
+		}
+		if !res.Complete || len(res.Products) != len(products) || mid != 1 {
+			t.Errorf("exclusive %v: complete %v with %d products, the one at 500 %d times, want %d products, it once",
+				exclusive, res.Complete, len(res.Products), mid, len(products))
+		}
+	}
+}
+
+// inclusiveMax is catalog with its max price bound inclusive: the catalog
+// leaves out its max price, this one doesn't.
+func inclusiveMax(catalog *Catalog) Doer {
+	return DoerFunc(func(req *http.Request) (*http.Response, error) {
+		q := req.URL.Query()
+		if hi, err := strconv.ParseFloat(q.Get(maxPriceParam), 32); err == nil {
+			next := math.Nextafter32(float32(hi), float32(math.Inf(1)))
+			q.Set(maxPriceParam, strconv.FormatFloat(float64(next), 'f', -1, 32))
+			req = req.Clone(req.Context())
+			req.URL.RawQuery = q.Encode()
+		}
+		return catalog.Do(req)
+	})
+}
+
+// TestMaxPriceProduct scrapes a product priced exactly at maxPrice, with
+// the API's max bound exclusive, inclusive, or detected: it's collected
+// once either way.
+func TestMaxPriceProduct(t *testing.T) {
+	products := make([]Product, 3000)
+	for i := range products {
+		products[i] = Product{ID: ProductID(strconv.Itoa(i + 1)), Price: float32(i+1) * maxPrice / 3001}
+	}
+	products = append(products, Product{ID: "top", Price: maxPrice})
+	catalog := NewCatalog(products, CatalogParams{})
+
+	for _, tc := range []struct {
+		name      string
+		client    Doer
+		exclusive bool
+		detect    bool
+	}{
+		{"exclusive", catalog, true, false},
+		{"inclusive", inclusiveMax(catalog), false, false},
+		{"detected", catalog, false, true},
+	} {
+		cfg := testConfig(tc.client)
+		cfg.MaxPriceExclusive = tc.exclusive
+		cfg.DetectMaxPriceBound = tc.detect
+		res, err := testScraper(t, cfg).Run(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		top := 0
+		for _, p := range res.Products {
+			if p.ID == "top" {
+				top++
+			}
+		}
+		if !res.Complete || len(res.Products) != len(products) || top != 1 {
+			t.Errorf("%s: complete %v with %d products, the one at maxPrice %d times, want %d products, it once",
+				tc.name, res.Complete, len(res.Products), top, len(products))
+		}
+	}
+}
+
+// TestFollowCursor scrapes an API paging with cursors, 300 products a
+// page, an interval of 500 products: its two pages are followed.
+func TestFollowCursor(t *testing.T) {
+	products := make([]Product, 500)
+	for i := range products {
+		products[i] = Product{ID: ProductID(strconv.Itoa(i + 1)), Price: 10 + float32(i%100)/100}
+	}
+	catalog := NewCatalog(products, CatalogParams{})
+	for _, loop := range []bool{false, true} {
+		var cursors []string
+		cfg := testConfig(DoerFunc(func(req *http.Request) (*http.Response, error) {
+			resp, err := catalog.Do(req)
+			if err != nil || resp.StatusCode != http.StatusOK {
+				return resp, err
+			}
+			var res Response
+			if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+				return nil, err
+			}
+			cursor := req.URL.Query().Get(cursorParam)
+			cursors = append(cursors, cursor)
+			from, _ := strconv.Atoi(strings.TrimPrefix(cursor, "from-"))
+			if loop {
+				from = 0
+			}
+			res.Products = res.Products[from:min(from+300, len(res.Products))]
+			res.Count = len(res.Products)
+			if next := from + 300; next < res.Total {
+				res.NextCursor = "from-" + strconv.Itoa(next)
+			}
+			body, err := json.Marshal(res)
+			if err != nil {
+				return nil, err
+			}
+			return simulatedResponse(req, http.StatusOK, body), nil
+		}))
+		cfg.MaxWorkers = 1
+		cfg.Intervals = []Interval{{10, 11}}
+		res, err := testScraper(t, cfg).Run(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if loop {
+			if res.Complete || len(res.Failed) != 1 || !errors.Is(res.Failed[0].Err, errCursorLoop) {
+				t.Errorf("cursor loop: complete %v, failed %v", res.Complete, res.Failed)
+			}
+			continue
+		}
+		if !res.Complete || len(res.Products) != len(products) || res.Stats.Pages != 1 {
+			t.Errorf("complete %v with %d products in %d more pages, want %d in 1", res.Complete, len(res.Products), res.Stats.Pages, len(products))
+		}
+		if !slices.Contains(cursors, "from-300") {
+			t.Errorf("cursors sent %q, want from-300", cursors)
+		}
+	}
+}
+
+func TestPaginateBelow(t *testing.T) {
+	// A cluster of 3500 products within half a unit, over a sparse
+	// catalog.
+	products := testCatalog(2000).products
+	for i := range 3500 {
+		id := "c" + strconv.Itoa(i)
+		products = append(products, Product{ID: ProductID(id), Name: id, Price: 500 + float32(i%50)/100})
+	}
+	var widths []float32
+	var mu sync.Mutex
+	catalog := NewCatalog(products, (&Config{}).catalogParams())
+	cfg := testConfig(DoerFunc(func(req *http.Request) (*http.Response, error) {
+		q := req.URL.Query()
+		lo, _ := strconv.ParseFloat(q.Get(minPriceParam), 32)
+		hi, _ := strconv.ParseFloat(q.Get(maxPriceParam), 32)
+		mu.Lock()
+		widths = append(widths, float32(hi-lo))
+		mu.Unlock()
+		return catalog.Do(req)
+	}))
+	cfg.PaginateBelow = 10
+	res, err := testScraper(t, cfg).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Complete || len(res.Products) != catalog.Valid() {
+		t.Errorf("complete %v with %d products, want %d", res.Complete, len(res.Products), catalog.Valid())
+	}
+	// Splitting stops at the first interval narrower than PaginateBelow,
+	// at least half as wide, which is paged through.
+	for _, w := range widths {
+		if w < cfg.PaginateBelow/2 {
+			t.Fatalf("requested an interval %v wide, splitting past %v", w, cfg.PaginateBelow)
+		}
+	}
+	if res.Stats.Pages < 3 {
+		t.Errorf("%d pages, want the cluster paged through", res.Stats.Pages)
+	}
+}
+
+func TestStatsOnly(t *testing.T) {
+	catalog := skewedCatalog(20_000)
+	cfg := testConfig(catalog)
+	cfg.StatsOnly = true
+	res, err := testScraper(t, cfg).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Products) > 0 || len(res.ByInterval) > 0 {
+		t.Errorf("%d products kept, under %d intervals", len(res.Products), len(res.ByInterval))
+	}
+	sum := 0
+	for i, n := range res.Histogram {
+		want := 0
+		for _, p := range catalog.products {
+			if validPrice(p) && p.Price >= i[0] && p.Price < i[1] {
+				want++
+			}
+		}
+		if n != want {
+			t.Errorf("%d products counted in %v, want %d", n, i, want)
+		}
+		sum += n
+	}
+	if sum != catalog.Valid() || res.Collected != sum {
+		t.Errorf("histogram sums to %d, %d collected, want %d", sum, res.Collected, catalog.Valid())
+	}
+}
+
+func TestResponseDuplicates(t *testing.T) {
+	catalog := testCatalog(3000)
+	var repeated atomic.Int64
+	cfg := testConfig(DoerFunc(func(req *http.Request) (*http.Response, error) {
+		resp, err := catalog.Do(req)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			return resp, err
+		}
+		var res Response
+		if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+			return nil, err
+		}
+		// The first product of each response but full ones, like those
+		// to the probes of the total, is in it twice.
+		if len(res.Products) > 0 && len(res.Products) < apiLimit {
+			res.Products = append(res.Products, res.Products[0])
+			res.Count++
+			repeated.Add(1)
+		}
+		body, err := json.Marshal(res)
+		if err != nil {
+			return nil, err
+		}
+		return simulatedResponse(req, http.StatusOK, body), nil
+	}))
+	res, err := testScraper(t, cfg).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[ProductID]bool{}
+	for _, p := range res.Products {
+		if seen[p.ID] {
+			t.Errorf("product %s collected twice", p.ID)
+		}
+		seen[p.ID] = true
+	}
+	if !res.Complete || len(res.Products) != catalog.Valid() {
+		t.Errorf("complete %v with %d products, want %d", res.Complete, len(res.Products), catalog.Valid())
+	}
+	if got := res.Stats.ResponseDuplicates; got != repeated.Load() {
+		t.Errorf("%d duplicates counted, want %d", got, repeated.Load())
+	}
+}
+
+func TestIDLists(t *testing.T) {
+	catalog := testCatalog(3000)
+	var ids []ProductID
+	for _, p := range catalog.products {
+		if validPrice(p) {
+			ids = append(ids, p.ID)
+		}
+	}
+	// a and b are allowed, b and c denied, d is in neither list.
+	a, b, c, d := ids[0], ids[1], ids[2], ids[3]
+	set := func(ids ...ProductID) map[ProductID]struct{} {
+		m := map[ProductID]struct{}{}
+		for _, id := range ids {
+			m[id] = struct{}{}
+		}
+		return m
+	}
+	for _, tc := range []struct {
+		name        string
+		allow, deny map[ProductID]struct{}
+		kept        map[ProductID]bool
+		n, filtered int // products kept, and left out of the catalog's
+	}{
+		{"allow", set(a, b), nil, map[ProductID]bool{a: true, b: true, c: false, d: false}, 2, catalog.Len() - 2},
+		{"deny", nil, set(b, c), map[ProductID]bool{a: true, b: false, c: false, d: true}, len(ids) - 2, 2},
+		// The allowlist takes precedence, b is kept.
+		{"both", set(a, b), set(b, c), map[ProductID]bool{a: true, b: true, c: false, d: false}, 2, catalog.Len() - 2},
+	} {
+		cfg := testConfig(catalog)
+		cfg.AllowIDs, cfg.DenyIDs = tc.allow, tc.deny
+		res, err := testScraper(t, cfg).Run(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := map[ProductID]bool{}
+		for _, p := range res.Products {
+			got[p.ID] = true
+		}
+		for id, kept := range tc.kept {
+			if got[id] != kept {
+				t.Errorf("%s: product %s kept %v, want %v", tc.name, id, got[id], kept)
+			}
+		}
+		if len(res.Products) != tc.n || res.Stats.FilteredOut != int64(tc.filtered) {
+			t.Errorf("%s: %d products kept, %d filtered out, want %d and %d", tc.name, len(res.Products), res.Stats.FilteredOut, tc.n, tc.filtered)
+		}
+	}
+}
+
+func TestOverProvisionedWarning(t *testing.T) {
+	for _, tc := range []struct {
+		workers int
+		limiter RateLimiter
+		warned  bool
+	}{
+		{overProvisionFactor * tokenBucketSize, nil, false},
+		{50, nil, true},
+		// A custom limiter's burst isn't known.
+		{50, unlimited{}, false},
+	} {
+		cfg := testConfig(testCatalog(100))
+		cfg.MaxWorkers = tc.workers
+		cfg.RateLimiter = tc.limiter
+		res, err := testScraper(t, cfg).Run(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		warned := slices.ContainsFunc(res.Warnings, func(w string) bool { return strings.Contains(w, "most will wait for tokens") })
+		if warned != tc.warned {
+			t.Errorf("%d workers, limiter %T: warned %v, want %v (warnings %q)", tc.workers, tc.limiter, warned, tc.warned, res.Warnings)
+		}
+	}
+}
+
+// byID serves catalog also bounding product IDs, inclusive, by minID and
+// maxID.
+func byID(catalog *Catalog) Doer {
+	return DoerFunc(func(req *http.Request) (*http.Response, error) {
+		q := req.URL.Query()
+		lo, _ := strconv.ParseFloat(q.Get(minPriceParam), 32)
+		hi, _ := strconv.ParseFloat(q.Get(maxPriceParam), 32)
+		ids := [2]int{0, maxID}
+		if q.Has("minID") {
+			ids[0], _ = strconv.Atoi(q.Get("minID"))
+			ids[1], _ = strconv.Atoi(q.Get("maxID"))
+		}
+		res := Response{Products: []Product{}}
+		for _, p := range catalog.products {
+			id, _ := strconv.Atoi(string(p.ID))
+			if float64(p.Price) >= lo && float64(p.Price) < hi && ids[0] <= id && id <= ids[1] {
+				res.Total++
+				if len(res.Products) < apiLimit {
+					res.Products = append(res.Products, p)
+				}
+			}
+		}
+		res.Count = len(res.Products)
+		body, err := json.Marshal(res)
+		if err != nil {
+			return nil, err
+		}
+		return simulatedResponse(req, http.StatusOK, body), nil
+	})
+}
+
+func TestSplitByID(t *testing.T) {
+	// 2000 products at one price, and a few around.
+	var products []Product
+	for i := range 2100 {
+		id := strconv.Itoa(i + 1)
+		price := float32(42)
+		if i >= 2000 {
+			price = float32(i - 1990)
+		}
+		products = append(products, Product{ID: ProductID(id), Name: "p" + id, Price: price})
+	}
+	for _, withIDs := range []bool{true, false} {
+		catalog := NewCatalog(products, CatalogParams{})
+		cfg := testConfig(byID(catalog))
+		if withIDs {
+			cfg.MinIDParam, cfg.MaxIDParam = "minID", "maxID"
+		}
+		res, err := testScraper(t, cfg).Run(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !withIDs {
+			// The price can't be split any further.
+			if res.Complete || len(res.Failed) == 0 {
+				t.Errorf("without splitting by ID: complete %v, %d failed", res.Complete, len(res.Failed))
+			}
+			continue
+		}
+		if !res.Complete || len(res.Products) != len(products) {
+			t.Errorf("complete %v with %d products, want %d", res.Complete, len(res.Products), len(products))
+		}
+	}
+}
+
+func TestEmptyCatalog(t *testing.T) {
+	catalog := NewCatalog(nil, CatalogParams{})
+	res, err := testScraper(t, testConfig(catalog)).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Complete || res.Products == nil || len(res.Products) > 0 || res.InitialTotal != 0 {
+		t.Errorf("complete %v with %v products, total %d, want complete and empty", res.Complete, res.Products, res.InitialTotal)
+	}
+	if n := catalog.Requests(); n != 1 {
+		t.Errorf("%d requests, want the probe only", n)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+)
+
+// scrapeRequest is the optional body of POST /scrape.
+type scrapeRequest struct {
+	MinPrice *float32 `json:"min_price"`
+	MaxPrice *float32 `json:"max_price"`
+}
+
+// scrapeServer runs a scrape per request, see ServeHTTP.
+type scrapeServer struct {
+	cfg  Config
+	opts []Option
+
+	mu      sync.Mutex
+	running int
+	runs    int
+	last    *Stats
+	total   Stats
+}
+
+// ServeHTTP exposes scraping with cfg over HTTP:
+//
+//   - POST /scrape runs a scrape, optionally of the price range in a
+//     {"min_price": ..., "max_price": ...} body, streaming the products
+//     as NDJSON one response at a time. Whether the run completed and its
+//     error, if any, follow in the X-Scrape-Complete and X-Scrape-Error
+//     trailers.
+//   - GET /metrics returns the number of scrapes running and ended, and
+//     the Stats of the last one to end and summed over all, as JSON.
+//
+// Every scrape gets a deep copy of cfg, whose Sink is replaced by the
+// response.
+func ServeHTTP(cfg Config, opts ...Option) http.Handler {
+	srv := &scrapeServer{cfg: cfg.clone(), opts: opts}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /scrape", srv.scrape)
+	mux.HandleFunc("GET /metrics", srv.metrics)
+	return mux
+}
+
+func (srv *scrapeServer) scrape(w http.ResponseWriter, r *http.Request) {
+	var body scrapeRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "invalid body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	cfg := srv.cfg.clone()
+	if body.MinPrice != nil || body.MaxPrice != nil {
+		r := cfg.priceRange()
+		if body.MinPrice != nil {
+			r[0] = *body.MinPrice
+		}
+		if body.MaxPrice != nil {
+			r[1] = *body.MaxPrice
+		}
+		cfg.PriceRange = &r
+	}
+	// The products go to the client only.
+	cfg.StatsOnly = true
+	cfg.Sink = &streamSink{ProductSink: NewNDJSONSink(w), rc: http.NewResponseController(w)}
+	s, err := NewScraper(&cfg, srv.opts...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	srv.mu.Lock()
+	srv.running++
+	srv.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Trailer", "X-Scrape-Complete, X-Scrape-Error")
+	res, err := s.Run(r.Context())
+
+	srv.mu.Lock()
+	srv.running--
+	srv.runs++
+	if res != nil {
+		srv.last = &res.Stats
+		srv.total = srv.total.merge(res.Stats)
+	}
+	srv.mu.Unlock()
+
+	w.Header().Set("X-Scrape-Complete", strconv.FormatBool(res != nil && res.Complete))
+	if err != nil {
+		w.Header().Set("X-Scrape-Error", err.Error())
+	}
+}
+
+// clone copies cfg along with its slices, maps and the structs it points
+// to, so that scrapes running at once share none of them. The Doers,
+// middleware, sinks and other funcs are shared, as they are with the CLI.
+func (cfg *Config) clone() Config {
+	c := *cfg
+	if cfg.BaseURL != nil {
+		u := *cfg.BaseURL
+		c.BaseURL = &u
+	}
+	if cfg.ExtraParams != nil {
+		c.ExtraParams = make(url.Values, len(cfg.ExtraParams))
+		for k, v := range cfg.ExtraParams {
+			c.ExtraParams[k] = slices.Clone(v)
+		}
+	}
+	if cfg.PriceRange != nil {
+		r := *cfg.PriceRange
+		c.PriceRange = &r
+	}
+	if cfg.BasicAuth != nil {
+		a := *cfg.BasicAuth
+		c.BasicAuth = &a
+	}
+	c.RetryableStatus = slices.Clone(cfg.RetryableStatus)
+	c.ExcludeRanges = slices.Clone(cfg.ExcludeRanges)
+	c.Intervals = slices.Clone(cfg.Intervals)
+	c.Middleware = slices.Clone(cfg.Middleware)
+	c.BearerTokens = slices.Clone(cfg.BearerTokens)
+	c.UserAgents = slices.Clone(cfg.UserAgents)
+	c.LocalAddrs = slices.Clone(cfg.LocalAddrs)
+	c.AllowIDs = maps.Clone(cfg.AllowIDs)
+	c.DenyIDs = maps.Clone(cfg.DenyIDs)
+	return c
+}
+
+func (srv *scrapeServer) metrics(w http.ResponseWriter, r *http.Request) {
+	srv.mu.Lock()
+	m := struct {
+		Running int    `json:"running"`
+		Runs    int    `json:"runs"`
+		Last    *Stats `json:"last"`
+		Total   Stats  `json:"total"`
+	}{srv.running, srv.runs, srv.last, srv.total}
+	srv.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(m)
+}
+
+// streamSink sends every batch of products to the client as it comes.
+type streamSink struct {
+	ProductSink
+	rc *http.ResponseController
+}
+
+func (s *streamSink) WriteBatch(products []Product) error {
+	for _, p := range products {
+		if err := s.WriteProduct(p); err != nil {
+			return err
+		}
+	}
+	if err := s.ProductSink.Flush(); err != nil {
+		return err
+	}
+	return s.rc.Flush()
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestServeHTTP(t *testing.T) {
+	catalog := testCatalog(20_000)
+	srv := httptest.NewServer(ServeHTTP(*testConfig(catalog), WithBaseURL("http://shop.test/products")))
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		body   string
+		lo, hi float32
+	}{
+		{"", 0, maxPrice},
+		{`{"min_price": 1000, "max_price": 2500}`, 1000, 2500},
+	} {
+		resp, err := http.Post(srv.URL+"/scrape", "application/json", strings.NewReader(tc.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var products []Product
+		for dec := json.NewDecoder(resp.Body); dec.More(); {
+			var p Product
+			if err := dec.Decode(&p); err != nil {
+				t.Fatalf("body %q: %v", tc.body, err)
+			}
+			products = append(products, p)
+		}
+		resp.Body.Close()
+
+		want := 0
+		for _, p := range catalog.products {
+			if validPrice(p) && p.Price >= tc.lo && p.Price <= tc.hi {
+				want++
+			}
+		}
+		if len(products) != want || resp.Trailer.Get("X-Scrape-Complete") != "true" {
+			t.Errorf("body %q: %d products, complete %q, want %d", tc.body, len(products), resp.Trailer.Get("X-Scrape-Complete"), want)
+		}
+		for _, p := range products {
+			if p.Price < tc.lo || p.Price > tc.hi {
+				t.Errorf("body %q: product %s at %v", tc.body, p.ID, p.Price)
+			}
+		}
+	}
+
+	resp, err := http.Get(srv.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var m struct {
+		Running, Runs int
+		Total         Stats
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
+		t.Fatal(err)
+	}
+	if m.Running != 0 || m.Runs != 2 || m.Total.Requests == 0 {
+		t.Errorf("metrics: %d running, %d runs, %d requests in total", m.Running, m.Runs, m.Total.Requests)
+	}
+
+	// A malformed body is refused.
+	resp, err = http.Post(srv.URL+"/scrape", "application/json", strings.NewReader("{"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("malformed body: status %d", resp.StatusCode)
+	}
+}
+
+// TestServeHTTPConcurrent runs scrapes at once while the caller changes the
+// Config it passed, which none of them may see; run with -race.
+func TestServeHTTPConcurrent(t *testing.T) {
+	catalog := testCatalog(5000)
+	var stray atomic.Int64
+	cfg := testConfig(DoerFunc(func(req *http.Request) (*http.Response, error) {
+		if req.URL.Query().Get("category") != "shoes" {
+			stray.Add(1)
+		}
+		return catalog.Do(req)
+	}))
+	cfg.ExtraParams = url.Values{"category": {"shoes"}}
+	var calls atomic.Int64
+	cfg.Middleware = make([]Middleware, 1, 4)
+	cfg.Middleware[0] = func(next Doer) Doer {
+		return DoerFunc(func(req *http.Request) (*http.Response, error) {
+			calls.Add(1)
+			return next.Do(req)
+		})
+	}
+	srv := httptest.NewServer(ServeHTTP(*cfg, WithBaseURL("http://shop.test/products")))
+	defer srv.Close()
+
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			body := fmt.Sprintf(`{"min_price": %d, "max_price": %d}`, i*1000, (i+1)*1000)
+			resp, err := http.Post(srv.URL+"/scrape", "application/json", strings.NewReader(body))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer resp.Body.Close()
+			for dec := json.NewDecoder(resp.Body); dec.More(); {
+				var p Product
+				if err := dec.Decode(&p); err != nil {
+					t.Errorf("body %s: %v", body, err)
+					return
+				}
+			}
+			if resp.Trailer.Get("X-Scrape-Complete") != "true" {
+				t.Errorf("body %s: incomplete, %s", body, resp.Trailer.Get("X-Scrape-Error"))
+			}
+		}()
+	}
+	cfg.ExtraParams["category"][0] = "boots"
+	cfg.ExtraParams.Set("tag", "sale")
+	cfg.Middleware[0] = nil
+	wg.Wait()
+
+	if n := stray.Load(); n != 0 {
+		t.Errorf("%d requests saw the caller's later params", n)
+	}
+	if calls.Load() == 0 {
+		t.Error("the middleware wasn't called")
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/cookiejar"
+	"sync"
+	"sync/atomic"
+)
+
+// session keeps the cookies of Config.SessionURL, sending them with every
+// request. A 403 most likely means the session expired: the landing page
+// is visited again and the request sent once more.
+type session struct {
+	next Doer
+	// warmer sends the visits, through the scraper's middleware for the
+	// headers the landing page may check too.
+	warmer Doer
+	// turn waits before each visit and resend, which the run's rate limit
+	// and budget know nothing about otherwise.
+	turn   func(context.Context) error
+	url    string
+	method string
+	jar    http.CookieJar
+	logger *slog.Logger
+
+	mu      sync.Mutex // held while visiting
+	gen     atomic.Int64
+	warmUps atomic.Int64
+}
+
+func newSession(next Doer, middleware []Middleware, turn func(context.Context) error, cfg *Config) *session {
+	// A nil public suffix list never fails.
+	jar, _ := cookiejar.New(nil)
+	return &session{
+		next:   next,
+		warmer: Chain(next, middleware...),
+		turn:   turn,
+		url:    cfg.SessionURL,
+		method: cfg.SessionMethod,
+		jar:    jar,
+		logger: cfg.Logger,
+	}
+}
+
+// warmUp visits the landing page for fresh cookies.
+func (s *session) warmUp(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.visit(ctx)
+}
+
+// rewarm visits the landing page again, unless another request already
+// did since gen.
+func (s *session) rewarm(ctx context.Context, gen int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.gen.Load() != gen {
+		return nil
+	}
+	return s.visit(ctx)
+}
+
+func (s *session) visit(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, s.method, s.url, nil)
+	if err != nil {
+		return fmt.Errorf("session warm-up: %w", err)
+	}
+	if err := s.turn(ctx); err != nil {
+		return fmt.Errorf("session warm-up: %w", err)
+	}
+	s.warmUps.Add(1)
+	resp, err := s.send(s.warmer, req)
+	if err != nil {
+		return fmt.Errorf("session warm-up: %w", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("session warm-up: %w", &StatusError{StatusCode: resp.StatusCode})
+	}
+	s.gen.Add(1)
+	s.logger.Info("session warmed up", "url", req.URL.Redacted(), "cookies", len(s.jar.Cookies(req.URL)))
+	return nil
+}
+
+// send sends req through d with the session's cookies, keeping those the
+// response sets.
+func (s *session) send(d Doer, req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for _, c := range s.jar.Cookies(req.URL) {
+		req.AddCookie(c)
+	}
+	resp, err := d.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if cookies := resp.Cookies(); len(cookies) > 0 {
+		s.jar.SetCookies(req.URL, cookies)
+	}
+	return resp, nil
+}
+
+func (s *session) Do(req *http.Request) (*http.Response, error) {
+	gen := s.gen.Load()
+	resp, err := s.send(s.next, req)
+	if err != nil || resp.StatusCode != http.StatusForbidden {
+		return resp, err
+	}
+	if err := s.rewarm(req.Context(), gen); err != nil {
+		s.logger.Warn("session expired", "err", err)
+		return resp, nil
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if err := s.turn(req.Context()); err != nil {
+		return nil, err
+	}
+	return s.send(s.next, req)
+}
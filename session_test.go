@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestSession scrapes an API refusing requests without the cookie of its
+// landing page, whose session expires every 10 requests. The visits and
+// the requests sent again are requests of the run like the others.
+func TestSession(t *testing.T) {
+	catalog := testCatalog(5000)
+	var mu sync.Mutex
+	session, served, hits := 0, 0, int64(0)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		hits++
+		if r.URL.Path == "/landing" {
+			session++
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "s3cr3t-" + strconv.Itoa(session)})
+			return
+		}
+		c, err := r.Cookie("session")
+		if err != nil || c.Value != "s3cr3t-"+strconv.Itoa(session) {
+			http.Error(w, "no session", http.StatusForbidden)
+			return
+		}
+		if served++; served%10 == 0 {
+			session++ // expired, for the next request
+		}
+		resp, err := catalog.Do(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer resp.Body.Close()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+	}))
+	defer srv.Close()
+
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	cfg := testConfig(srv.Client())
+	cfg.Logger = logger
+	cfg.Middleware = []Middleware{Logging(logger, nil)}
+	cfg.SessionURL = srv.URL + "/landing"
+	run := func(cfg *Config) *Result {
+		t.Helper()
+		s, err := NewScraper(cfg, WithBaseURL(srv.URL+"/products"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := s.Run(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+	res := run(cfg)
+	if !res.Complete || len(res.Products) != catalog.Valid() {
+		t.Errorf("complete %v with %d products, want %d", res.Complete, len(res.Products), catalog.Valid())
+	}
+	if n := res.Stats.SessionWarmUps; n < 2 {
+		t.Errorf("%d session warm-ups, want more than the first", n)
+	}
+	if !strings.Contains(logs.String(), "session warmed up") || strings.Contains(logs.String(), "s3cr3t") {
+		t.Errorf("session cookie logged, or nothing was:\n%s", logs.String())
+	}
+	var byWorker int64
+	for _, n := range res.Stats.WorkerRequests {
+		byWorker += n
+	}
+	if res.Stats.Requests != hits || byWorker != hits {
+		t.Errorf("%d requests, %d by worker, the API got %d", res.Stats.Requests, byWorker, hits)
+	}
+
+	// Nor do they go past the budget.
+	mu.Lock()
+	hits = 0
+	mu.Unlock()
+	cfg.MaxRequests = 10
+	res = run(cfg)
+	if res.Complete || hits > cfg.MaxRequests {
+		t.Errorf("complete %v after %d requests, want at most %d", res.Complete, hits, cfg.MaxRequests)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+)
+
+// SharedLimiter paces several scrapers of one host, each given its own
+// RateLimiter by Job, to a single rate. Requests are let through one job
+// at a time, in turn among the jobs with requests waiting: a job with more
+// workers gets no more than its share, and a job that stalls, waiting for
+// nothing, passes its turns on instead of holding them. Turns nobody waits
+// for are saved, up to burst, for whichever job asks first.
+type SharedLimiter struct {
+	refresh time.Duration
+	burst   int
+	clock   Clock
+	done    chan struct{}
+
+	mu     sync.Mutex
+	jobs   []*sharedJob
+	next   int // the job whose turn it is
+	saved  int
+	resume time.Time
+}
+
+type sharedJob struct {
+	l       *SharedLimiter
+	waiting []chan struct{}
+}
+
+// NewSharedLimiter returns a limiter letting rps requests through per
+// second across its jobs. Close it once their scrapers are done. A nil
+// clock is the system clock.
+func NewSharedLimiter(rps float64, burst int, clock Clock) *SharedLimiter {
+	if clock == nil {
+		clock = systemClock{}
+	}
+	l := &SharedLimiter{
+		refresh: time.Duration(float64(time.Second) / rps),
+		burst:   burst,
+		clock:   clock,
+		done:    make(chan struct{}),
+	}
+	go l.run()
+	return l
+}
+
+// Job returns a RateLimiter for one scraper's Config.RateLimiter.
+func (l *SharedLimiter) Job() RateLimiter {
+	j := &sharedJob{l: l}
+	l.mu.Lock()
+	l.jobs = append(l.jobs, j)
+	l.mu.Unlock()
+	return j
+}
+
+// Close stops the limiter: its jobs wait until their contexts are done.
+func (l *SharedLimiter) Close() {
+	close(l.done)
+}
+
+func (l *SharedLimiter) run() {
+	ticker := l.clock.NewTicker(l.refresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+			l.grant()
+		case <-l.done:
+			return
+		}
+	}
+}
+
+// grant lets the next waiting request through, or saves the turn.
+func (l *SharedLimiter) grant() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.clock.Now().Before(l.resume) {
+		return
+	}
+	for i := range l.jobs {
+		k := (l.next + i) % len(l.jobs)
+		j := l.jobs[k]
+		if len(j.waiting) == 0 {
+			continue
+		}
+		close(j.waiting[0])
+		j.waiting = j.waiting[1:]
+		l.next = (k + 1) % len(l.jobs)
+		return
+	}
+	l.saved = min(l.saved+1, l.burst)
+}
+
+func (j *sharedJob) Wait(ctx context.Context) error {
+	l := j.l
+	l.mu.Lock()
+	if l.saved > 0 {
+		l.saved--
+		l.mu.Unlock()
+		return nil
+	}
+	turn := make(chan struct{})
+	j.waiting = append(j.waiting, turn)
+	l.mu.Unlock()
+
+	select {
+	case <-turn:
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		if i := slices.Index(j.waiting, turn); i >= 0 {
+			j.waiting = slices.Delete(j.waiting, i, i+1)
+		} else {
+			// The turn came anyway: save it for someone else.
+			l.saved = min(l.saved+1, l.burst)
+		}
+		l.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// Observe pauses all jobs once the API reports no requests remaining.
+func (j *sharedJob) Observe(remaining int, reset time.Time) {
+	if remaining > 0 {
+		return
+	}
+	l := j.l
+	l.mu.Lock()
+	if reset.After(l.resume) {
+		l.resume = reset
+		l.saved = 0
+	}
+	l.mu.Unlock()
+}
+
+// Rate is the whole limiter's: it is shared by the jobs.
+func (j *sharedJob) Rate() float64 {
+	return float64(time.Second) / float64(j.l.refresh)
+}
+
+func (j *sharedJob) available() int {
+	j.l.mu.Lock()
+	defer j.l.mu.Unlock()
+	return j.l.saved
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// queued waits until job has n requests waiting for their turn.
+func queued(t *testing.T, job RateLimiter, n int) {
+	t.Helper()
+	j := job.(*sharedJob)
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		j.l.mu.Lock()
+		k := len(j.waiting)
+		j.l.mu.Unlock()
+		if k == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d requests waiting, want %d", k, n)
+		}
+	}
+}
+
+// TestSharedLimiterTurns has two busy jobs and one idle: the busy ones take
+// turns, and the idle one gets the next turn once it asks.
+func TestSharedLimiterTurns(t *testing.T) {
+	clock := newFakeClock()
+	l := NewSharedLimiter(1, 0, clock)
+	defer l.Close()
+	waitForWaiters(t, clock, 1)
+
+	jobs := map[string]RateLimiter{"a": l.Job(), "b": l.Job(), "c": l.Job()}
+	order := make(chan string)
+	wait := func(name string, n int) {
+		for range n {
+			go func() {
+				if err := jobs[name].Wait(context.Background()); err == nil {
+					order <- name
+				}
+			}()
+		}
+		queued(t, jobs[name], n)
+	}
+	tick := func() string {
+		clock.Advance(time.Second)
+		select {
+		case name := <-order:
+			return name
+		case <-time.After(5 * time.Second):
+			t.Fatal("no turn given")
+			return ""
+		}
+	}
+
+	wait("a", 3)
+	wait("b", 3)
+	var got []string
+	for range 4 {
+		got = append(got, tick())
+	}
+	// c, stalled, doesn't hold the turns up, and gets one as soon as it
+	// asks, ahead of the requests waiting longer.
+	wait("c", 1)
+	for range 3 {
+		got = append(got, tick())
+	}
+	if want := "a b a b c a b"; strings.Join(got, " ") != want {
+		t.Errorf("turns %q, want %s", got, want)
+	}
+}
+
+// TestSharedLimiterStalledJob runs scrapers sharing a limiter, one stuck on
+// a request that never answers: the others finish.
+func TestSharedLimiterStalledJob(t *testing.T) {
+	l := NewSharedLimiter(2000, 1, nil)
+	defer l.Close()
+	cfg := testConfig(DoerFunc(func(req *http.Request) (*http.Response, error) {
+		<-req.Context().Done()
+		return nil, req.Context().Err()
+	}))
+	cfg.RateLimiter = l.Job()
+	stalled := testScraper(t, cfg)
+	ctx, stop := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		stalled.Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		stop()
+		<-done
+	}()
+
+	for range 2 {
+		catalog := testCatalog(5000)
+		cfg := testConfig(catalog)
+		cfg.RateLimiter = l.Job()
+		cfg.MaxWorkers = 4
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		res, err := testScraper(t, cfg).Run(ctx)
+		cancel()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !res.Complete || len(res.Products) != catalog.Valid() {
+			t.Errorf("complete %v with %d products, want %d", res.Complete, len(res.Products), catalog.Valid())
+		}
+	}
+}
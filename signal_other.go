@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+// handlePauseSignals is a no-op where SIGUSR1/SIGUSR2 don't exist; use
+// Scraper.Pause and Scraper.Resume instead.
+func handlePauseSignals(s *Scraper) (stop func()) {
+	return func() {}
+}
@@ -0,0 +1,54 @@
+//go:build unix
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestPauseSignals(t *testing.T) {
+	s := testScraper(t, testConfig(testCatalog(10)))
+	stop := handlePauseSignals(s)
+	defer stop()
+
+	waitPaused := func(want bool) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+			if paused, _ := s.paused(); paused == want {
+				return
+			}
+		}
+		t.Fatalf("paused isn't %v", want)
+	}
+	syscall.Kill(os.Getpid(), syscall.SIGUSR1)
+	waitPaused(true)
+	syscall.Kill(os.Getpid(), syscall.SIGUSR2)
+	waitPaused(false)
+}
+
+// TestInterruptSignal stops a run with SIGINT the way main does, meant
+// for -race.
+func TestInterruptSignal(t *testing.T) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	cfg := testConfig(testCatalog(20_000))
+	cfg.Middleware = append(cfg.Middleware, afterRequests(5, func(req *http.Request) (*http.Response, error) {
+		syscall.Kill(os.Getpid(), syscall.SIGINT)
+		<-req.Context().Done()
+		return nil, req.Context().Err()
+	}))
+	res, err := testScraper(t, cfg).Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Truncated || exitCode(res, err) != exitTruncated {
+		t.Errorf("truncated %v (%s), want an interrupted run", res.Truncated, res.Stats.StopReason)
+	}
+}
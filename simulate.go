@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync/atomic"
+)
+
+// Catalog is an in-memory products API: a Doer answering like the real one
+// without any network, so the splitting can be tried on synthetic price
+// distributions. Intervals include their lower bound but not their upper
+// one, and responses hold at most apiLimit products.
+type Catalog struct {
+	products []Product // by price
+	params   CatalogParams
+
+	requests atomic.Int64
+}
+
+// CatalogParams are the query params a Catalog reads, to match the
+// scraper's Config.MinPriceParam, Config.MaxPriceParam and
+// Config.OffsetParam. Empty price params are the scraper's defaults; an
+// empty Offset pages nothing.
+type CatalogParams struct {
+	MinPrice, MaxPrice string
+	Offset             string
+}
+
+// catalogParams are the params cfg scrapes with, for a Catalog.
+func (cfg *Config) catalogParams() CatalogParams {
+	return CatalogParams{
+		MinPrice: cmp.Or(cfg.MinPriceParam, minPriceParam),
+		MaxPrice: cmp.Or(cfg.MaxPriceParam, maxPriceParam),
+		Offset:   cmp.Or(cfg.OffsetParam, offsetParam),
+	}
+}
+
+// NewCatalog serves products to requests made with params.
+func NewCatalog(products []Product, params CatalogParams) *Catalog {
+	products = slices.Clone(products)
+	slices.SortStableFunc(products, func(a, b Product) int {
+		switch {
+		case a.Price < b.Price:
+			return -1
+		case a.Price > b.Price:
+			return 1
+		}
+		return 0
+	})
+	params.MinPrice = cmp.Or(params.MinPrice, minPriceParam)
+	params.MaxPrice = cmp.Or(params.MaxPrice, maxPriceParam)
+	return &Catalog{products: products, params: params}
+}
+
+// RandomCatalog makes n products with roughly exponential prices rounded
+// to cents, a tenth of them in clusters sharing a price, and a few with a
+// zero price, served with params.
+func RandomCatalog(n int, seed int64, params CatalogParams) *Catalog {
+	rng := rand.New(rand.NewSource(seed))
+	products := make([]Product, 0, n)
+	for len(products) < n {
+		price := float32(math.Round(rng.ExpFloat64()*float64(maxPrice)/20*100) / 100)
+		price = min(price, maxPrice-0.01)
+		size := 1
+		switch r := rng.Float64(); {
+		case r < 0.001:
+			price = 0
+		case r < 0.0015:
+			size = 1 + rng.Intn(apiLimit/2)
+		}
+		for i := 0; i < size && len(products) < n; i++ {
+			id := len(products) + 1
+			products = append(products, Product{ID: ProductID(strconv.Itoa(id)), Name: "p" + strconv.Itoa(id), Price: price})
+		}
+	}
+	return NewCatalog(products, params)
+}
+
+// Len is the number of products in c, Valid those with a valid price.
+func (c *Catalog) Len() int { return len(c.products) }
+
+func (c *Catalog) Valid() int {
+	n := 0
+	for _, p := range c.products {
+		if validPrice(p) {
+			n++
+		}
+	}
+	return n
+}
+
+// Requests is the number of requests c answered.
+func (c *Catalog) Requests() int64 { return c.requests.Load() }
+
+func (c *Catalog) Do(req *http.Request) (*http.Response, error) {
+	c.requests.Add(1)
+	q := req.URL.Query()
+	lo, err := strconv.ParseFloat(q.Get(c.params.MinPrice), 32)
+	if err != nil {
+		return simulatedResponse(req, http.StatusBadRequest, nil), nil
+	}
+	hi, err := strconv.ParseFloat(q.Get(c.params.MaxPrice), 32)
+	if err != nil {
+		return simulatedResponse(req, http.StatusBadRequest, nil), nil
+	}
+
+	from, _ := slices.BinarySearchFunc(c.products, float32(lo), cmpPrice)
+	to, _ := slices.BinarySearchFunc(c.products, float32(hi), cmpPrice)
+	products := c.products[from:to]
+	res := Response{Total: len(products)}
+	if c.params.Offset != "" {
+		if off, _ := strconv.Atoi(q.Get(c.params.Offset)); off > 0 {
+			products = products[min(off, len(products)):]
+		}
+	}
+	res.Products = products[:min(len(products), apiLimit)]
+	res.Count = len(res.Products)
+	body, err := json.Marshal(res)
+	if err != nil {
+		return nil, err
+	}
+	return simulatedResponse(req, http.StatusOK, body), nil
+}
+
+func cmpPrice(p Product, price float32) int {
+	switch {
+	case p.Price < price:
+		return -1
+	case p.Price > price:
+		return 1
+	}
+	return 0
+}
+
+func simulatedResponse(req *http.Request, status int, body []byte) *http.Response {
+	return &http.Response{
+		Status:     strconv.Itoa(status) + " " + http.StatusText(status),
+		StatusCode: status,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(bytes.NewReader(body)),
+		Request:    req,
+	}
+}
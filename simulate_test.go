@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var catalogSeed = flag.Int64("catalog-seed", 0, "run TestRandomCatalogs with this seed only, e.g. one it failed with")
+
+// randomProducts draws a catalog of one of a few shapes: spread out, with
+// empty price ranges, clustered at a few prices, or in clusters of exactly
+// apiLimit products.
+func randomProducts(rng *rand.Rand) (string, []Product) {
+	var products []Product
+	add := func(price float32, n int) {
+		for range n {
+			id := strconv.Itoa(len(products) + 1)
+			products = append(products, Product{ID: ProductID(id), Name: "p" + id, Price: price})
+		}
+	}
+	cents := func(x float64) float32 {
+		return float32(min(math.Round(x*100)/100, float64(maxPrice)-0.01))
+	}
+
+	shapes := []string{"empty", "spread", "gaps", "clusters", "at limit"}
+	shape := shapes[rng.Intn(len(shapes))]
+	switch shape {
+	case "spread":
+		for range rng.Intn(10 * apiLimit) {
+			add(cents(rng.ExpFloat64()*float64(maxPrice)/20), 1)
+		}
+		add(0, rng.Intn(10))
+	case "gaps":
+		// Products in a few narrow bands, nothing in between.
+		for range 1 + rng.Intn(5) {
+			lo := rng.Float64() * float64(maxPrice)
+			for range rng.Intn(3 * apiLimit) {
+				add(cents(lo+rng.Float64()*10), 1)
+			}
+		}
+	case "clusters":
+		for range 1 + rng.Intn(20) {
+			add(cents(rng.Float64()*float64(maxPrice)), 1+rng.Intn(apiLimit))
+		}
+	case "at limit":
+		for range 1 + rng.Intn(4) {
+			add(cents(rng.Float64()*float64(maxPrice)), apiLimit)
+		}
+		for range rng.Intn(apiLimit) {
+			add(cents(rng.Float64()*float64(maxPrice)), 1)
+		}
+	}
+	rng.Shuffle(len(products), func(i, j int) { products[i], products[j] = products[j], products[i] })
+	return shape, products
+}
+
+// TestRandomCatalogs scrapes random catalogs, each in a subtest named by
+// its seed: rerun a failed one with -catalog-seed.
+func TestRandomCatalogs(t *testing.T) {
+	seeds := []int64{*catalogSeed}
+	if *catalogSeed == 0 {
+		n := 30
+		if testing.Short() {
+			n = 5
+		}
+		rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+		seeds = seeds[:0]
+		for range n {
+			seeds = append(seeds, rng.Int63())
+		}
+	}
+	for _, seed := range seeds {
+		t.Run(fmt.Sprint("seed=", seed), func(t *testing.T) {
+			shape, products := randomProducts(rand.New(rand.NewSource(seed)))
+			cfg := testConfig(nil)
+			cfg.MaxWorkers = 4
+			// Clusters of apiLimit products at one price can't be split.
+			cfg.PaginateBelow = 1
+			if seed%2 == 0 {
+				cfg.MinPriceParam, cfg.MaxPriceParam, cfg.OffsetParam = "from", "to", "skip"
+			}
+			catalog := NewCatalog(products, cfg.catalogParams())
+			cfg.Client = catalog
+
+			res, err := testScraper(t, cfg).Run(context.Background())
+			if err != nil {
+				t.Fatalf("%s catalog of %d products: %v", shape, catalog.Len(), err)
+			}
+			if !res.Complete {
+				t.Errorf("%s catalog: incomplete", shape)
+			}
+
+			want := map[ProductID]bool{}
+			for _, p := range products {
+				if validPrice(p) {
+					want[p.ID] = true
+				}
+			}
+			seen := map[ProductID]bool{}
+			for _, p := range res.Products {
+				if seen[p.ID] {
+					t.Errorf("%s catalog: product %s collected twice", shape, p.ID)
+				}
+				seen[p.ID] = true
+				if !want[p.ID] {
+					t.Errorf("%s catalog: product %s (price %v) collected, not in the catalog", shape, p.ID, p.Price)
+				}
+			}
+			if len(seen) != len(want) {
+				t.Errorf("%s catalog: collected %d products, want %d", shape, len(seen), len(want))
+			}
+
+			// Every full interval costs at most a split to the price
+			// precision and a page past its end.
+			depth := int64(math.Ceil(math.Log2(float64(maxPrice) * math.Pow10(pricePrecision))))
+			limit := 2 + 2*depth*int64(len(want)/apiLimit+1)
+			if got := catalog.Requests(); got > limit {
+				t.Errorf("%s catalog of %d products: %d requests, want at most %d", shape, len(want), got, limit)
+			}
+		})
+	}
+}
+
+func TestCatalogParams(t *testing.T) {
+	cfg := testConfig(nil)
+	cfg.MinPriceParam, cfg.MaxPriceParam = "price_gte", "price_lt"
+	catalog := RandomCatalog(3000, 1, cfg.catalogParams())
+	cfg.Client = catalog
+	res, err := testScraper(t, cfg).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Complete || len(res.Products) != catalog.Valid() {
+		t.Errorf("complete %v with %d products, want %d", res.Complete, len(res.Products), catalog.Valid())
+	}
+
+	// A catalog reading other params answers nothing.
+	cfg = testConfig(testCatalog(3000))
+	cfg.MinPriceParam = "price_gte"
+	if _, err := testScraper(t, cfg).Run(context.Background()); err == nil {
+		t.Error("scraped a catalog reading other params")
+	}
+}
+
+// BenchmarkSimulate scrapes a simulated catalog of 100k products per
+// iteration, reporting the requests it took.
+func BenchmarkSimulate(b *testing.B) {
+	catalog := testCatalog(100_000)
+	b.ResetTimer()
+	for range b.N {
+		cfg := testConfig(catalog)
+		cfg.MaxWorkers = 8
+		res, err := testScraper(b, cfg).Run(context.Background())
+		if err != nil {
+			b.Fatal(err)
+		}
+		if !res.Complete {
+			b.Fatal("incomplete")
+		}
+	}
+	b.ReportMetric(float64(catalog.Requests())/float64(b.N), "requests/op")
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"slices"
+	"strconv"
+)
+
+// checkReusedIDs fetches again up to n random products of the output at
+// path with IDs up to Config.SinceID, and warns about those that changed:
+// a catalog reusing IDs isn't append-only, and scraping only higher IDs
+// would miss products.
+func (s *Scraper) checkReusedIDs(ctx context.Context, path string, n int, rng *rand.Rand) error {
+	known, err := readSnapshot(path)
+	if err != nil {
+		return err
+	}
+	var ids []int
+	for id := range known {
+		if n, err := strconv.Atoi(string(id)); err == nil && n <= s.cfg.SinceID {
+			ids = append(ids, n)
+		}
+	}
+	slices.Sort(ids)
+	rng.Shuffle(len(ids), func(i, j int) { ids[i], ids[j] = ids[j], ids[i] })
+
+	for _, id := range ids[:min(n, len(ids))] {
+		res, _, err := s.probe(ctx, s.cfg.priceRange(), 1, &[2]int{id, id})
+		if err != nil {
+			return err
+		}
+		was := known[ProductID(strconv.Itoa(id))]
+		switch {
+		case len(res.Products) == 0:
+			s.cfg.Logger.Warn("known product is gone, IDs may be reused", "id", id)
+		case res.Products[0] != was:
+			s.cfg.Logger.Warn("known product changed, IDs may be reused", "was", was, "now", res.Products[0])
+		}
+	}
+	return nil
+}
+
+// maxNumericID is the highest numeric ID of res's products, or floor if
+// none is higher.
+func maxNumericID(res *Result, floor int) (int, error) {
+	high := floor
+	err := res.EachProduct(func(p Product) error {
+		if id, err := strconv.Atoi(string(p.ID)); err == nil {
+			high = max(high, id)
+		}
+		return nil
+	})
+	return high, err
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+)
+
+// ProductSink receives every collected product, one at a time and from a
+// single goroutine, or a response at a time if it is a BatchSink. Flush is
+// called once the run is over.
+type ProductSink interface {
+	WriteProduct(Product) error
+	Flush() error
+}
+
+// BatchSink is a ProductSink that takes the products of a response at
+// once, e.g. to insert them in a single statement. The collector calls
+// WriteBatch instead of WriteProduct; it must not keep the slice.
+type BatchSink interface {
+	ProductSink
+	WriteBatch([]Product) error
+}
+
+// writeBatch hands products to sink at once if it is a BatchSink, or one
+// by one, carrying on past failures and returning the first.
+func writeBatch(sink ProductSink, products []Product) error {
+	if b, ok := sink.(BatchSink); ok {
+		return b.WriteBatch(products)
+	}
+	var first error
+	for _, p := range products {
+		if err := sink.WriteProduct(p); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+type multiSink struct {
+	sinks []ProductSink
+	errs  []error // first write error of each sink
+}
+
+// multiBatchSink is a multiSink with a BatchSink among its sinks.
+type multiBatchSink struct {
+	*multiSink
+}
+
+// MultiSink writes each product to all sinks. A failing sink doesn't stop
+// the others from receiving products; WriteProduct returns the errors of
+// that write, and the first error of each sink is returned by Flush too.
+// It is a BatchSink if any of sinks is.
+func MultiSink(sinks ...ProductSink) ProductSink {
+	m := &multiSink{sinks: sinks, errs: make([]error, len(sinks))}
+	for _, s := range sinks {
+		if _, ok := s.(BatchSink); ok {
+			return multiBatchSink{m}
+		}
+	}
+	return m
+}
+
+func (m *multiSink) WriteProduct(p Product) error {
+	var errs []error
+	for i, s := range m.sinks {
+		if err := s.WriteProduct(p); err != nil {
+			errs = append(errs, err)
+			if m.errs[i] == nil {
+				m.errs[i] = err
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (m multiBatchSink) WriteBatch(products []Product) error {
+	var errs []error
+	for i, s := range m.sinks {
+		if err := writeBatch(s, products); err != nil {
+			errs = append(errs, err)
+			if m.errs[i] == nil {
+				m.errs[i] = err
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (m *multiSink) Flush() error {
+	errs := m.errs
+	for _, s := range m.sinks {
+		errs = append(errs, s.Flush())
+	}
+	return errors.Join(errs...)
+}
+
+// SinkFailure decides what becomes of a product the sink failed to write.
+type SinkFailure int
+
+const (
+	// SinkFailFatal aborts the run.
+	SinkFailFatal SinkFailure = iota
+	// SinkFailDeadLetter appends the product to deadLetterProducts in
+	// Config.DeadLetterDir and carries on.
+	SinkFailDeadLetter
+)
+
+const deadLetterProducts string = "products.ndjson"
+
+// guardedSink hands write errors to onErr instead of returning them.
+type guardedSink struct {
+	ProductSink
+	onErr func(Product, error)
+}
+
+func (g guardedSink) WriteProduct(p Product) error {
+	if err := g.ProductSink.WriteProduct(p); err != nil {
+		g.onErr(p, err)
+	}
+	return nil
+}
+
+// WriteBatch hands every product of a failed batch to onErr, not knowing
+// which were written.
+func (g guardedSink) WriteBatch(products []Product) error {
+	b, ok := g.ProductSink.(BatchSink)
+	if !ok {
+		for _, p := range products {
+			g.WriteProduct(p)
+		}
+		return nil
+	}
+	if err := b.WriteBatch(products); err != nil {
+		for _, p := range products {
+			g.onErr(p, err)
+		}
+	}
+	return nil
+}
+
+type ndjsonSink struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+// NewNDJSONSink writes products to w as newline-delimited JSON.
+func NewNDJSONSink(w io.Writer) ProductSink {
+	bw := bufio.NewWriter(w)
+	return &ndjsonSink{w: bw, enc: json.NewEncoder(bw)}
+}
+
+func (s *ndjsonSink) WriteProduct(p Product) error {
+	return s.enc.Encode(p)
+}
+
+func (s *ndjsonSink) Flush() error {
+	return s.w.Flush()
+}
+
+type csvSink struct {
+	w      *csv.Writer
+	header bool
+}
+
+// NewCSVSink writes products to w as CSV with a header row. The sale_price
+// column is empty for products without a sale price.
+func NewCSVSink(w io.Writer) ProductSink {
+	return &csvSink{w: csv.NewWriter(w)}
+}
+
+func (s *csvSink) WriteProduct(p Product) error {
+	if !s.header {
+		s.header = true
+		if err := s.w.Write([]string{"id", "name", "price", "sale_price"}); err != nil {
+			return err
+		}
+	}
+	salePrice := ""
+	if p.SalePrice != 0 {
+		salePrice = strconv.FormatFloat(float64(p.SalePrice), 'f', -1, 32)
+	}
+	return s.w.Write([]string{
+		string(p.ID),
+		p.Name,
+		strconv.FormatFloat(float64(p.Price), 'f', -1, 32),
+		salePrice,
+	})
+}
+
+func (s *csvSink) Flush() error {
+	s.w.Flush()
+	return s.w.Error()
+}
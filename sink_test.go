@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCSVSink(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		products []Product
+		want     string
+	}{
+		{"none", nil, ""},
+		{"no sale price", []Product{{ID: "1", Name: "a", Price: 9.99}}, "id,name,price,sale_price\n1,a,9.99,\n"},
+		{"sale price", []Product{{ID: "2", Name: "b", Price: 10, SalePrice: 7.5}}, "id,name,price,sale_price\n2,b,10,7.5\n"},
+		{"quoted", []Product{{ID: "SKU-3", Name: `c, "d"`, Price: 1}}, "id,name,price,sale_price\nSKU-3,\"c, \"\"d\"\"\",1,\n"},
+		{"several", []Product{{ID: "1", Name: "a", Price: 1}, {ID: "2", Name: "b", Price: 2, SalePrice: 1.5}},
+			"id,name,price,sale_price\n1,a,1,\n2,b,2,1.5\n"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			sink := NewCSVSink(&buf)
+			for _, p := range tc.products {
+				if err := sink.WriteProduct(p); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := sink.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != tc.want {
+				t.Errorf("got %q, want %q", buf.String(), tc.want)
+			}
+		})
+	}
+}
+
+// slowSink holds its first product until release is closed, telling
+// held once it has it.
+type slowSink struct {
+	ids     map[ProductID]bool
+	held    chan struct{}
+	release chan struct{}
+}
+
+func (k *slowSink) WriteProduct(p Product) error {
+	if len(k.ids) == 0 {
+		close(k.held)
+		<-k.release
+	}
+	k.ids[p.ID] = true
+	return nil
+}
+
+func (k *slowSink) Flush() error { return nil }
+
+func TestSlowSink(t *testing.T) {
+	catalog := testCatalog(20_000)
+	cfg := testConfig(catalog)
+	cfg.MaxWorkers = 8
+	cfg.StatsOnly = true
+	cfg.ProductBuffer = 2 * apiLimit
+	sink := &slowSink{ids: map[ProductID]bool{}, held: make(chan struct{}), release: make(chan struct{})}
+	cfg.Sink = sink
+	s := testScraper(t, cfg)
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.Run(context.Background())
+		done <- err
+	}()
+
+	<-sink.held
+	select {
+	case err := <-done:
+		t.Fatalf("run ended with the sink held: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	// The workers block on the buffer rather than pile products up: at
+	// most it is full and the collector holds one response.
+	if sent, limit := s.products.Load(), int64(cfg.ProductBuffer+apiLimit); sent > limit {
+		t.Errorf("%d products sent ahead of the sink, want at most %d", sent, limit)
+	}
+
+	close(sink.release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if len(sink.ids) != catalog.Valid() {
+		t.Errorf("sink got %d products, want %d", len(sink.ids), catalog.Valid())
+	}
+}
+
+// countingSink counts the products written to it, by ID.
+type countingSink struct {
+	ids     map[ProductID]int
+	flushed bool
+}
+
+func (c *countingSink) WriteProduct(p Product) error {
+	c.ids[p.ID]++
+	return nil
+}
+
+func (c *countingSink) Flush() error {
+	c.flushed = true
+	return nil
+}
+
+// countingBatchSink takes the products of a response at once.
+type countingBatchSink struct{ countingSink }
+
+func (c *countingBatchSink) WriteBatch(products []Product) error {
+	for _, p := range products {
+		c.ids[p.ID]++
+	}
+	return nil
+}
+
+func TestMultiSink(t *testing.T) {
+	catalog := testCatalog(5000)
+	one := &countingSink{ids: map[ProductID]int{}}
+	other := &countingBatchSink{countingSink{ids: map[ProductID]int{}}}
+	cfg := testConfig(catalog)
+	cfg.MaxWorkers = 4
+	cfg.Sink = MultiSink(one, other)
+	res, err := testScraper(t, cfg).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, sink := range map[string]*countingSink{"sink": one, "batch sink": &other.countingSink} {
+		if len(sink.ids) != len(res.Products) || !sink.flushed {
+			t.Errorf("%s got %d products, flushed %v, want %d", name, len(sink.ids), sink.flushed, len(res.Products))
+		}
+		for _, p := range res.Products {
+			if n := sink.ids[p.ID]; n != 1 {
+				t.Errorf("%s got product %s %d times", name, p.ID, n)
+			}
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// readSnapshot loads the products of an NDJSON snapshot by ID. A missing
+// snapshot is empty.
+func readSnapshot(path string) (map[ProductID]Product, error) {
+	products := map[ProductID]Product{}
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return products, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for dec.More() {
+		var p Product
+		if err := dec.Decode(&p); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		products[p.ID] = p
+	}
+	return products, nil
+}
+
+// writeSnapshot replaces the snapshot at path with products, sorted by ID,
+// through a temporary file so that a failure leaves the old one intact.
+func writeSnapshot(path string, products map[ProductID]Product) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	sink := NewNDJSONSink(w)
+	ids := make([]ProductID, 0, len(products))
+	for id := range products {
+		ids = append(ids, id)
+	}
+	slices.SortFunc(ids, compareIDs)
+	for _, id := range ids {
+		if err := sink.WriteProduct(products[id]); err != nil {
+			return err
+		}
+	}
+	if err := sink.Flush(); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// idSink records the IDs of the products written to it.
+type idSink map[ProductID]struct{}
+
+func (s idSink) WriteProduct(p Product) error {
+	s[p.ID] = struct{}{}
+	return nil
+}
+
+func (s idSink) Flush() error { return nil }
+
+// deletedIDs lists the IDs of snapshot no longer in the catalog, sorted,
+// scraping the whole catalog for its IDs as a full run would. The scrape
+// is skipped only when the run added nothing to snapshot and the catalog's
+// total is exactly its size: a total alone can't tell deletions from
+// products added meanwhile.
+func deletedIDs(ctx context.Context, cfg Config, opts []Option, snapshot map[ProductID]Product, added int) ([]ProductID, error) {
+	// Whatever narrowed the run would pass for deletions.
+	cfg.UpdatedSince = time.Time{}
+	cfg.Intervals = nil
+	cfg.SinceID = 0
+	cfg.ExcludeRanges = nil
+	cfg.AllowIDs = nil
+	cfg.DenyIDs = nil
+	cfg.Sample = 0
+	cfg.MaxProducts = 0
+	ids := idSink{}
+	cfg.Sink = ids
+	cfg.StatsOnly = true
+	s, err := NewScraper(&cfg, opts...)
+	if err != nil {
+		return nil, err
+	}
+	res, _, err := s.probe(ctx, cfg.priceRange(), 1, nil)
+	if err != nil {
+		return nil, fmt.Errorf("probing the catalog's total: %w", err)
+	}
+	if added == 0 && res.Total == len(snapshot) {
+		return nil, nil
+	}
+
+	cfg.Logger.Info("scraping the catalog's IDs for deletions", "snapshot", len(snapshot), "total", res.Total)
+	run, err := s.Run(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !run.Complete {
+		return nil, errors.New("the catalog's IDs were scraped incompletely")
+	}
+	var deleted []ProductID
+	for id := range snapshot {
+		if _, ok := ids[id]; !ok {
+			deleted = append(deleted, id)
+		}
+	}
+	slices.SortFunc(deleted, compareIDs)
+	return deleted, nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+func TestDeletedIDs(t *testing.T) {
+	catalog := testCatalog(2000)
+	snapshot := map[ProductID]Product{}
+	for _, p := range catalog.products {
+		if validPrice(p) {
+			snapshot[p.ID] = p
+		}
+	}
+	// Two products deleted, and two added that the run found: the total
+	// matches the snapshot's size anyway.
+	added := 0
+	for id := range snapshot {
+		if added == 2 {
+			break
+		}
+		delete(snapshot, id)
+		added++
+	}
+	snapshot["gone-1"] = Product{ID: "gone-1", Price: 1}
+	snapshot["gone-2"] = Product{ID: "gone-2", Price: 2}
+
+	for _, tc := range []struct {
+		name   string
+		narrow func(cfg *Config)
+	}{
+		{"full", func(*Config) {}},
+		{"max products", func(cfg *Config) { cfg.MaxProducts = 10 }},
+		{"allow list", func(cfg *Config) { cfg.AllowIDs = map[ProductID]struct{}{"1": {}} }},
+		{"excluded range", func(cfg *Config) { cfg.ExcludeRanges = []Interval{{0, maxPrice}} }},
+		{"sample", func(cfg *Config) { cfg.Sample = 0.1 }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := testConfig(catalog)
+			tc.narrow(cfg)
+			deleted, err := deletedIDs(context.Background(), *cfg, []Option{WithBaseURL("http://shop.test/products")}, snapshot, added)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := []ProductID{"gone-1", "gone-2"}; !slices.Equal(deleted, want) {
+				t.Errorf("deleted %v, want %v", deleted, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"container/heap"
+	"encoding/json"
+	"errors"
+	"os"
+	"slices"
+)
+
+// spillFanIn caps the runs merged at once, and so the files open: past
+// it, the oldest are merged into a single run first.
+const spillFanIn = 64
+
+// histogramBuckets caps the intervals Result.Histogram has when spilling.
+const histogramBuckets = 4096
+
+// spillSink appends the products collected past Config.SpillAfter to a
+// temporary NDJSON file in Config.SpillDir, created on the first one.
+type spillSink struct {
+	dir  string
+	f    *os.File
+	sink ProductSink
+}
+
+func (s *spillSink) WriteProduct(p Product) error {
+	if s.f == nil {
+		f, err := os.CreateTemp(s.dir, "products-*.ndjson")
+		if err != nil {
+			return err
+		}
+		s.f, s.sink = f, NewNDJSONSink(f)
+	}
+	return s.sink.WriteProduct(p)
+}
+
+func (s *spillSink) Flush() error {
+	if s.f == nil {
+		return nil
+	}
+	return errors.Join(s.sink.Flush(), s.f.Close())
+}
+
+// path is the spill file, "" if nothing was spilled.
+func (s *spillSink) path() string {
+	if s.f == nil {
+		return ""
+	}
+	return s.f.Name()
+}
+
+// spillRecord is a product the collector didn't keep in memory, with what
+// it needs to count the product once the runs are merged.
+type spillRecord struct {
+	Product  Product  `json:"product"`
+	Interval Interval `json:"interval"`
+	// Filtered is set for a product the ID filter left out.
+	Filtered bool `json:"filtered,omitempty"`
+}
+
+// spiller keeps the products past Config.SpillAfter on disk, in runs of
+// runSize sorted by ID. merge goes through the runs at once in ID order,
+// so that a product seen again after its run was written, which the
+// collector no longer remembers, is counted once.
+type spiller struct {
+	dir     string
+	runSize int
+	out     ProductSink // gets the merged valid products
+	fail    func(error)
+
+	run  []spillRecord
+	runs []string
+	err  error
+}
+
+func (s *spiller) add(rec spillRecord) { s.run = append(s.run, rec) }
+
+func (s *spiller) full() bool { return len(s.run) >= s.runSize }
+
+// flush writes the buffered records out as a run.
+func (s *spiller) flush() {
+	defer func() { s.run = s.run[:0] }()
+	if len(s.run) == 0 || s.err != nil {
+		return
+	}
+	slices.SortStableFunc(s.run, func(a, b spillRecord) int { return cmp.Compare(a.Product.ID, b.Product.ID) })
+	path, err := s.writeRun(func(emit func(spillRecord) error) error {
+		for _, rec := range s.run {
+			if err := emit(rec); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		s.failed(err)
+		return
+	}
+	s.runs = append(s.runs, path)
+}
+
+// merge flushes the last run and calls keep with the first record of
+// every product in the runs, in ID order, removing them.
+func (s *spiller) merge(keep func(spillRecord)) {
+	s.flush()
+	defer func() {
+		for _, path := range s.runs {
+			os.Remove(path)
+		}
+		s.runs = nil
+	}()
+	// Each pass merges the runs spillFanIn at a time, in order, for the
+	// first record of a product to stay first.
+	for s.err == nil && len(s.runs) > spillFanIn {
+		var merged []string
+		for len(s.runs) > 0 {
+			group := s.runs[:min(spillFanIn, len(s.runs))]
+			path, err := s.writeRun(func(emit func(spillRecord) error) error {
+				return mergeRuns(group, emit)
+			})
+			if err != nil {
+				s.runs = append(merged, s.runs...)
+				s.failed(err)
+				return
+			}
+			for _, p := range group {
+				os.Remove(p)
+			}
+			merged = append(merged, path)
+			s.runs = s.runs[len(group):]
+		}
+		s.runs = merged
+	}
+	if s.err != nil {
+		return
+	}
+	err := mergeRuns(s.runs, func(rec spillRecord) error {
+		keep(rec)
+		return nil
+	})
+	if err != nil {
+		s.failed(err)
+	}
+}
+
+// writeRun writes the records fill emits to a new run file.
+func (s *spiller) writeRun(fill func(emit func(spillRecord) error) error) (string, error) {
+	f, err := os.CreateTemp(s.dir, "run-*.ndjson")
+	if err != nil {
+		return "", err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	err = fill(func(rec spillRecord) error { return enc.Encode(rec) })
+	if err = errors.Join(err, w.Flush(), f.Close()); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// failed records the first spill error, which fail makes fatal; the
+// records after it are dropped.
+func (s *spiller) failed(err error) {
+	if s.err == nil {
+		s.err = err
+		s.fail(err)
+	}
+}
+
+// runHead is the next record of a run being merged.
+type runHead struct {
+	rec spillRecord
+	run int
+	dec *json.Decoder
+}
+
+// runHeap orders the heads of the runs by ID, then run.
+type runHeap []runHead
+
+func (h runHeap) Len() int { return len(h) }
+
+func (h runHeap) Less(i, j int) bool {
+	if c := cmp.Compare(h[i].rec.Product.ID, h[j].rec.Product.ID); c != 0 {
+		return c < 0
+	}
+	return h[i].run < h[j].run
+}
+
+func (h runHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *runHeap) Push(x any) { *h = append(*h, x.(runHead)) }
+
+func (h *runHeap) Pop() any {
+	n := len(*h)
+	head := (*h)[n-1]
+	*h = (*h)[:n-1]
+	return head
+}
+
+// mergeRuns calls emit with the records of the runs at paths in ID order,
+// only the first of each product: that of the earliest run.
+func mergeRuns(paths []string, emit func(spillRecord) error) error {
+	h := make(runHeap, 0, len(paths))
+	for i, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		head := runHead{run: i, dec: json.NewDecoder(bufio.NewReader(f))}
+		if err := head.dec.Decode(&head.rec); err != nil {
+			return err
+		}
+		h = append(h, head)
+	}
+	heap.Init(&h)
+
+	var last ProductID
+	for first := true; len(h) > 0; first = false {
+		head := &h[0]
+		if first || head.rec.Product.ID != last {
+			last = head.rec.Product.ID
+			if err := emit(head.rec); err != nil {
+				return err
+			}
+		}
+		if head.dec.More() {
+			head.rec = spillRecord{}
+			if err := head.dec.Decode(&head.rec); err != nil {
+				return err
+			}
+			heap.Fix(&h, 0)
+		} else {
+			heap.Pop(&h)
+		}
+	}
+	return nil
+}
+
+// histogram counts products per interval, in at most max buckets if
+// max > 0: past it, neighbouring buckets are merged pairwise.
+type histogram struct {
+	counts map[Interval]int
+	max    int
+	// buckets are those of counts in price order once merged, to find
+	// the one a narrower interval falls in.
+	buckets []Interval
+}
+
+func (h *histogram) add(i Interval, n int) {
+	if h.buckets != nil {
+		k, found := slices.BinarySearchFunc(h.buckets, i, func(b, i Interval) int { return cmp.Compare(b[0], i[0]) })
+		in := k
+		if !found {
+			in--
+		}
+		if in >= 0 && h.buckets[in][0] <= i[0] && i[1] <= h.buckets[in][1] {
+			h.counts[h.buckets[in]] += n
+			return
+		}
+		if _, ok := h.counts[i]; !ok {
+			h.buckets = slices.Insert(h.buckets, k, i)
+		}
+	}
+	h.counts[i] += n
+	if h.max > 0 && len(h.counts) > h.max {
+		h.coarsen()
+	}
+}
+
+// coarsen halves the buckets, merging them in pairs of neighbours.
+func (h *histogram) coarsen() {
+	buckets := make([]Interval, 0, len(h.counts))
+	for b := range h.counts {
+		buckets = append(buckets, b)
+	}
+	slices.SortFunc(buckets, func(a, b Interval) int {
+		return cmp.Or(cmp.Compare(a[0], b[0]), cmp.Compare(a[1], b[1]))
+	})
+	counts := make(map[Interval]int, (len(buckets)+1)/2)
+	merged := buckets[:0]
+	for k := 0; k < len(buckets); k += 2 {
+		b, n := buckets[k], h.counts[buckets[k]]
+		if k+1 < len(buckets) {
+			next := buckets[k+1]
+			b = Interval{b[0], max(b[1], next[1])}
+			n += h.counts[next]
+		}
+		if _, ok := counts[b]; !ok {
+			merged = append(merged, b)
+		}
+		counts[b] += n
+	}
+	h.counts, h.buckets = counts, merged
+}
+
+// EachProduct calls fn with Products and then those spilled to SpillFile,
+// stopping at the first error.
+func (r *Result) EachProduct(fn func(Product) error) error {
+	for _, p := range r.Products {
+		if err := fn(p); err != nil {
+			return err
+		}
+	}
+	if r.SpillFile == "" {
+		return nil
+	}
+
+	f, err := os.Open(r.SpillFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for dec.More() {
+		var p Product
+		if err := dec.Decode(&p); err != nil {
+			return err
+		}
+		if err := fn(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close removes SpillFile, if any. r keeps its in-memory products.
+func (r *Result) Close() error {
+	if r.SpillFile == "" {
+		return nil
+	}
+	err := os.Remove(r.SpillFile)
+	r.SpillFile = ""
+	return err
+}
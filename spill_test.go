@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/metrics"
+	"slices"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSpillAfter(t *testing.T) {
+	catalog := testCatalog(5000)
+	cfg := testConfig(catalog)
+	cfg.SpillAfter = 1000
+	cfg.SpillDir = t.TempDir()
+	cfg.SpotChecks = 5
+	res, err := testScraper(t, cfg).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Products) != cfg.SpillAfter {
+		t.Errorf("%d products in memory, want %d", len(res.Products), cfg.SpillAfter)
+	}
+	// The spot checks find the products in the spill file too.
+	if len(res.SpotChecks) != cfg.SpotChecks || res.SpotMisses != 0 {
+		t.Errorf("%d spot checks, %d misses, want %d and none", len(res.SpotChecks), res.SpotMisses, cfg.SpotChecks)
+	}
+	seen := map[ProductID]bool{}
+	err = res.EachProduct(func(p Product) error {
+		if seen[p.ID] {
+			return fmt.Errorf("product %s twice", p.ID)
+		}
+		seen[p.ID] = true
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != catalog.Valid() {
+		t.Errorf("%d products in all, want %d", len(seen), catalog.Valid())
+	}
+
+	spilled := res.SpillFile
+	if err := res.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(spilled); !os.IsNotExist(err) {
+		t.Errorf("spill file left after Close: %v", err)
+	}
+}
+
+// TestSpillRuns collects a catalog in runs of 10, then all of it again,
+// long after the runs were written, some of it left out by the filter or
+// with invalid prices: once the runs are merged, each product counts once.
+func TestSpillRuns(t *testing.T) {
+	catalog := testCatalog(2000)
+	batches := responses(catalog)
+	for i := len(batches) - 1; i >= 0; i-- {
+		batches = append(batches, batches[i])
+	}
+	c := make(chan foundProducts, len(batches))
+	for _, b := range batches {
+		c <- b
+	}
+	close(c)
+	denied := func(p Product) bool { return p.ID[len(p.ID)-1] == '7' }
+	var valid, invalid, filtered int
+	for _, p := range catalog.products {
+		switch {
+		case denied(p):
+			filtered++
+		case validPrice(p):
+			valid++
+		default:
+			invalid++
+		}
+	}
+
+	dir := t.TempDir()
+	out := &spillSink{dir: dir}
+	spill := &spiller{dir: dir, runSize: 10, out: out, fail: func(err error) { t.Error(err) }}
+	sink := &countingSink{ids: map[ProductID]int{}}
+	g, ctx := newGroup(context.Background())
+	pl := getProductsList(ctx, c, 0, 0, func() {}, func(p Product) bool { return !denied(p) }, sink, true, spill, g)
+	g.Wait()
+	if err := out.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pl.products) != 10 || pl.collected != valid || pl.filtered != filtered || len(pl.invalid) != invalid {
+		t.Errorf("%d in memory, %d collected, %d filtered out, %d invalid, want 10, %d, %d and %d",
+			len(pl.products), pl.collected, pl.filtered, len(pl.invalid), valid, filtered, invalid)
+	}
+	for id, n := range sink.ids {
+		if n != 1 {
+			t.Errorf("sink got %s %d times", id, n)
+		}
+	}
+	if len(sink.ids) != valid {
+		t.Errorf("sink got %d products, want %d", len(sink.ids), valid)
+	}
+	counted := 0
+	for _, n := range pl.histogram.counts {
+		counted += n
+	}
+	if counted != valid {
+		t.Errorf("histogram counts %d products, want %d", counted, valid)
+	}
+
+	res := &Result{Products: pl.products, SpillFile: out.path()}
+	defer res.Close()
+	seen := map[ProductID]bool{}
+	var spilled []ProductID
+	err := res.EachProduct(func(p Product) error {
+		if seen[p.ID] {
+			return fmt.Errorf("product %s twice", p.ID)
+		}
+		seen[p.ID] = true
+		if len(seen) > len(res.Products) {
+			spilled = append(spilled, p.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != valid || !slices.IsSorted(spilled) {
+		t.Errorf("%d products in all, spilled sorted %v, want %d in ID order", len(seen), slices.IsSorted(spilled), valid)
+	}
+	// Only the merged file is left of the runs.
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 1 {
+		t.Errorf("%d files left in the spill directory, want 1: %v", len(entries), err)
+	}
+}
+
+// TestHistogramCoarsen counts more intervals than a histogram has buckets,
+// then narrower ones within them: it merges neighbours, counting all.
+func TestHistogramCoarsen(t *testing.T) {
+	h := histogram{counts: map[Interval]int{}, max: 8}
+	total := 0
+	for i := range 100 {
+		h.add(Interval{float32(i), float32(i) + 0.5}, i)
+		total += i
+	}
+	for i := range 100 {
+		h.add(Interval{float32(i) + 0.1, float32(i) + 0.2}, 1)
+		total++
+	}
+	if len(h.counts) > h.max {
+		t.Errorf("%d buckets, want at most %d", len(h.counts), h.max)
+	}
+	counted := 0
+	for _, n := range h.counts {
+		counted += n
+	}
+	if counted != total {
+		t.Errorf("counts sum to %d, want %d", counted, total)
+	}
+	for k := 1; k < len(h.buckets); k++ {
+		if h.buckets[k][0] <= h.buckets[k-1][1] {
+			t.Errorf("buckets %v and %v overlap", h.buckets[k-1], h.buckets[k])
+		}
+	}
+}
+
+// BenchmarkSpill reports the heap a run peaks at, in MB, and keeps, per
+// product, with all the products in memory and with all but a thousand
+// spilled: spilling, the peak stays about the same whatever the catalog's
+// size. The peak is that of the heap live after each collection, as the
+// garbage of the responses would swamp the rest, and the catalog is
+// generated so as not to take any.
+func BenchmarkSpill(b *testing.B) {
+	for _, n := range []int{200_000, 2_000_000} {
+		catalog := generatedCatalog(n)
+		for _, spillAfter := range []int{0, 1000} {
+			b.Run(fmt.Sprintf("products=%d/spill-after=%d", n, spillAfter), func(b *testing.B) {
+				for range b.N {
+					cfg := testConfig(catalog)
+					cfg.SpillAfter = spillAfter
+					cfg.SpillDir = b.TempDir()
+					runtime.GC()
+					base := liveHeap()
+					stop := make(chan struct{})
+					peak := make(chan uint64)
+					go func() {
+						var peakLive uint64
+						ticker := time.NewTicker(10 * time.Millisecond)
+						defer ticker.Stop()
+						for {
+							peakLive = max(peakLive, liveHeap())
+							select {
+							case <-ticker.C:
+							case <-stop:
+								peak <- peakLive
+								return
+							}
+						}
+					}()
+					res, err := testScraper(b, cfg).Run(context.Background())
+					if err != nil {
+						b.Fatal(err)
+					}
+					close(stop)
+					if res.Collected != n {
+						b.Fatalf("%d products collected, want %d", res.Collected, n)
+					}
+					b.ReportMetric(float64(<-peak-base)/(1<<20), "peak-MB")
+					runtime.GC()
+					b.ReportMetric(float64(liveHeap()-base)/float64(n), "kept-B/product")
+					runtime.KeepAlive(res)
+					res.Close()
+				}
+			})
+		}
+	}
+}
+
+// generatedCatalog serves its number of products, at distinct prices, like
+// a Catalog but making them up for each request instead of keeping them.
+type generatedCatalog int
+
+func (n generatedCatalog) product(i int) Product {
+	id := strconv.Itoa(i + 1)
+	return Product{ID: ProductID(id), Name: "p" + id, Price: float32(i+1) * (maxPrice / 2) / float32(n)}
+}
+
+func (n generatedCatalog) Do(req *http.Request) (*http.Response, error) {
+	q := req.URL.Query()
+	lo, err := strconv.ParseFloat(q.Get(minPriceParam), 32)
+	if err != nil {
+		return simulatedResponse(req, http.StatusBadRequest, nil), nil
+	}
+	hi, err := strconv.ParseFloat(q.Get(maxPriceParam), 32)
+	if err != nil {
+		return simulatedResponse(req, http.StatusBadRequest, nil), nil
+	}
+	from := sort.Search(int(n), func(i int) bool { return n.product(i).Price >= float32(lo) })
+	to := sort.Search(int(n), func(i int) bool { return n.product(i).Price >= float32(hi) })
+	res := Response{Total: to - from, Count: min(to-from, apiLimit)}
+	for i := from; i < from+res.Count; i++ {
+		res.Products = append(res.Products, n.product(i))
+	}
+	body, err := json.Marshal(res)
+	if err != nil {
+		return nil, err
+	}
+	return simulatedResponse(req, http.StatusOK, body), nil
+}
+
+// liveHeap is the heap found live by the last garbage collection.
+func liveHeap() uint64 {
+	sample := []metrics.Sample{{Name: "/gc/heap/live:bytes"}}
+	metrics.Read(sample)
+	return sample[0].Value.Uint64()
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"cmp"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SplitNode is one interval of the run and what became of it. Intervals
+// deeper than Config.SplitTreeDepth aren't recorded; their requests and
+// products are folded into their ancestor at that depth instead.
+type SplitNode struct {
+	Interval Interval      `json:"interval"`
+	Status   string        `json:"status"`
+	Count    int           `json:"count"` // products of the last response
+	Attempts int           `json:"attempts"`
+	Latency  time.Duration `json:"latency_ns"` // of all attempts
+	Children []*SplitNode  `json:"children,omitempty"`
+
+	Folded         int `json:"folded,omitempty"`
+	FoldedRequests int `json:"folded_requests,omitempty"`
+	FoldedProducts int `json:"folded_products,omitempty"`
+}
+
+// Requests counts the requests made for n's subtree.
+func (n *SplitNode) Requests() int {
+	total := n.Attempts + n.FoldedRequests
+	for _, c := range n.Children {
+		total += c.Requests()
+	}
+	return total
+}
+
+const (
+	nodePending   = "pending"
+	nodeCompleted = "completed"
+	nodeSplit     = "split"
+	nodeFailed    = "failed"
+	nodeUncovered = "uncovered"
+	nodeCancelled = "cancelled"
+)
+
+type treeRef struct {
+	node   *SplitNode
+	depth  int
+	folded bool // node is an ancestor standing in for the interval
+}
+
+// splitTree records the SplitNodes of a run. A nil *splitTree records
+// nothing.
+type splitTree struct {
+	maxDepth  int
+	precision int
+	roots     []*SplitNode
+	nodes     map[string]treeRef // by intervalKey
+	mu        sync.Mutex
+}
+
+func newSplitTree(maxDepth, precision int) *splitTree {
+	return &splitTree{maxDepth: maxDepth, precision: precision, nodes: map[string]treeRef{}}
+}
+
+// ref finds the node of i, making it a root if it is new. Called with mu
+// held.
+func (t *splitTree) ref(i Interval) treeRef {
+	key := intervalKey(i, t.precision)
+	r, ok := t.nodes[key]
+	if !ok {
+		r = treeRef{node: &SplitNode{Interval: i, Status: nodePending}}
+		t.roots = append(t.roots, r.node)
+		t.nodes[key] = r
+	}
+	return r
+}
+
+func (t *splitTree) attempt(i Interval, latency time.Duration) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	r := t.ref(i)
+	if r.folded {
+		r.node.FoldedRequests++
+		return
+	}
+	r.node.Attempts++
+	r.node.Latency += latency
+}
+
+func (t *splitTree) finish(i Interval, status string, count int) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	r := t.ref(i)
+	if r.folded {
+		r.node.FoldedProducts += count
+		return
+	}
+	r.node.Status = status
+	r.node.Count = count
+}
+
+func (t *splitTree) split(parent Interval, count int, children [2]Interval) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	r := t.ref(parent)
+	if !r.folded {
+		r.node.Status = nodeSplit
+		r.node.Count = count
+	}
+	for _, c := range children {
+		if r.folded || r.depth+1 > t.maxDepth {
+			r.node.Folded++
+			t.nodes[intervalKey(c, t.precision)] = treeRef{node: r.node, depth: r.depth, folded: true}
+			continue
+		}
+		n := &SplitNode{Interval: c, Status: nodePending}
+		r.node.Children = append(r.node.Children, n)
+		t.nodes[intervalKey(c, t.precision)] = treeRef{node: n, depth: r.depth + 1}
+	}
+}
+
+// writeSplitTree saves roots to path as JSON.
+func writeSplitTree(path string, roots []*SplitNode) error {
+	data, err := json.MarshalIndent(roots, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// readSplitTree loads a tree saved by writeSplitTree.
+func readSplitTree(path string) ([]*SplitNode, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var roots []*SplitNode
+	if err := json.Unmarshal(data, &roots); err != nil {
+		return nil, err
+	}
+	return roots, nil
+}
+
+// planFromTree returns the leaves of a previous run's tree, which must
+// partition root exactly, as the plan of a new run. saved is how many
+// requests the previous run spent splitting down to them.
+func planFromTree(roots []*SplitNode, root Interval, precision int) (plan []Interval, saved int, err error) {
+	var walk func(n *SplitNode)
+	walk = func(n *SplitNode) {
+		if len(n.Children) == 0 {
+			plan = append(plan, n.Interval)
+			return
+		}
+		saved += n.Attempts
+		for _, c := range n.Children {
+			walk(c)
+		}
+	}
+	for _, r := range roots {
+		walk(r)
+	}
+
+	slices.SortFunc(plan, func(a, b Interval) int { return cmp.Compare(a[0], b[0]) })
+	at := root[0]
+	for _, i := range plan {
+		switch {
+		case sameBound(i[0], at, precision):
+		case i[0] < at:
+			return nil, 0, fmt.Errorf("plan intervals overlap at %v", i[0])
+		default:
+			return nil, 0, fmt.Errorf("plan has a gap [%v, %v)", at, i[0])
+		}
+		at = i[1]
+	}
+	if !sameBound(at, root[1], precision) {
+		return nil, 0, fmt.Errorf("plan covers [%v, %v), want %v", root[0], at, root)
+	}
+	return plan, saved, nil
+}
+
+// SplitLeaf is an interval of the split tree that wasn't split further,
+// Depth splits below its top-level interval. A leaf at Config.SplitTreeDepth
+// may stand for Folded deeper intervals, whose products it counts.
+type SplitLeaf struct {
+	Interval Interval `json:"interval"`
+	Depth    int      `json:"depth"`
+	Products int      `json:"products"`
+	Status   string   `json:"status"`
+	Folded   int      `json:"folded,omitempty"`
+}
+
+// SplitLeaves lists the leaves of SplitTree in price order, e.g. to see
+// where the catalog is dense. It is empty without Config.SplitTreeDepth.
+func (r *Result) SplitLeaves() []SplitLeaf {
+	var leaves []SplitLeaf
+	var walk func(n *SplitNode, depth int)
+	walk = func(n *SplitNode, depth int) {
+		if len(n.Children) > 0 {
+			for _, c := range n.Children {
+				walk(c, depth+1)
+			}
+			return
+		}
+		leaf := SplitLeaf{Interval: n.Interval, Depth: depth, Products: n.Count, Status: n.Status, Folded: n.Folded}
+		if n.Folded > 0 {
+			leaf.Products = n.FoldedProducts
+		}
+		leaves = append(leaves, leaf)
+	}
+	for _, root := range r.SplitTree {
+		walk(root, 0)
+	}
+	slices.SortFunc(leaves, func(a, b SplitLeaf) int { return cmp.Compare(a.Interval[0], b.Interval[0]) })
+	return leaves
+}
+
+// writeSplitLeaves writes leaves as CSV with a header, or as NDJSON.
+func writeSplitLeaves(w io.Writer, leaves []SplitLeaf, asCSV bool) error {
+	if !asCSV {
+		enc := json.NewEncoder(w)
+		for _, l := range leaves {
+			if err := enc.Encode(l); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"min", "max", "depth", "products", "status", "folded"})
+	for _, l := range leaves {
+		cw.Write([]string{
+			strconv.FormatFloat(float64(l.Interval[0]), 'f', -1, 32),
+			strconv.FormatFloat(float64(l.Interval[1]), 'f', -1, 32),
+			strconv.Itoa(l.Depth),
+			strconv.Itoa(l.Products),
+			l.Status,
+			strconv.Itoa(l.Folded),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// renderHeaviest writes the n subtrees that took the most requests, down
+// to a few levels, heaviest children first.
+func renderHeaviest(w io.Writer, roots []*SplitNode, n int) {
+	byRequests := func(a, b *SplitNode) int { return cmp.Compare(b.Requests(), a.Requests()) }
+
+	var render func(node *SplitNode, level int)
+	render = func(node *SplitNode, level int) {
+		fmt.Fprintf(w, "%s%v %s: %d requests, %d products",
+			strings.Repeat("  ", level), node.Interval, node.Status, node.Requests(), node.Count)
+		if node.Folded > 0 {
+			fmt.Fprintf(w, " (+%d folded intervals, %d products)", node.Folded, node.FoldedProducts)
+		}
+		fmt.Fprintln(w)
+		if level == 3 {
+			return
+		}
+		children := slices.Clone(node.Children)
+		slices.SortFunc(children, byRequests)
+		for _, c := range children {
+			render(c, level+1)
+		}
+	}
+
+	roots = slices.Clone(roots)
+	slices.SortFunc(roots, byRequests)
+	for _, r := range roots[:min(n, len(roots))] {
+		render(r, 0)
+	}
+}
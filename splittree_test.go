@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"math"
+	"slices"
+	"testing"
+)
+
+func TestSplitLeaves(t *testing.T) {
+	catalog := skewedCatalog(20_000)
+	for _, depth := range []int{64, 1} {
+		cfg := testConfig(catalog)
+		cfg.MaxWorkers = 4
+		cfg.SplitTreeDepth = depth
+		res, err := testScraper(t, cfg).Run(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaves := res.SplitLeaves()
+		if len(leaves) == 0 {
+			t.Fatalf("depth %d: no leaves", depth)
+		}
+
+		// The leaves partition the whole range, and hold every product.
+		if leaves[0].Interval[0] != 0 || leaves[len(leaves)-1].Interval[1] != maxPrice {
+			t.Errorf("depth %d: leaves span %v to %v", depth, leaves[0].Interval[0], leaves[len(leaves)-1].Interval[1])
+		}
+		products, folded := 0, 0
+		for i, l := range leaves {
+			if i > 0 && l.Interval[0] != leaves[i-1].Interval[1] {
+				t.Errorf("depth %d: gap or overlap between %v and %v", depth, leaves[i-1].Interval, l.Interval)
+			}
+			// One at the depth limit was split into the intervals it folds.
+			if (l.Status != nodeCompleted && (l.Status != nodeSplit || l.Folded == 0)) || l.Depth > depth {
+				t.Errorf("depth %d: leaf %v %s at depth %d", depth, l.Interval, l.Status, l.Depth)
+			}
+			products += l.Products
+			folded += l.Folded
+		}
+		if products != len(res.Products) {
+			t.Errorf("depth %d: leaves hold %d products, %d collected", depth, products, len(res.Products))
+		}
+		if depth == 1 && folded == 0 {
+			t.Error("depth 1: nothing folded")
+		}
+
+		// Each split halves its interval: a leaf is its root's width over
+		// two to its depth.
+		for _, l := range leaves {
+			k := slices.IndexFunc(res.SplitTree, func(n *SplitNode) bool {
+				return n.Interval[0] <= l.Interval[0] && l.Interval[1] <= n.Interval[1]
+			})
+			if k < 0 {
+				t.Fatalf("depth %d: leaf %v under no root", depth, l.Interval)
+			}
+			want := float64(width(res.SplitTree[k].Interval)) / math.Pow(2, float64(l.Depth))
+			if got := float64(width(l.Interval)); math.Abs(got-want) > want*1e-3+0.01 {
+				t.Errorf("depth %d: leaf %v at depth %d is %v wide, want %v", depth, l.Interval, l.Depth, got, want)
+			}
+		}
+
+		// Written out, one line or row per leaf.
+		var buf bytes.Buffer
+		if err := writeSplitLeaves(&buf, leaves, false); err != nil {
+			t.Fatal(err)
+		}
+		var decoded []SplitLeaf
+		for dec := json.NewDecoder(&buf); dec.More(); {
+			var l SplitLeaf
+			if err := dec.Decode(&l); err != nil {
+				t.Fatal(err)
+			}
+			decoded = append(decoded, l)
+		}
+		if !slices.Equal(decoded, leaves) {
+			t.Errorf("depth %d: NDJSON leaves read back differently", depth)
+		}
+		buf.Reset()
+		if err := writeSplitLeaves(&buf, leaves, true); err != nil {
+			t.Fatal(err)
+		}
+		rows, err := csv.NewReader(&buf).ReadAll()
+		if err != nil || len(rows) != len(leaves)+1 {
+			t.Errorf("depth %d: %d CSV rows (err %v), want a header and %d", depth, len(rows), err, len(leaves))
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"slices"
+)
+
+// spotCheckSlices is the width of a spot check, as a fraction of the price
+// range: narrow enough to land in a single interval of most plans.
+const spotCheckSlices = 1000
+
+// SpotCheck is a narrow interval requested again after the run, see
+// Config.SpotChecks. Missing lists the products it returned that the run
+// didn't collect.
+type SpotCheck struct {
+	Interval Interval    `json:"interval"`
+	Products int         `json:"products"`
+	Missing  []ProductID `json:"missing,omitempty"`
+	Err      string      `json:"error,omitempty"`
+}
+
+// recordSpotIDs remembers the products found, for Config.SpotChecks with
+// Config.StatsOnly. The products kept are looked up in the Result
+// instead, see settleSpotChecks.
+func (s *Scraper) recordSpotIDs(products []Product) {
+	if s.cfg.SpotChecks <= 0 || !s.cfg.StatsOnly {
+		return
+	}
+	s.spotMu.Lock()
+	defer s.spotMu.Unlock()
+	if s.spotIDs == nil {
+		s.spotIDs = map[ProductID]struct{}{}
+	}
+	for _, p := range products {
+		s.spotIDs[p.ID] = struct{}{}
+	}
+}
+
+// spotCheck requests Config.SpotChecks random narrow intervals, within
+// what the run covered, listing every product they return as Missing
+// until settleSpotChecks looks them up among those found. A miss is a gap
+// the splitting left, e.g. from rounding the bounds, or a product the API
+// left out of a response.
+func (s *Scraper) spotCheck(ctx context.Context) []SpotCheck {
+	root := s.cfg.priceRange()
+	w := width(root) / spotCheckSlices
+	rng := rand.New(rand.NewSource(s.cfg.Seed))
+
+	var checks []SpotCheck
+	// Intervals the run didn't cover are redrawn, up to a point.
+	for tries := 0; len(checks) < s.cfg.SpotChecks && tries < 10*s.cfg.SpotChecks; tries++ {
+		lo := root[0] + rng.Float32()*(width(root)-w)
+		interval := Interval{lo, lo + w}
+		if len(s.covered.remainder(interval)) > 0 {
+			continue
+		}
+		if ctx.Err() != nil || !s.acquire(ctx) {
+			break
+		}
+		check := SpotCheck{Interval: interval}
+		res, err := s.request(ctx, interval, pageRef{}, nil)
+		if err != nil {
+			check.Err = err.Error()
+			checks = append(checks, check)
+			continue
+		}
+		products := slices.DeleteFunc(res.Products, func(p Product) bool {
+			return !validPrice(p) || p.Price < interval[0] || p.Price > interval[1]
+		})
+		check.Products = len(products)
+		for _, p := range products {
+			check.Missing = append(check.Missing, p.ID)
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+// settleSpotChecks drops from the Missing products of checks those res
+// has, spilled ones included, or Config.AllowIDs and Config.DenyIDs left
+// out. With Config.StatsOnly, res has none: they are looked up among the
+// IDs recordSpotIDs kept.
+func (s *Scraper) settleSpotChecks(checks []SpotCheck, res *Result) error {
+	missing := map[ProductID]bool{}
+	for _, c := range checks {
+		for _, id := range c.Missing {
+			missing[id] = true
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	filter := s.cfg.idFilter()
+	found := func(p Product) error {
+		if missing[p.ID] {
+			missing[p.ID] = false
+		}
+		return nil
+	}
+	s.spotMu.Lock()
+	for id := range missing {
+		_, seen := s.spotIDs[id]
+		missing[id] = !seen && (filter == nil || filter(Product{ID: id}))
+	}
+	s.spotMu.Unlock()
+	if err := res.EachProduct(found); err != nil {
+		return err
+	}
+	for _, p := range res.InvalidPrices {
+		found(p)
+	}
+
+	for i, c := range checks {
+		checks[i].Missing = slices.DeleteFunc(c.Missing, func(id ProductID) bool { return !missing[id] })
+		if len(checks[i].Missing) == 0 {
+			checks[i].Missing = nil
+		} else {
+			s.cfg.Logger.Warn("spot check found products the run missed", "interval", c.Interval, "missing", len(checks[i].Missing))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+// TestSpotCheckGap scrapes an API leaving the products in [40000, 60000)
+// out of every response wider than a spot check, counts included: the run
+// looks complete, the spot checks landing in the gap find them missing.
+func TestSpotCheckGap(t *testing.T) {
+	products := make([]Product, 20_000)
+	for i := range products {
+		products[i] = Product{ID: ProductID(strconv.Itoa(i + 1)), Price: float32(i+1) * maxPrice / 20_001}
+	}
+	catalog := NewCatalog(products, CatalogParams{})
+	hidden := func(p Product) bool { return p.Price >= 40_000 && p.Price < 60_000 }
+
+	for _, gap := range []bool{false, true} {
+		client := Doer(catalog)
+		if gap {
+			client = DoerFunc(func(req *http.Request) (*http.Response, error) {
+				resp, err := catalog.Do(req)
+				q := req.URL.Query()
+				lo, _ := strconv.ParseFloat(q.Get(minPriceParam), 32)
+				hi, _ := strconv.ParseFloat(q.Get(maxPriceParam), 32)
+				if err != nil || hi-lo <= 2*float64(maxPrice)/spotCheckSlices {
+					return resp, err
+				}
+				var res Response
+				if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+					return nil, err
+				}
+				n := len(res.Products)
+				res.Products = slices.DeleteFunc(res.Products, hidden)
+				res.Count -= n - len(res.Products)
+				res.Total -= n - len(res.Products)
+				body, err := json.Marshal(res)
+				if err != nil {
+					return nil, err
+				}
+				return simulatedResponse(req, http.StatusOK, body), nil
+			})
+		}
+		cfg := testConfig(client)
+		cfg.SpotChecks = 20
+		cfg.Seed = 1
+		res, err := testScraper(t, cfg).Run(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(res.SpotChecks) != cfg.SpotChecks {
+			t.Fatalf("gap %v: %d spot checks, want %d", gap, len(res.SpotChecks), cfg.SpotChecks)
+		}
+		missing := 0
+		for _, c := range res.SpotChecks {
+			if c.Err != "" {
+				t.Errorf("gap %v: spot check %v: %s", gap, c.Interval, c.Err)
+			}
+			for _, id := range c.Missing {
+				i, err := strconv.Atoi(string(id))
+				if err != nil || !hidden(products[i-1]) {
+					t.Errorf("gap %v: %s reported missing, it wasn't hidden", gap, id)
+				}
+			}
+			missing += len(c.Missing)
+		}
+		if missing != res.SpotMisses {
+			t.Errorf("gap %v: %d spot misses, the checks list %d", gap, res.SpotMisses, missing)
+		}
+		if !gap && res.SpotMisses != 0 {
+			t.Errorf("no gap: %d spot misses", res.SpotMisses)
+		}
+		if gap && (res.SpotMisses == 0 || !res.Complete) {
+			t.Errorf("gap: complete %v, %d spot misses, want a complete run and some", res.Complete, res.SpotMisses)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"runtime/debug"
+)
+
+// defaultUserAgent identifies the scraper by its module version or, for
+// a local build, its VCS revision.
+func defaultUserAgent() string {
+	version := "dev"
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "go-scraper-concept/" + version
+	}
+	if info.Main.Version != "" && info.Main.Version != "(devel)" {
+		version = info.Main.Version
+	}
+	for _, s := range info.Settings {
+		if s.Key == "vcs.revision" && version == "dev" && len(s.Value) >= 7 {
+			version = s.Value[:7]
+		}
+	}
+	return "go-scraper-concept/" + version
+}
+
+// userAgent sets the User-Agent of every request to Config.UserAgent, or
+// to one of Config.UserAgents drawn from Config.Seed.
+func (s *Scraper) userAgent(next Doer) Doer {
+	return DoerFunc(func(req *http.Request) (*http.Response, error) {
+		ua := s.cfg.UserAgent
+		if len(s.cfg.UserAgents) > 0 {
+			s.uaMu.Lock()
+			ua = s.cfg.UserAgents[s.uaRng.Intn(len(s.cfg.UserAgents))]
+			s.uaMu.Unlock()
+		}
+		req = req.Clone(req.Context())
+		req.Header.Set("User-Agent", ua)
+		return next.Do(req)
+	})
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// scrapeUserAgents scrapes catalog with one worker, returning the
+// User-Agent of every request in order.
+func scrapeUserAgents(t *testing.T, catalog *Catalog, configure func(*Config)) []string {
+	t.Helper()
+	var mu sync.Mutex
+	var agents []string
+	cfg := testConfig(DoerFunc(func(req *http.Request) (*http.Response, error) {
+		mu.Lock()
+		agents = append(agents, req.Header.Get("User-Agent"))
+		mu.Unlock()
+		return catalog.Do(req)
+	}))
+	cfg.MaxWorkers = 1
+	configure(cfg)
+	if _, err := testScraper(t, cfg).Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return agents
+}
+
+func TestUserAgent(t *testing.T) {
+	catalog := testCatalog(5000)
+
+	for _, ua := range scrapeUserAgents(t, catalog, func(*Config) {}) {
+		if !strings.HasPrefix(ua, "go-scraper-concept/") {
+			t.Fatalf("default User-Agent %q", ua)
+		}
+	}
+	for _, ua := range scrapeUserAgents(t, catalog, func(cfg *Config) { cfg.UserAgent = "acme/1.0" }) {
+		if ua != "acme/1.0" {
+			t.Fatalf("User-Agent %q, want acme/1.0", ua)
+		}
+	}
+
+	// Rotation draws the same agents, in the same order, from a seed.
+	pool := []string{"a/1", "b/2", "c/3"}
+	rotate := func(seed int64) []string {
+		return scrapeUserAgents(t, catalog, func(cfg *Config) {
+			cfg.UserAgents = pool
+			cfg.Seed = seed
+		})
+	}
+	first, again, other := rotate(7), rotate(7), rotate(8)
+	if !slices.Equal(first, again) {
+		t.Errorf("seed 7 drew %q, then %q", first, again)
+	}
+	if slices.Equal(first, other) {
+		t.Errorf("seeds 7 and 8 both drew %q", first)
+	}
+	used := map[string]bool{}
+	for _, ua := range first {
+		if !slices.Contains(pool, ua) {
+			t.Errorf("User-Agent %q, not from %q", ua, pool)
+		}
+		used[ua] = true
+	}
+	if len(used) < 2 {
+		t.Errorf("only %v used over %d requests", used, len(first))
+	}
+
+	// The request log tells which one was sent.
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, nil))
+	scrapeUserAgents(t, testCatalog(100), func(cfg *Config) {
+		cfg.UserAgent = "acme/1.0"
+		cfg.Middleware = []Middleware{Logging(logger, nil)}
+	})
+	if !strings.Contains(logs.String(), "user_agent=acme/1.0") {
+		t.Errorf("User-Agent not logged:\n%s", logs.String())
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+var errInvalidResponse = errors.New("response failed validation")
+
+// PricesWithin is a Config.ValidateInterval checking that every product
+// with a valid price is priced within the interval that returned it, to
+// within precision decimals, catching an API that ignores the price
+// params.
+func PricesWithin(precision int) func(Interval, *Response) error {
+	return func(i Interval, res *Response) error {
+		for _, p := range res.Products {
+			if !validPrice(p) {
+				continue
+			}
+			if (p.Price < i[0] && !sameBound(p.Price, i[0], precision)) || (p.Price > i[1] && !sameBound(p.Price, i[1], precision)) {
+				return fmt.Errorf("product %s priced %v outside of %v", p.ID, p.Price, i)
+			}
+		}
+		return nil
+	}
+}
+
+// validate runs Config.ValidateInterval on a response to interval.
+func (s *Scraper) validate(interval Interval, res *Response) error {
+	if s.cfg.ValidateInterval == nil {
+		return nil
+	}
+	if err := s.cfg.ValidateInterval(interval, res); err != nil {
+		return fmt.Errorf("%w: %w", errInvalidResponse, err)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestPricesWithin(t *testing.T) {
+	validate := PricesWithin(2)
+	for _, tc := range []struct {
+		name   string
+		prices []float32
+		ok     bool
+	}{
+		{"within", []float32{10, 15.5, 20}, true},
+		{"below", []float32{15, 9.99}, false},
+		{"above", []float32{20.01}, false},
+		{"on the bounds to the cent", []float32{9.999, 20.004}, true},
+		{"invalid prices", []float32{0, float32(math.NaN())}, true},
+	} {
+		res := &Response{}
+		for _, p := range tc.prices {
+			res.Products = append(res.Products, Product{ID: "1", Price: p})
+		}
+		if err := validate(Interval{10, 20}, res); (err == nil) != tc.ok {
+			t.Errorf("%s: %v", tc.name, err)
+		}
+	}
+}
+
+// TestValidateInterval scrapes an API returning a stray product with the
+// interval starting at 50000: the interval fails validation, or passes
+// once retried if the stray one went away.
+func TestValidateInterval(t *testing.T) {
+	catalog := testCatalog(3000)
+	for _, retry := range []bool{false, true} {
+		var strays atomic.Int64
+		cfg := testConfig(DoerFunc(func(req *http.Request) (*http.Response, error) {
+			resp, err := catalog.Do(req)
+			if err != nil || req.URL.Query().Get(minPriceParam) != "50000" || retry && strays.Load() > 0 {
+				return resp, err
+			}
+			strays.Add(1)
+			var res Response
+			if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+				return nil, err
+			}
+			res.Products = append(res.Products, Product{ID: "stray", Price: 1})
+			res.Count++
+			res.Total++
+			body, err := json.Marshal(res)
+			if err != nil {
+				return nil, err
+			}
+			return simulatedResponse(req, http.StatusOK, body), nil
+		}))
+		cfg.Intervals = []Interval{{0, 50_000}, {50_000, maxPrice}}
+		cfg.ValidateInterval = PricesWithin(2)
+		cfg.RetryInvalidIntervals = retry
+		res, err := testScraper(t, cfg).Run(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range res.Products {
+			if p.ID == "stray" {
+				t.Errorf("retry %v: stray product collected", retry)
+			}
+		}
+		if retry {
+			if !res.Complete || len(res.Products) != catalog.Valid() {
+				t.Errorf("retry: complete %v with %d products, want %d", res.Complete, len(res.Products), catalog.Valid())
+			}
+			continue
+		}
+		if len(res.Failed) != 1 || res.Failed[0].Interval != cfg.Intervals[1] ||
+			!errors.Is(res.Failed[0].Err, errInvalidResponse) || !strings.Contains(res.Failed[0].Err.Error(), "stray") {
+			t.Errorf("failed %v, want %v flagged for the stray product", res.Failed, cfg.Intervals[1])
+		}
+	}
+}